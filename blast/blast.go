@@ -1,11 +1,11 @@
 package blast
 
 import (
+	"errors"
 	"fmt"
 	"github.com/martinghunt/tnahelper/utils"
 	"github.com/shenwei356/xopen"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +14,32 @@ import (
 	"strings"
 )
 
+// ErrBlastFailed is returned, wrapped in a *BlastError, when makeblastdb
+// or blast fails
+var ErrBlastFailed = errors.New("blast failed")
+
+// ErrBadBlastOutput is returned when a blast output file cannot be parsed
+var ErrBadBlastOutput = errors.New("bad blast output")
+
+// BlastError has the output of a blast program that failed
+type BlastError struct {
+	Program string
+	Output  string
+	Err     error
+}
+
+func (e *BlastError) Error() string {
+	return fmt.Sprintf("%v: error running %v: %v\n%v", ErrBlastFailed, e.Program, e.Err, e.Output)
+}
+
+func (e *BlastError) Is(target error) bool {
+	return target == ErrBlastFailed
+}
+
+func (e *BlastError) Unwrap() error {
+	return e.Err
+}
+
 type AlnBlock struct {
 	qstart  int
 	qend    int
@@ -22,21 +48,21 @@ type AlnBlock struct {
 	alnType int
 }
 
-func ParseBlastFile(infile string, outfile string, blastType string) {
+func ParseBlastFile(infile string, outfile string, blastType string) (err error) {
 	reader, err := xopen.Ropen(infile)
 	if err != nil {
-		log.Fatalf("Error opening blast file %v: %v", infile, err)
+		return fmt.Errorf("error opening blast file %v: %w", infile, err)
+	}
+	defer reader.Close()
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening blast file for writing %v: %w", outfile, err)
 	}
 	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", infile, err)
+		if closeErr := fout.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error closing file %v: %w", outfile, closeErr)
 		}
 	}()
-	fout, errOut := xopen.Wopen(outfile)
-	if errOut != nil {
-		log.Fatalf("Error opening blast file for writing %v: %v", outfile, errOut)
-	}
-	defer fout.Close()
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -45,11 +71,13 @@ func ParseBlastFile(infile string, outfile string, blastType string) {
 				break
 			}
 
-			log.Fatalf("read file line error: %v", err)
-			return
+			return fmt.Errorf("error reading file %v: %w", infile, err)
 		}
 
 		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 9 {
+			return fmt.Errorf("%w: expected 9 columns in file %v, got %d: %v", ErrBadBlastOutput, infile, len(fields), line)
+		}
 		// fields are:
 		// 0, 1 = ref, qry name
 		// 2 = percent identity
@@ -64,7 +92,7 @@ func ParseBlastFile(infile string, outfile string, blastType string) {
 		var qend, _ = strconv.Atoi(fields[4])
 		if qstart > qend {
 			if blastType == "blastn" {
-				log.Fatalf("Query start > end, and using blastn. Cannot continue\n%v", fields)
+				return fmt.Errorf("%w: query start > end, and using blastn. Cannot continue\n%v", ErrBadBlastOutput, fields)
 			}
 			fields[4], fields[3] = fields[3], fields[4]
 			fields[6], fields[5] = fields[5], fields[6]
@@ -82,7 +110,7 @@ func ParseBlastFile(infile string, outfile string, blastType string) {
 		for i := 1; i < len(fields[7]); i++ {
 			if fields[7][i] == '-' {
 				if fields[8][i] == '-' {
-					log.Fatalf("Error, both seqs have gap at same position. Cannot continue\n%v\n%v", fields[7], fields[8])
+					return fmt.Errorf("%w: both seqs have gap at same position. Cannot continue\n%v\n%v", ErrBadBlastOutput, fields[7], fields[8])
 				}
 				rpos++
 			} else if fields[8][i] == '-' {
@@ -122,9 +150,10 @@ func ParseBlastFile(infile string, outfile string, blastType string) {
 
 		fout.WriteString("]\n")
 	}
+	return nil
 }
 
-func RunBlast(workingDir string, binDir string, blastType string, sendUsageReport bool, extraOptions []string) {
+func RunBlast(workingDir string, binDir string, blastType string, sendUsageReport bool, extraOptions []string) error {
 	fmt.Println("Extra options:", extraOptions)
 	makeblastdb := filepath.Join(binDir, "makeblastdb")
 	blastProgram := filepath.Join(binDir, blastType)
@@ -135,14 +164,16 @@ func RunBlast(workingDir string, binDir string, blastType string, sendUsageRepor
 
 	tempDir, err := os.MkdirTemp("", "tna-blast-")
 	if err != nil {
-		log.Fatalf("Failed to create temporary dir: %v", err)
+		return fmt.Errorf("failed to create temporary dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 	fmt.Println("Working in temporary dir:", tempDir)
 
 	refToCopy := filepath.Join(workingDir, "g2.fa")
 	ref := filepath.Join(tempDir, "ref.fa")
-	utils.CopyFile(refToCopy, ref)
+	if err := utils.CopyFile(refToCopy, ref); err != nil {
+		return err
+	}
 	blastdb := filepath.Join(tempDir, "blast_db")
 	fmt.Println("Running makeblastdb", makeblastdb)
 	command := exec.Command(makeblastdb, "-dbtype", "nucl", "-in", ref, "-out", blastdb)
@@ -152,8 +183,7 @@ func RunBlast(workingDir string, binDir string, blastType string, sendUsageRepor
 	}
 	output, err := command.CombinedOutput()
 	if err != nil {
-		os.RemoveAll(tempDir)
-		log.Fatalf("Error running makeblastdb: %s\n%s", output, err)
+		return &BlastError{Program: "makeblastdb", Output: string(output), Err: err}
 	}
 	fmt.Printf("output: %s", output)
 
@@ -162,7 +192,9 @@ func RunBlast(workingDir string, binDir string, blastType string, sendUsageRepor
 	blast_out := filepath.Join(workingDir, "blast")
 	qryToCopy := filepath.Join(workingDir, "g1.fa")
 	qry := filepath.Join(tempDir, "qry.fa")
-	utils.CopyFile(qryToCopy, qry)
+	if err := utils.CopyFile(qryToCopy, qry); err != nil {
+		return err
+	}
 	var commandline = append([]string{"-db", blastdb, "-query", qry, "-out", blast_out_tmp, "-outfmt", "6 qseqid sseqid pident qstart qend sstart send qseq sseq"}, extraOptions...)
 	fmt.Println("Going to run this blast command:", blastProgram, strings.Join(commandline, " "))
 	command = exec.Command(blastProgram, commandline...)
@@ -172,10 +204,12 @@ func RunBlast(workingDir string, binDir string, blastType string, sendUsageRepor
 	}
 	output, err = command.CombinedOutput()
 	if err != nil {
-		os.RemoveAll(tempDir)
-		log.Fatalf("Error running blast: %s\n%s", output, err)
+		return &BlastError{Program: blastType, Output: string(output), Err: err}
 	}
 	fmt.Println("Finished running blast")
-	ParseBlastFile(blast_out_tmp, blast_out, blastType)
+	if err := ParseBlastFile(blast_out_tmp, blast_out, blastType); err != nil {
+		return err
+	}
 	fmt.Println("Tidied up temproary files")
+	return nil
 }
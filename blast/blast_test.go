@@ -1,9 +1,11 @@
 package blast
 
 import (
+	"errors"
 	"github.com/martinghunt/tnahelper/utils"
 	"github.com/stretchr/testify/require"
 	"github.com/udhos/equalfile"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -12,7 +14,7 @@ func TestParseBlastnFile(t *testing.T) {
 	infile := filepath.Join("blast_testdata", "parse_blastn.in")
 	outfile := "tmp.test.ParseBlastn"
 	utils.DeleteFileIfExists(outfile)
-	ParseBlastFile(infile, outfile, "blastn")
+	require.NoError(t, ParseBlastFile(infile, outfile, "blastn"), "Error parsing blast file %v", infile)
 	expectFile := filepath.Join("blast_testdata", "parse_blastn.expect")
 	cmp := equalfile.New(nil, equalfile.Options{})
 	filesEqual, err := cmp.CompareFile(expectFile, outfile)
@@ -25,7 +27,7 @@ func TestParseTblastxFile(t *testing.T) {
 	infile := filepath.Join("blast_testdata", "parse_tblastx.in")
 	outfile := "tmp.test.ParseTblastx"
 	utils.DeleteFileIfExists(outfile)
-	ParseBlastFile(infile, outfile, "tblastx")
+	require.NoError(t, ParseBlastFile(infile, outfile, "tblastx"), "Error parsing blast file %v", infile)
 	expectFile := filepath.Join("blast_testdata", "parse_tblastx.expect")
 	cmp := equalfile.New(nil, equalfile.Options{})
 	filesEqual, err := cmp.CompareFile(expectFile, outfile)
@@ -33,3 +35,15 @@ func TestParseTblastxFile(t *testing.T) {
 	require.True(t, filesEqual, "tlastx file %s expected contents incorrect", outfile)
 	utils.DeleteFileIfExists(outfile)
 }
+
+func TestRunBlastMissingProgram(t *testing.T) {
+	workingDir := t.TempDir()
+	for _, name := range []string{"g1.fa", "g2.fa"} {
+		require.NoError(t, os.WriteFile(filepath.Join(workingDir, name), []byte(">seq\nACGT\n"), 0644), "Error writing %v", name)
+	}
+	err := RunBlast(workingDir, filepath.Join(workingDir, "notadir"), "blastn", false, nil)
+	require.ErrorIs(t, err, ErrBlastFailed, "Should have got blast failed error")
+	var blastErr *BlastError
+	require.True(t, errors.As(err, &blastErr), "Error should be a BlastError")
+	require.Equal(t, "makeblastdb", blastErr.Program, "Wrong program in BlastError")
+}
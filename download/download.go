@@ -34,11 +34,11 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"github.com/martinghunt/tnahelper/seqfiles"
 	"github.com/martinghunt/tnahelper/utils"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -46,6 +46,13 @@ import (
 	"strings"
 )
 
+var (
+	// ErrBadAccession is returned when NCBI has no genome for an accession
+	ErrBadAccession = errors.New("bad accession")
+	// ErrDownloadFailed is returned when a file could not be downloaded
+	ErrDownloadFailed = errors.New("download failed")
+)
+
 const BLAST_FTP_URL = "https://ftp.ncbi.nlm.nih.gov/blast/executables/blast+/2.16.0/"
 
 var BLAST_TARBALLS = map[string]string{
@@ -145,14 +152,14 @@ func downloadBlast(outdir string) error {
 	fmt.Println("Detected OS/architecture:", key)
 	tarballToDownload, exists := BLAST_TARBALLS[key]
 	if !exists {
-		return fmt.Errorf("Cannot download blast binaries. Unknown OS/architecture: %v", key)
+		return fmt.Errorf("cannot download blast binaries. Unknown OS/architecture: %v", key)
 	}
 	url := BLAST_FTP_URL + tarballToDownload
 	tmpOut := filepath.Join(outdir, tarballToDownload)
 	fmt.Println("Downloading", url, "to", tmpOut)
 	err := downloadFile(url, tmpOut)
 	if err != nil {
-		return fmt.Errorf("Error downloading %v. Error: %v", url, err)
+		return fmt.Errorf("error downloading %v: %w", url, err)
 	}
 	// Comment out tblastx for now, since we don't support it but
 	// might in the future
@@ -162,36 +169,46 @@ func downloadBlast(outdir string) error {
 	} else {
 		wanted = []string{"blastn", "makeblastdb"} //, "tblastx"}
 	}
-	extractGzipTarball(tmpOut, outdir, wanted)
+	err = extractGzipTarball(tmpOut, outdir, wanted)
+	if err != nil {
+		return fmt.Errorf("error extracting files from tarball %v: %w", tmpOut, err)
+	}
 	err = os.Remove(tmpOut)
 	if err != nil {
-		return fmt.Errorf("Error deleting downloaded tarball %v %v", tmpOut, err)
+		return fmt.Errorf("error deleting downloaded tarball %v: %w", tmpOut, err)
 	}
 	return nil
 }
 
-func DownloadBinaries(outdir string) {
+func DownloadBinaries(outdir string) error {
 	err := os.MkdirAll(outdir, 0755)
 	if err != nil {
-		log.Fatalf("Error making output directory %v %v", outdir, err)
+		return fmt.Errorf("error making output directory %v: %w", outdir, err)
 	}
 	err = downloadBlast(outdir)
 	if err != nil {
-		log.Fatalf("Error downloading blast binaries %v", err)
+		return fmt.Errorf("%w: error downloading blast binaries: %w", ErrDownloadFailed, err)
 	}
+	return nil
 }
 
-func FastaAndGffFromZip(zipfile string, outprefix string) {
+// Extracts the FASTA (*.fna) and GFF (*.gff) files from a zip file
+// downloaded with the datasets API, to outprefix.fa and outprefix.gff.
+// Returns ErrBadAccession if the zip file cannot be read or has no FASTA
+// file, which is what happens when the accession does not exist
+func FastaAndGffFromZip(zipfile string, outprefix string) error {
 	zipReader, err := zip.OpenReader(zipfile)
 	if err != nil {
-		log.Fatalf("Error opening ZIP file %s. This probably means a bad accession. Error: %v", zipfile, err)
+		return fmt.Errorf("%w: error opening ZIP file %s. This probably means a bad accession. Error: %w", ErrBadAccession, zipfile, err)
 	}
 	defer zipReader.Close()
+	foundFasta := false
 
 	for _, file := range zipReader.File {
 		outfile := ""
 		if strings.HasSuffix(file.Name, ".fna") {
 			outfile = outprefix + ".fa"
+			foundFasta = true
 			fmt.Println("Extract fasta", file.Name, "to", outfile)
 		} else if strings.HasSuffix(file.Name, ".gff") {
 			outfile = outprefix + ".gff"
@@ -200,51 +217,64 @@ func FastaAndGffFromZip(zipfile string, outprefix string) {
 		if outfile == "" {
 			continue
 		}
-		toExtract, err := file.Open()
-		if err != nil {
-			log.Fatalf("Error opening %s: %v", file.Name, err)
+		if err := extractZipFile(file, outfile); err != nil {
+			return err
 		}
-		defer toExtract.Close()
+	}
 
-		fout, err := os.Create(outfile)
-		if err != nil {
-			log.Fatalf("Error opening %s: %v", outfile, err)
-		}
-		defer fout.Close()
+	if !foundFasta {
+		return fmt.Errorf("%w: no FASTA file found in ZIP file %s", ErrBadAccession, zipfile)
+	}
+	return nil
+}
 
-		_, err = io.Copy(fout, toExtract)
-		if err != nil {
-			log.Fatalf("Error copying contents of file from %s to %s: %v", file.Name, outfile, err)
-		}
+func extractZipFile(file *zip.File, outfile string) error {
+	toExtract, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", file.Name, err)
+	}
+	defer toExtract.Close()
+
+	fout, err := os.Create(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", outfile, err)
+	}
+
+	_, err = io.Copy(fout, toExtract)
+	if closeErr := fout.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error copying contents of file from %s to %s: %w", file.Name, outfile, err)
 	}
+	return nil
 }
 
-func DownloadGenomeWithDatasetsAPI(accession string, outprefix string) {
+func DownloadGenomeWithDatasetsAPI(accession string, outprefix string) error {
 	tmp_dir := outprefix + ".tmp"
 	err := os.MkdirAll(tmp_dir, 0755)
 	if err != nil {
-		log.Fatalf("Error making temp dir for downloaded files: %s", tmp_dir)
+		return fmt.Errorf("error making temp dir for downloaded files %s: %w", tmp_dir, err)
 	}
+	defer os.RemoveAll(tmp_dir)
 	tmp_zip_file := filepath.Join(tmp_dir, "dl.zip")
 	url := fmt.Sprintf("https://api.ncbi.nlm.nih.gov/datasets/v2/genome/accession/%s/download?include_annotation_type=GENOME_FASTA&include_annotation_type=GENOME_GFF", accession)
 	err = downloadFile(url, tmp_zip_file)
 	if err != nil {
-		log.Fatalf("Error downloading '%s' from NCBI (url: %s)\nError: %s", accession, url, err)
+		return fmt.Errorf("%w: error downloading '%s' from NCBI (url: %s): %w", ErrDownloadFailed, accession, url, err)
 	}
 
 	fmt.Println("Downloaded zip file from URL:", url)
-	FastaAndGffFromZip(tmp_zip_file, outprefix)
-	utils.DeleteFileIfExists(tmp_zip_file)
-	utils.DeleteFileIfExists(tmp_dir)
+	return FastaAndGffFromZip(tmp_zip_file, outprefix)
 }
 
-func DownloadGenomeFromGenBank(accession string, outprefix string) {
+func DownloadGenomeFromGenBank(accession string, outprefix string) error {
 	url := fmt.Sprintf("https://www.ncbi.nlm.nih.gov/sviewer/viewer.fcgi?id=%s&db=nuccore&report=fasta&retmode=text", accession)
 	fastaOut := outprefix + ".fa"
 	fmt.Println("Getting FASTA: ", url)
 	err := downloadFile(url, fastaOut)
 	if err != nil {
-		log.Fatalf("Error downloading FASTA file for '%s' from NCBI (url: %s)\nError: %s", accession, url, err)
+		return fmt.Errorf("%w: error downloading FASTA file for '%s' from NCBI (url: %s): %w", ErrDownloadFailed, accession, url, err)
 	}
 
 	url = fmt.Sprintf("https://www.ncbi.nlm.nih.gov/sviewer/viewer.fcgi?id=%s&db=nuccore&report=gff3&retmode=text", accession)
@@ -254,29 +284,54 @@ func DownloadGenomeFromGenBank(accession string, outprefix string) {
 	if err != nil {
 		fmt.Println("Didn't get gff file, but carrying on because FASTA is ok")
 	}
+	return nil
 }
 
-func DownloadGenome(accession string, outprefix string) error {
+// DownloadGenome downloads the sequences, and annotation if it exists, for
+// an accession. These are then imported using seqfiles.ParseSeqFileWithOptions,
+// so that the output files outprefix.fa and outprefix.gff are the same as
+// from importing any other sequence and annotation file
+func DownloadGenome(accession string, outprefix string, options seqfiles.ImportOptions) error {
+	dlPrefix := outprefix + ".download"
+	dlFa := dlPrefix + ".fa"
+	dlGff := dlPrefix + ".gff"
+	defer utils.DeleteFileIfExists(dlFa)
+	defer utils.DeleteFileIfExists(dlGff)
+	var err error
 	if strings.HasPrefix(accession, "GCF_") || strings.HasPrefix(accession, "GCA_") {
-		DownloadGenomeWithDatasetsAPI(accession, outprefix)
+		err = DownloadGenomeWithDatasetsAPI(accession, dlPrefix)
 	} else {
-		DownloadGenomeFromGenBank(accession, outprefix)
+		err = DownloadGenomeFromGenBank(accession, dlPrefix)
 	}
-	dlFa := outprefix + ".fa"
+	if err != nil {
+		return err
+	}
+
+	// NCBI sends an error message instead of FASTA if the accession does
+	// not exist
 	if !utils.FileExists(dlFa) {
-		log.Fatalf("FASTA file not downloaded")
+		return fmt.Errorf("%w: FASTA file not downloaded for '%s'", ErrBadAccession, accession)
+	}
+	fileType, err := seqfiles.GetFileType(dlFa)
+	if err != nil {
+		return err
+	} else if fileType != seqfiles.FASTA {
+		return fmt.Errorf("%w: downloaded file for '%s' is not FASTA", ErrBadAccession, accession)
 	}
-	tmpFa := outprefix + ".tmp.fa"
-	seqfiles.ParseSeqFile(dlFa, outprefix+".tmp")
-	utils.RenameFile(tmpFa, dlFa)
 
 	// File we get that's supposed to be GFF3 can be HTML file if something
-	// is wrong. Delete if it's not GFF3
-	gff := outprefix + ".gff"
-	if utils.FileExists(gff) && seqfiles.GetFileType(gff) != seqfiles.GFF3 {
-		fmt.Println("Bad format of GFF file (probably no GFF exists for the accession). Delete it")
-		utils.DeleteFileIfExists(gff)
+	// is wrong. Only use it if it's GFF3
+	options.AnnotationFile = ""
+	if utils.FileExists(dlGff) {
+		fileType, err := seqfiles.GetFileType(dlGff)
+		if err != nil {
+			return err
+		} else if fileType == seqfiles.GFF3 {
+			options.AnnotationFile = dlGff
+		} else {
+			fmt.Println("Bad format of GFF file (probably no GFF exists for the accession). Ignoring it")
+		}
 	}
 
-	return nil
+	return seqfiles.ParseSeqFileWithOptions(dlFa, outprefix, options)
 }
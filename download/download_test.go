@@ -14,7 +14,7 @@ func TestFastaAndGffFromZipOK(t *testing.T) {
 	gff := outprefix + ".gff"
 	utils.DeleteFileIfExists(fa)
 	utils.DeleteFileIfExists(gff)
-	FastaAndGffFromZip(zipfile, outprefix)
+	require.NoError(t, FastaAndGffFromZip(zipfile, outprefix), "Error extracting from zip file")
 	require.True(t, utils.FileExists(fa), "FASTA file not found %s", fa)
 	require.True(t, utils.FileExists(gff), "GFF file not found %s", gff)
 	utils.DeleteFileIfExists(fa)
@@ -28,7 +28,7 @@ func TestFastaAndGffFromZipNoGFF(t *testing.T) {
 	gff := outprefix + ".gff"
 	utils.DeleteFileIfExists(fa)
 	utils.DeleteFileIfExists(gff)
-	FastaAndGffFromZip(zipfile, outprefix)
+	require.NoError(t, FastaAndGffFromZip(zipfile, outprefix), "Error extracting from zip file")
 	require.True(t, utils.FileExists(fa), "FASTA file not found %s", fa)
 	require.False(t, utils.FileExists(gff), "GFF file found %s", gff)
 	utils.DeleteFileIfExists(fa)
@@ -41,7 +41,13 @@ func TestFastaAndGffFromZipNoFasta(t *testing.T) {
 	gff := outprefix + ".gff"
 	utils.DeleteFileIfExists(fa)
 	utils.DeleteFileIfExists(gff)
-	FastaAndGffFromZip(zipfile, outprefix)
+	err := FastaAndGffFromZip(zipfile, outprefix)
+	require.ErrorIs(t, err, ErrBadAccession, "Zip file with no FASTA should be bad accession")
 	require.False(t, utils.FileExists(fa), "FASTA file found %s", fa)
 	require.False(t, utils.FileExists(gff), "GFF file found %s", gff)
 }
+
+func TestFastaAndGffFromZipNotZip(t *testing.T) {
+	err := FastaAndGffFromZip(filepath.Join("download_testdata", "notafile.zip"), "tmp.TestFastaAndGffFromZip_not_zip")
+	require.ErrorIs(t, err, ErrBadAccession, "Missing zip file should be bad accession")
+}
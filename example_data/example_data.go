@@ -1,9 +1,9 @@
 package example_data
 
 import (
+	"fmt"
 	"github.com/martinghunt/tnahelper/utils"
 	"github.com/shenwei356/xopen"
-	"log"
 	"math/rand"
 	"path/filepath"
 	"strings"
@@ -59,7 +59,7 @@ But don't want bottom c4 to match top c5. Which means need to add mutations
 to make them too different for blast
 */
 
-func MakeTestFiles(outdir string) {
+func MakeTestFiles(outdir string) error {
 	commonT := makeRandomSeq(500)
 	bot4 := makeRandomSeq(500)
 	top3 := mutateNBases(bot4, 13)
@@ -72,9 +72,8 @@ func MakeTestFiles(outdir string) {
 	genome1_gff := filepath.Join(outdir, "g1.gff")
 	fout, errOut := xopen.Wopen(genome1_gff)
 	if errOut != nil {
-		log.Fatalf("Error opening file for writing %v: %v", genome1_gff, errOut)
+		return fmt.Errorf("error opening file for writing %v: %w", genome1_gff, errOut)
 	}
-	defer fout.Close()
 	fout.WriteString("##gff-version 3\n")
 	fout.WriteString("g1.c1\t.\tgene\t900\t1400\t.\t+\t.\tID=gene1;foo=bar;name=name1\n")
 	fout.WriteString("g1.c1\t.\texon\t910\t1000\t.\t+\t.\tID=exon1;name=gene1.exon1;Parent=gene1\n")
@@ -103,15 +102,15 @@ func MakeTestFiles(outdir string) {
 	fout.WriteString(string(makeRandomSeq(800)) + "\n")
 	fout.WriteString(">g1.c7\n")
 	fout.WriteString(string(top7) + "\n")
-	fout.Flush()
-	fout.Close()
+	if err := fout.Close(); err != nil {
+		return fmt.Errorf("error closing file %v: %w", genome1_gff, err)
+	}
 
 	genome2_gff := filepath.Join(outdir, "g2.gff")
 	fout, errOut = xopen.Wopen(genome2_gff)
 	if errOut != nil {
-		log.Fatalf("Error opening file for writing %v: %v", genome2_gff, errOut)
+		return fmt.Errorf("error opening file for writing %v: %w", genome2_gff, errOut)
 	}
-	defer fout.Close()
 	fout.WriteString("##gff-version 3\n")
 	fout.WriteString("g2.c2\t.\tgene\t1\t500\t.\t-\t.\tID=gene42;foo=bar;name=name_gene42\n")
 	fout.WriteString("g2.c10\t.\tgene\t150\t410\t.\t+\t.\tID=gene43;foo=bar;name=name_gene43\n")
@@ -140,4 +139,8 @@ func MakeTestFiles(outdir string) {
 	fout.WriteString(string(makeRandomSeq(600)) + "\n")
 	fout.WriteString(">g2.c10\n")
 	fout.WriteString(string(bot10) + "\n")
+	if err := fout.Close(); err != nil {
+		return fmt.Errorf("error closing file %v: %w", genome2_gff, err)
+	}
+	return nil
 }
@@ -14,7 +14,7 @@ func TestMakeTestData(t *testing.T) {
 	utils.DeleteFileIfExists(genome1_gff)
 	utils.DeleteFileIfExists(genome2_gff)
 	utils.DeleteFileIfExists(outdir)
-	MakeTestFiles(outdir)
+	require.NoError(t, MakeTestFiles(outdir), "Error making test files")
 	require.True(t, utils.FileExists(genome1_gff), "File not found, but should have found it: %v", genome1_gff)
 	utils.DeleteFileIfExists(genome1_gff)
 	utils.DeleteFileIfExists(genome2_gff)
@@ -1,48 +1,280 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/martinghunt/tnahelper/blast"
 	"github.com/martinghunt/tnahelper/download"
 	"github.com/martinghunt/tnahelper/example_data"
 	"github.com/martinghunt/tnahelper/seqfiles"
 	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
 )
 
 var Version = "development"
 
+const exitCodesHelp = `Helper for TNA.
+
+Exit codes:
+  0  success
+  1  any other error
+  2  bad command line options
+  3  unknown file format
+  4  badly formatted input file
+  5  duplicate sequence names, or annotation that does not match the sequences
+  6  bad accession (genome not found at NCBI)
+  7  blast failed
+  8  download failed
+  9  bad region (unknown sequence or gene, or coordinates past the end of the sequence)`
+
+// usageError marks an error in the command line options
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+// Returns the exit code for an error, as listed in exitCodesHelp. TNA
+// uses these to show the right message to the user
+func exitCode(err error) int {
+	var usageErr usageError
+	switch {
+	case errors.As(err, &usageErr):
+		return 2
+	case errors.Is(err, seqfiles.ErrUnknownFormat):
+		return 3
+	case errors.Is(err, seqfiles.ErrBadFormat):
+		return 4
+	case errors.Is(err, seqfiles.ErrDuplicateNames), errors.Is(err, seqfiles.ErrAnnotationMismatch):
+		return 5
+	case errors.Is(err, download.ErrBadAccession):
+		return 6
+	case errors.Is(err, blast.ErrBlastFailed):
+		return 7
+	case errors.Is(err, download.ErrDownloadFailed):
+		return 8
+	case errors.Is(err, seqfiles.ErrBadRegion):
+		return 9
+	}
+	return 1
+}
+
 func main() {
-	rootCmd := &cobra.Command{Use: "tnahelper"}
+	rootCmd := &cobra.Command{
+		Use:           "tnahelper",
+		Long:          exitCodesHelp,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
 	rootCmd.Version = Version
+
+	// Any error from cobra before a command runs, eg a missing required
+	// flag, is a usage error
+	commandRan := false
+	runE := func(run func(args []string) error) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			commandRan = true
+			return run(args)
+		}
+	}
 	var infile string
 	var outprefix string
 	var outdir string
 	var bindir string
 	minGapLen := -1
+	strictGFF3 := false
+	var annotPolicy string
+	var annotFile string
+	uniqueNames := false
+	var namePrefix string
+	var fastqTrimQual int
+	var fastqMaskQual int
+	var fastqMinLen int
+	var softMaskMinLen int
+	var softMaskType string
+	var gapChars string
+	var agpFile string
+	var splitMinGapLen int
+	var splitPolicy string
+	var nameFrom string
+	var geneticCode int
+
+	// Options for turning a sequence file into outprefix.fa, .gff etc. These
+	// are shared by import_seqfile and download_genome. Options that depend
+	// on the type of input file are only used by import_seqfile
+	addImportFlags := func(cmd *cobra.Command) {
+		cmd.Flags().IntVarP(&minGapLen, "mingap", "g", -1, "Minimum length of run of gap characters (see --gap_chars) to count as a gap and get added to annotation. Anything <= 0 means do not add any gaps")
+		cmd.Flags().StringVar(&gapChars, "gap_chars", seqfiles.DefaultGapChars, "Characters that count as gap when finding gaps, eg NX- (not case sensitive)")
+		cmd.Flags().BoolVar(&strictGFF3, "strict_gff", false, "Stop with an error if any problem is found in GFF3 input. Default is to fix problems where possible, skip lines that cannot be fixed, and print a warning for each problem")
+		cmd.Flags().StringVar(&annotPolicy, "annot_policy", "clip", "What to do with annotation on sequences that were not imported, or that goes past the end of a sequence. One of: clip (clip to sequence length, remove if on unknown sequence), drop (remove), fail (stop with an error). Any problems are written to outprefix.annotation_problems.tsv")
+		cmd.Flags().BoolVar(&uniqueNames, "unique_names", false, "Rename sequences whose name is already used, by adding .2, .3, etc. Default is to stop with an error if any names are duplicated. Renamed sequences are listed in outprefix.names.tsv")
+		cmd.Flags().IntVar(&geneticCode, "genetic_code", seqfiles.DefaultGeneticCode, "NCBI genetic code used to translate CDS features that do not have a transl_table attribute. The proteins are written to outprefix.faa")
+		cmd.Flags().StringVar(&namePrefix, "name_prefix", "", "Add this to the start of every sequence name (eg the genome label). Renamed sequences are listed in outprefix.names.tsv")
+		cmd.Flags().IntVar(&softMaskMinLen, "softmask_min_len", 0, "FASTA, FASTQ and GFF3 input only. Minimum length of run of lower case (soft-masked) bases to add to annotation. Anything <= 0 means do not add any")
+		cmd.Flags().StringVar(&softMaskType, "softmask_type", "repeat_region", "Feature type of soft-masked runs added to annotation. One of: repeat_region, low_complexity")
+		cmd.Flags().IntVar(&splitMinGapLen, "split_gap", 0, "Split sequences into contigs at gaps (see --gap_chars) at least this long, and import the contigs instead. Contigs are named <sequence>_1, <sequence>_2, etc, and where they are in the original sequences is written to outprefix.split.tsv. Anything <= 0 means do not split")
+		cmd.Flags().StringVar(&splitPolicy, "split_policy", "split", "What to do with features that cross a gap where a sequence is split (see --split_gap). One of: split (split into one feature per contig), drop (remove, and remove from the Parent of their children)")
+	}
+	importOptions := func() (seqfiles.ImportOptions, error) {
+		options := seqfiles.DefaultImportOptions()
+		options.MinGapLen = minGapLen
+		options.GapChars = gapChars
+		options.StrictGFF3 = strictGFF3
+		policy, err := seqfiles.ParseAnnotationPolicy(annotPolicy)
+		if err != nil {
+			return options, usageError{err}
+		}
+		options.AnnotationPolicy = policy
+		options.UniqueNames = uniqueNames
+		options.GeneticCode = geneticCode
+		options.NamePrefix = namePrefix
+		options.SoftMaskMinLen = softMaskMinLen
+		options.SoftMaskType = softMaskType
+		options.SplitMinGapLen = splitMinGapLen
+		options.SplitPolicy, err = seqfiles.ParseSplitPolicy(splitPolicy)
+		if err != nil {
+			return options, usageError{err}
+		}
+		return options, nil
+	}
 
 	// ---------------- import_seqfile ---------------------
 	var cmdImportSeqfile = &cobra.Command{
 		Use:   "import_seqfile",
 		Short: "Import sequence file",
-		Run: func(cmd *cobra.Command, args []string) {
-			seqfiles.ParseSeqFile(infile, outprefix, minGapLen)
-		},
+		RunE: runE(func(args []string) error {
+			options, err := importOptions()
+			if err != nil {
+				return err
+			}
+			options.AnnotationFile = annotFile
+			options.AGPFile = agpFile
+			options.FastqTrimQuality = fastqTrimQual
+			options.FastqMaskQuality = fastqMaskQual
+			options.FastqMinLength = fastqMinLen
+			options.SeqNameSource, err = seqfiles.ParseSeqNameSource(nameFrom)
+			if err != nil {
+				return usageError{err}
+			}
+			sniffed, err := seqfiles.SniffFileType(infile)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Detected file type: %v (confidence %.2f): %v\n", sniffed.Format, sniffed.Confidence, sniffed.Reason)
+			options.Sniffed = &sniffed
+			return seqfiles.ParseSeqFileWithOptions(infile, outprefix, options)
+		}),
 	}
 
 	cmdImportSeqfile.Flags().StringVarP(&infile, "infile", "i", "", "REQUIRED. Input sequence file")
 	cmdImportSeqfile.Flags().StringVarP(&outprefix, "outprefix", "o", "", "REQUIRED. Prefix of output files")
-	cmdImportSeqfile.Flags().IntVarP(&minGapLen, "mingap", "g", -1, "Minimum length of run of Ns to count as a gap and get added to annotation. Anything <= 0 means do not add any gaps")
+	cmdImportSeqfile.Flags().StringVarP(&annotFile, "annotation", "a", "", "Annotation file (GFF3, GTF, BED, genbank or EMBL) to add to the annotation of the sequences in the input file")
+	cmdImportSeqfile.Flags().StringVar(&agpFile, "agp", "", "AGP file describing how to join the sequences in the input file into scaffolds. The scaffolds are imported instead, with a contig feature for each component and an assembly_gap feature for each gap")
+	cmdImportSeqfile.Flags().StringVar(&nameFrom, "name_from", "locus", "Genbank and EMBL input only. Which identifier to use as the sequence name. One of: locus (LOCUS or ID line), accession (ACCESSION or AC line), version (accession.version from the VERSION line, or the ID or SV line). All of them, with the definition, organism and taxon, are written to outprefix.metadata.json")
+	cmdImportSeqfile.Flags().IntVar(&fastqTrimQual, "fastq_trim_qual", 0, "FASTQ input only. Trim bases with quality below this from the start and end of each read. Anything <= 0 means do not trim")
+	cmdImportSeqfile.Flags().IntVar(&fastqMaskQual, "fastq_mask_qual", 0, "FASTQ input only. Change bases with quality below this to N, so they are shown as gaps. Anything <= 0 means do not mask")
+	cmdImportSeqfile.Flags().IntVar(&fastqMinLen, "fastq_min_len", 0, "FASTQ input only. Skip reads shorter than this after trimming")
+	addImportFlags(cmdImportSeqfile)
 	cmdImportSeqfile.MarkFlagRequired("infile")
 	cmdImportSeqfile.MarkFlagRequired("outprefix")
 	rootCmd.AddCommand(cmdImportSeqfile)
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
 
+	// ---------------- export_agp -------------------------
+	var inprefix string
+	var outfile string
+	agpMinGapLen := 1
+	var cmdExportAGP = &cobra.Command{
+		Use:   "export_agp",
+		Short: "Write AGP file of contigs and gaps of imported sequences",
+		RunE: runE(func(args []string) error {
+			return seqfiles.WriteAGP(inprefix+".fa", inprefix+".gff", outfile, agpMinGapLen, gapChars)
+		}),
+	}
+	cmdExportAGP.Flags().StringVarP(&inprefix, "inprefix", "i", "", "REQUIRED. Prefix of files made by import_seqfile. Uses inprefix.fa, and the gap types in inprefix.gff if it exists")
+	cmdExportAGP.Flags().StringVarP(&outfile, "outfile", "o", "", "REQUIRED. Output AGP file")
+	cmdExportAGP.Flags().IntVarP(&agpMinGapLen, "mingap", "g", 1, "Minimum length of run of gap characters (see --gap_chars) to count as a gap")
+	cmdExportAGP.Flags().StringVar(&gapChars, "gap_chars", seqfiles.DefaultGapChars, "Characters that count as gap, eg NX- (not case sensitive)")
+	cmdExportAGP.MarkFlagRequired("inprefix")
+	cmdExportAGP.MarkFlagRequired("outfile")
+	rootCmd.AddCommand(cmdExportAGP)
+
+	// ---------------- export -----------------------------
+	var exportFormat string
+	var cmdExport = &cobra.Command{
+		Use:   "export",
+		Short: "Write imported sequences and annotation to a genbank or EMBL file",
+		RunE: runE(func(args []string) error {
+			format, err := seqfiles.ParseFlatfileFormat(exportFormat)
+			if err != nil {
+				return usageError{err}
+			}
+			return seqfiles.ExportFlatfile(inprefix, outfile, format)
+		}),
+	}
+	cmdExport.Flags().StringVarP(&inprefix, "inprefix", "i", "", "REQUIRED. Prefix of files made by import_seqfile. Uses inprefix.fa, and inprefix.gff if it exists")
+	cmdExport.Flags().StringVarP(&outfile, "outfile", "o", "", "REQUIRED. Output file")
+	cmdExport.Flags().StringVarP(&exportFormat, "format", "f", "genbank", "Output format. One of: genbank, embl")
+	cmdExport.MarkFlagRequired("inprefix")
+	cmdExport.MarkFlagRequired("outfile")
+	rootCmd.AddCommand(cmdExport)
+
+	// ---------------- extract ----------------------------
+	var bedFile string
+	var revcomp bool
+	var cmdExtract = &cobra.Command{
+		Use:   "extract [flags] [region ...]",
+		Short: "Extract regions, and their annotation, from imported sequences",
+		Long:  "Extract regions, and their annotation, from imported sequences.\nEach region is name (the whole sequence), name:start or name:start-end, where coordinates are 1-based. Regions can also be given in a BED file",
+		RunE: runE(func(args []string) error {
+			regions := []seqfiles.Region{}
+			for _, arg := range args {
+				region, err := seqfiles.ParseRegion(arg)
+				if err != nil {
+					return err
+				}
+				regions = append(regions, region)
+			}
+			if bedFile != "" {
+				bedRegions, err := seqfiles.RegionsFromBED(bedFile)
+				if err != nil {
+					return err
+				}
+				regions = append(regions, bedRegions...)
+			}
+			if len(regions) == 0 {
+				return usageError{errors.New("no regions given. Give at least one region, or a BED file with --bed")}
+			}
+			if revcomp {
+				for i := range regions {
+					regions[i].Reverse = true
+				}
+			}
+			return seqfiles.ExtractRegions(inprefix, regions, outprefix)
+		}),
+	}
+	cmdExtract.Flags().StringVarP(&inprefix, "inprefix", "i", "", "REQUIRED. Prefix of files made by import_seqfile. Uses inprefix.fa, inprefix.fa.fai, and inprefix.gff if it exists")
+	cmdExtract.Flags().StringVarP(&outprefix, "outprefix", "o", "", "REQUIRED. Prefix of output files. Writes outprefix.fa and outprefix.gff")
+	cmdExtract.Flags().StringVarP(&bedFile, "bed", "b", "", "BED file of regions to extract. Column 4 (if present) is used as the name of the extracted sequence, and regions on the - strand (column 6) are reverse complemented")
+	cmdExtract.Flags().BoolVar(&revcomp, "revcomp", false, "Reverse complement all regions")
+	cmdExtract.MarkFlagRequired("inprefix")
+	cmdExtract.MarkFlagRequired("outprefix")
+	rootCmd.AddCommand(cmdExtract)
+
 	// ---------------- download_binaries ------------------
 	var cmdDownloadBinaries = &cobra.Command{
 		Use:   "download_binaries",
 		Short: "Download binary files",
-		Run: func(cmd *cobra.Command, args []string) {
-			download.DownloadBinaries(outdir)
-		},
+		RunE: runE(func(args []string) error {
+			return download.DownloadBinaries(outdir)
+		}),
 	}
 	cmdDownloadBinaries.Flags().StringVarP(&outdir, "outdir", "o", "", "REQUIRED. Output directory")
 	cmdDownloadBinaries.MarkFlagRequired("outdir")
@@ -53,14 +285,20 @@ func main() {
 	var cmdDownloadGenome = &cobra.Command{
 		Use:   "download_genome",
 		Short: "Download genome file(s)",
-		Run: func(cmd *cobra.Command, args []string) {
-			download.DownloadGenome(accession, outprefix)
-		},
+		Long:  "Download genome file(s).\nThe genome is imported in the same way as import_seqfile, using the import options below",
+		RunE: runE(func(args []string) error {
+			options, err := importOptions()
+			if err != nil {
+				return err
+			}
+			return download.DownloadGenome(accession, outprefix, options)
+		}),
 	}
 	cmdDownloadGenome.Flags().StringVarP(&accession, "accession", "a", "", "REQUIRED. Accession to download")
 	cmdDownloadGenome.MarkFlagRequired("accession")
 	cmdDownloadGenome.Flags().StringVarP(&outprefix, "outprefix", "o", "", "REQUIRED. Output prefix")
 	cmdDownloadGenome.MarkFlagRequired("outprefix")
+	addImportFlags(cmdDownloadGenome)
 	rootCmd.AddCommand(cmdDownloadGenome)
 
 	// ------------------ blast ----------------------------
@@ -69,10 +307,10 @@ func main() {
 	var cmdBlast = &cobra.Command{
 		Use:   "blast",
 		Short: "Run makeblastdb and blastn",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: runE(func(args []string) error {
 			// args has anything that's put after "--" on the command line
-			blast.RunBlast(outdir, bindir, "blastn", blastSendUsageReport, args)
-		},
+			return blast.RunBlast(outdir, bindir, "blastn", blastSendUsageReport, args)
+		}),
 	}
 
 	cmdBlast.Flags().StringVarP(&blastType, "blast_type", "t", "", "Placeholder, is ignored for now. Blast type. Is forced to be blastn (tblastx support may come in the future)")
@@ -83,17 +321,86 @@ func main() {
 	cmdBlast.MarkFlagRequired("bindir")
 	rootCmd.AddCommand(cmdBlast)
 
+	// ---------------- reorder_contigs --------------------
+	var reportFile string
+	var cmdReorderContigs = &cobra.Command{
+		Use:   "reorder_contigs",
+		Short: "Order and orient contigs of g1 to match g2",
+		Long:  "Order and orient contigs of g1 to match g2, using the blast matches between them.\nContigs are put in the order of their best match in g2, and reverse complemented (with their annotation) if the match is on the - strand. Rewrites g1.fa, g1.gff, g1.fa.fai and g1.summary.tsv",
+		RunE: runE(func(args []string) error {
+			if bindir != "" {
+				if err := blast.RunBlast(outdir, bindir, "blastn", blastSendUsageReport, args); err != nil {
+					return err
+				}
+			}
+			if reportFile == "" {
+				reportFile = filepath.Join(outdir, "g1.reorder.tsv")
+			}
+			if err := seqfiles.ReorderContigs(outdir, reportFile); err != nil {
+				return err
+			}
+			if bindir == "" {
+				fmt.Println("Contigs reordered. The blast file no longer matches g1.fa, so blast needs running again")
+				return nil
+			}
+			return blast.RunBlast(outdir, bindir, "blastn", blastSendUsageReport, args)
+		}),
+	}
+	cmdReorderContigs.Flags().StringVarP(&outdir, "outdir", "o", "", "REQUIRED. Directory with the files g1.fa and g2.fa, and g1.gff if there is annotation. Must also have the blast file made by the blast command, unless --bindir is used")
+	cmdReorderContigs.Flags().StringVarP(&bindir, "bindir", "b", "", "Bin directory, must contain makeblastdb,blastn. If used, blast is run before reordering, and again afterwards so that the blast file matches the new g1.fa")
+	cmdReorderContigs.Flags().BoolVar(&blastSendUsageReport, "send_usage_report", false, "Use this flag to enable sending a usage report to NCBI when blast runs")
+	cmdReorderContigs.Flags().StringVarP(&reportFile, "report", "r", "", "File to write the chosen layout of the contigs to [outdir/g1.reorder.tsv]")
+	cmdReorderContigs.MarkFlagRequired("outdir")
+	rootCmd.AddCommand(cmdReorderContigs)
+
+	// ---------------- rotate -----------------------------
+	var seqName string
+	var rotateStart int
+	var geneName string
+	var blastFile string
+	var cmdRotate = &cobra.Command{
+		Use:   "rotate",
+		Short: "Rotate a circular sequence to start at a new position",
+		Long:  "Rotate a circular sequence to start at a new position, given by exactly one of --start, --gene or --blast.\nFeatures are moved with the sequence. Features that cross the new start are split in two, and features that crossed the old start are joined. Rewrites inprefix.fa and inprefix.gff",
+		RunE: runE(func(args []string) error {
+			options := 0
+			for _, used := range []bool{rotateStart > 0, geneName != "", blastFile != ""} {
+				if used {
+					options++
+				}
+			}
+			if options != 1 {
+				return usageError{errors.New("must use exactly one of --start, --gene, --blast")}
+			}
+			return seqfiles.RotateSequence(inprefix, seqName, rotateStart, geneName, blastFile)
+		}),
+	}
+	cmdRotate.Flags().StringVarP(&inprefix, "inprefix", "i", "", "REQUIRED. Prefix of files made by import_seqfile. Uses inprefix.fa, and inprefix.gff if it exists. inprefix.faa is remade if it exists")
+	cmdRotate.Flags().StringVarP(&seqName, "seqname", "s", "", "REQUIRED. Name of sequence to rotate")
+	cmdRotate.Flags().IntVar(&rotateStart, "start", 0, "Position (1-based) to be the new start of the sequence")
+	cmdRotate.Flags().StringVar(&geneName, "gene", "", "Name of gene (eg dnaA) to be at the start of the sequence. Matched against the Name, gene, locus_tag and ID of features in inprefix.gff. If the gene is on the - strand, the sequence is also reverse complemented")
+	cmdRotate.Flags().StringVar(&blastFile, "blast", "", "Blast file made by the blast command, where the sequence is in g1. The sequence is rotated (and reverse complemented if needed) so that its longest match starts at the same position as in g2")
+	cmdRotate.MarkFlagRequired("inprefix")
+	cmdRotate.MarkFlagRequired("seqname")
+	rootCmd.AddCommand(cmdRotate)
+
 	// --------------- make_example_data -------------------
 	var cmdExampleData = &cobra.Command{
 		Use:   "make_example_data",
 		Short: "Make example data for testing TNA",
-		Run: func(cmd *cobra.Command, args []string) {
-			example_data.MakeTestFiles(outdir)
-		},
+		RunE: runE(func(args []string) error {
+			return example_data.MakeTestFiles(outdir)
+		}),
 	}
 	cmdExampleData.Flags().StringVarP(&outdir, "outdir", "o", "", "REQUIRED. Output directory. Will be created if doesn't exist")
 	cmdExampleData.MarkFlagRequired("outdir")
 	rootCmd.AddCommand(cmdExampleData)
 
-	rootCmd.Execute()
+	if err := rootCmd.Execute(); err != nil {
+		if !commandRan {
+			err = usageError{err}
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
 }
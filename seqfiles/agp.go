@@ -0,0 +1,434 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Length of every gap of type U (unknown length) in an AGP file, which
+// is fixed by the AGP specification
+const agpUnknownGapLength = 100
+
+// agpLine is one line of an AGP (version 2) file. A line is either a
+// sequence component (usually type W), or a gap (type N or U)
+type agpLine struct {
+	object        string
+	objectBeg     int
+	objectEnd     int
+	partNumber    int
+	componentType string
+	// set for sequence components
+	componentID  string
+	componentBeg int
+	componentEnd int
+	orientation  string
+	// set for gaps
+	gapLength       int
+	gapType         string
+	linkage         string
+	linkageEvidence string
+}
+
+func (a *agpLine) isGap() bool {
+	return a.componentType == "N" || a.componentType == "U"
+}
+
+// AGP gap types, and the gap_type of a genbank assembly_gap feature that
+// each one is the same as. The linkage column of AGP is only needed for
+// the repeat type
+var agpToGenbankGapTypes = map[string]string{
+	"scaffold":        "within scaffold",
+	"contig":          "between scaffolds",
+	"centromere":      "centromere",
+	"short_arm":       "short arm",
+	"heterochromatin": "heterochromatin",
+	"telomere":        "telomere",
+	"contamination":   "contamination",
+}
+
+// Returns the genbank assembly_gap gap_type of an AGP gap
+func genbankGapType(agpGapType string, linkage string) string {
+	if agpGapType == "repeat" {
+		if linkage == "yes" {
+			return "repeat within scaffold"
+		}
+		return "repeat between scaffolds"
+	}
+	if gapType, exists := agpToGenbankGapTypes[agpGapType]; exists {
+		return gapType
+	}
+	return agpGapType
+}
+
+// Returns the AGP gap type and linkage of a genbank assembly_gap
+// gap_type. Unknown gap types are assumed to be within a scaffold
+func agpGapType(genbankGapType string) (string, string) {
+	switch genbankGapType {
+	case "repeat within scaffold":
+		return "repeat", "yes"
+	case "repeat between scaffolds":
+		return "repeat", "no"
+	}
+	for agpType, gbType := range agpToGenbankGapTypes {
+		if gbType == genbankGapType {
+			if agpType == "scaffold" {
+				return agpType, "yes"
+			}
+			return agpType, "no"
+		}
+	}
+	return "scaffold", "yes"
+}
+
+// Returns the line formatted for an AGP file, including the newline
+func (a *agpLine) String() string {
+	prefix := fmt.Sprintf("%v\t%d\t%d\t%d\t%v\t", a.object, a.objectBeg, a.objectEnd, a.partNumber, a.componentType)
+	if a.isGap() {
+		return prefix + fmt.Sprintf("%d\t%v\t%v\t%v\n", a.gapLength, a.gapType, a.linkage, a.linkageEvidence)
+	}
+	return prefix + fmt.Sprintf("%v\t%d\t%d\t%v\n", a.componentID, a.componentBeg, a.componentEnd, a.orientation)
+}
+
+func parseAGPLine(line string) (agpLine, error) {
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(fields) < 8 {
+		return agpLine{}, fmt.Errorf("expected at least 8 tab-separated columns but got %d", len(fields))
+	}
+	a := agpLine{object: fields[0], componentType: fields[4]}
+	var err error
+	for i, value := range []*int{&a.objectBeg, &a.objectEnd, &a.partNumber} {
+		*value, err = strconv.Atoi(fields[i+1])
+		if err != nil || *value < 1 {
+			return a, fmt.Errorf("column %d must be an integer >= 1, got '%v'", i+2, fields[i+1])
+		}
+	}
+	if a.objectBeg > a.objectEnd {
+		return a, fmt.Errorf("object_beg %d > object_end %d", a.objectBeg, a.objectEnd)
+	}
+
+	if a.isGap() {
+		if len(fields) < 9 {
+			return a, fmt.Errorf("expected 9 columns for a gap line but got %d", len(fields))
+		}
+		a.gapLength, err = strconv.Atoi(fields[5])
+		if err != nil || a.gapLength < 1 {
+			return a, fmt.Errorf("gap length must be an integer >= 1, got '%v'", fields[5])
+		}
+		if a.gapLength != a.objectEnd-a.objectBeg+1 {
+			return a, fmt.Errorf("gap length %d does not match object_beg and object_end", a.gapLength)
+		}
+		a.gapType = fields[6]
+		a.linkage = fields[7]
+		a.linkageEvidence = fields[8]
+		return a, nil
+	}
+
+	if len(fields) < 9 {
+		return a, fmt.Errorf("expected 9 columns for a component line but got %d", len(fields))
+	}
+	a.componentID = fields[5]
+	a.componentBeg, err = strconv.Atoi(fields[6])
+	if err != nil || a.componentBeg < 1 {
+		return a, fmt.Errorf("component_beg must be an integer >= 1, got '%v'", fields[6])
+	}
+	a.componentEnd, err = strconv.Atoi(fields[7])
+	if err != nil || a.componentEnd < a.componentBeg {
+		return a, fmt.Errorf("component_end must be an integer >= component_beg, got '%v'", fields[7])
+	}
+	if a.componentEnd-a.componentBeg != a.objectEnd-a.objectBeg {
+		return a, fmt.Errorf("component length %d does not match object length %d", a.componentEnd-a.componentBeg+1, a.objectEnd-a.objectBeg+1)
+	}
+	a.orientation = fields[8]
+	switch a.orientation {
+	case "+", "-", "?", "0", "na":
+	default:
+		return a, fmt.Errorf("unknown orientation '%v'", a.orientation)
+	}
+	return a, nil
+}
+
+// Returns the lines of an AGP file, not including comments. Checks that
+// the lines of each object are in order, with no overlaps or holes
+func readAGP(filename string) ([]agpLine, error) {
+	lines := []agpLine{}
+	lineNumber := 0
+	err := forEachLine(filename, func(line string) error {
+		lineNumber++
+		if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+			return nil
+		}
+		a, err := parseAGPLine(line)
+		if err != nil {
+			return fmt.Errorf("%w: AGP file %v, line %d: %v", ErrBadFormat, filename, lineNumber, err)
+		}
+		expectBeg := 1
+		if len(lines) > 0 && lines[len(lines)-1].object == a.object {
+			expectBeg = lines[len(lines)-1].objectEnd + 1
+		}
+		if a.objectBeg != expectBeg {
+			return fmt.Errorf("%w: AGP file %v, line %d: expected object_beg to be %d, got %d", ErrBadFormat, filename, lineNumber, expectBeg, a.objectBeg)
+		}
+		lines = append(lines, a)
+		return nil
+	})
+	return lines, err
+}
+
+// Returns the feature of an AGP line: a contig feature for a sequence
+// component, or an assembly_gap feature for a gap
+func agpLineFeature(a agpLine) Feature {
+	feature := Feature{SeqID: a.object, Source: "AGP", Start: a.objectBeg, End: a.objectEnd, Score: ".", Strand: "+", Phase: "."}
+	if a.isGap() {
+		feature.Type = "assembly_gap"
+		feature.Attributes = []Attribute{{Key: "ID", Values: []string{fmt.Sprintf("%v.assembly_gap_%d", a.object, a.partNumber)}}}
+		feature.SetAttribute("gap_type", genbankGapType(a.gapType, a.linkage))
+		if a.componentType == "U" {
+			feature.SetAttribute("estimated_length", "unknown")
+		} else {
+			feature.SetAttribute("estimated_length", strconv.Itoa(a.gapLength))
+		}
+		if a.linkageEvidence != "na" && a.linkageEvidence != "" {
+			feature.Attributes = append(feature.Attributes, Attribute{Key: "linkage_evidence", Values: strings.Split(a.linkageEvidence, ";")})
+		}
+		return feature
+	}
+
+	feature.Type = "contig"
+	if a.orientation == "-" {
+		feature.Strand = "-"
+	} else if a.orientation != "+" {
+		feature.Strand = "?"
+	}
+	feature.Attributes = []Attribute{
+		{Key: "ID", Values: []string{fmt.Sprintf("%v.contig_%d", a.object, a.partNumber)}},
+		{Key: "component_id", Values: []string{a.componentID}},
+		{Key: "component_beg", Values: []string{strconv.Itoa(a.componentBeg)}},
+		{Key: "component_end", Values: []string{strconv.Itoa(a.componentEnd)}},
+	}
+	return feature
+}
+
+// Returns the scaffolds made from contigs using AGP lines, and the names
+// of the contigs that were used. Contig features are moved to scaffold
+// coordinates, and each scaffold gets a contig feature per component and
+// an assembly_gap feature per gap. from describes where the AGP lines
+// came from, for error messages
+func buildScaffolds(agpLines []agpLine, contigs []*SeqRecord, from string) ([]*SeqRecord, map[string]bool, error) {
+	contigsByName := map[string]*SeqRecord{}
+	for _, contig := range contigs {
+		contigsByName[contig.Name] = contig
+	}
+	usedContigs := map[string]bool{}
+	scaffolds := []*SeqRecord{}
+	var seq strings.Builder
+
+	for i, a := range agpLines {
+		if i == 0 || agpLines[i-1].object != a.object {
+			scaffolds = append(scaffolds, &SeqRecord{Name: a.object})
+			seq.Reset()
+		}
+		scaffold := scaffolds[len(scaffolds)-1]
+		scaffold.Features = append(scaffold.Features, agpLineFeature(a))
+
+		if a.isGap() {
+			seq.WriteString(strings.Repeat("N", a.gapLength))
+		} else {
+			contig, exists := contigsByName[a.componentID]
+			if !exists || contig.Seq == "" {
+				return nil, nil, fmt.Errorf("%w: %v has component %v, which is not in the sequences", ErrBadFormat, from, a.componentID)
+			} else if a.componentEnd > len(contig.Seq) {
+				return nil, nil, fmt.Errorf("%w: %v has component %v end %d, but it has length %d", ErrBadFormat, from, a.componentID, a.componentEnd, len(contig.Seq))
+			}
+			if usedContigs[a.componentID] {
+				log.Printf("Warning: contig %v is used more than once in %v. Its features are copied to each place it is used", a.componentID, from)
+			}
+			usedContigs[a.componentID] = true
+			reverse := a.orientation == "-"
+			part := contig.Seq[a.componentBeg-1 : a.componentEnd]
+			if reverse {
+				part = string(utils.ReverseComplement([]byte(part)))
+			}
+			seq.WriteString(part)
+
+			for _, feature := range contig.Features {
+				if a.componentBeg <= feature.Start && feature.End <= a.componentEnd {
+					scaffold.Features = append(scaffold.Features, liftFeature(feature, a.object, a.componentBeg, a.componentEnd, a.objectBeg, reverse))
+				} else if feature.Start <= a.componentEnd && a.componentBeg <= feature.End {
+					log.Printf("Warning: feature %v %v:%d-%d is only partly in the part of the contig used in %v. Feature removed", feature.Type, feature.SeqID, feature.Start, feature.End, from)
+				}
+			}
+		}
+
+		if i == len(agpLines)-1 || agpLines[i+1].object != a.object {
+			scaffold.Seq = seq.String()
+			scaffold.RegionLength = len(scaffold.Seq)
+		}
+	}
+	return scaffolds, usedContigs, nil
+}
+
+// Makes scaffolds from the contigs in fastaFile and their annotation in
+// annotFile, using an AGP file. The scaffolds and their annotation are
+// written to outFasta and outAnnot. Contigs that are not in the AGP file
+// are written unchanged
+func scaffoldsFromAGP(fastaFile string, annotFile string, agpFile string, outFasta string, outAnnot string) error {
+	agpLines, err := readAGP(agpFile)
+	if err != nil {
+		return err
+	}
+	contigs, err := readSeqsAndAnnotation(fastaFile, annotFile)
+	if err != nil {
+		return err
+	}
+	scaffolds, usedContigs, err := buildScaffolds(agpLines, contigs, "AGP file "+agpFile)
+	if err != nil {
+		return err
+	}
+	for _, contig := range contigs {
+		if !usedContigs[contig.Name] {
+			scaffolds = append(scaffolds, contig)
+		}
+	}
+	return writeSeqsAndAnnotation(scaffolds, outFasta, outAnnot)
+}
+
+// Replaces the imported contigs in fastaFile and annotFile with the
+// scaffolds made from them using an AGP file
+func importScaffoldsFromAGP(fastaFile string, annotFile string, agpFile string, outprefix string) error {
+	tmpFasta := outprefix + ".tmp.agp.fa"
+	tmpAnnot := outprefix + ".tmp.agp.gff"
+	if err := scaffoldsFromAGP(fastaFile, annotFile, agpFile, tmpFasta, tmpAnnot); err != nil {
+		utils.DeleteFileIfExists(tmpFasta)
+		utils.DeleteFileIfExists(tmpAnnot)
+		return err
+	}
+	if err := utils.RenameFile(tmpFasta, fastaFile); err != nil {
+		return err
+	}
+	return utils.RenameFile(tmpAnnot, annotFile)
+}
+
+// WriteAGP writes an AGP file that describes the sequences in a FASTA
+// file that has each sequence on one line as contigs separated by gaps.
+// Gaps are runs of gapChars (DefaultGapChars if "") at least minGapLen
+// long. If annotFile exists, the gap types and lengths are taken from its
+// assembly_gap features. AGP objects cannot start or end with a gap, so
+// gaps at the start or end of a sequence are left out, and the object is
+// the rest of the sequence. Gaps of unknown length are written with the
+// length of 100 required by AGP, so the object coordinates after them
+// are different to the sequence coordinates if the gap was not 100 long
+func WriteAGP(fastaFile string, annotFile string, outfile string, minGapLen int, gapChars string) (err error) {
+	if minGapLen < 1 {
+		minGapLen = 1
+	}
+	if gapChars == "" {
+		gapChars = DefaultGapChars
+	}
+	gaps, err := getGapsFromSingleLineFasta(fastaFile, minGapLen, gapChars)
+	if err != nil {
+		return err
+	}
+	if len(gaps) > 0 && utils.FileExists(annotFile) {
+		if err := addGapInfoFromAnnotation(gaps, annotFile); err != nil {
+			return err
+		}
+	}
+	stats, err := getSeqStatsFromSingleLineFasta(fastaFile)
+	if err != nil {
+		return err
+	}
+
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	if _, err := fout.WriteString("##agp-version\t2.1\n"); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", outfile, err)
+	}
+
+	gapIndex := 0
+	trimmedSeqs := 0
+	resizedGaps := 0
+	for _, s := range stats {
+		seqGaps := []Gap{}
+		for ; gapIndex < len(gaps) && gaps[gapIndex].SeqName == s.name; gapIndex++ {
+			seqGaps = append(seqGaps, gaps[gapIndex])
+		}
+		// the part of the sequence described by the object, without any
+		// gaps at the start or end
+		seqStart := 1
+		seqEnd := s.length
+		if len(seqGaps) > 0 && seqGaps[0].Start == 1 {
+			seqStart = seqGaps[0].End + 1
+			seqGaps = seqGaps[1:]
+		}
+		if len(seqGaps) > 0 && seqGaps[len(seqGaps)-1].End == s.length {
+			seqEnd = seqGaps[len(seqGaps)-1].Start - 1
+			seqGaps = seqGaps[:len(seqGaps)-1]
+		}
+		if seqStart > seqEnd {
+			log.Printf("Warning: sequence %v is all gap, so it is not in the AGP file", s.name)
+			continue
+		} else if seqStart > 1 || seqEnd < s.length {
+			trimmedSeqs++
+		}
+
+		lines := []agpLine{}
+		// position is in the object, and seqPosition in the sequence
+		position := 1
+		seqPosition := seqStart
+		contigs := 0
+		addLine := func(a agpLine, length int) {
+			a.object = s.name
+			a.objectBeg = position
+			a.objectEnd = position + length - 1
+			a.partNumber = len(lines) + 1
+			lines = append(lines, a)
+			position += length
+		}
+		addContig := func(end int) {
+			contigs++
+			length := end - seqPosition + 1
+			addLine(agpLine{componentType: "W", componentID: fmt.Sprintf("%v_%d", s.name, contigs), componentBeg: 1, componentEnd: length, orientation: "+"}, length)
+		}
+
+		for _, gap := range seqGaps {
+			if gap.Start > seqPosition {
+				addContig(gap.Start - 1)
+			}
+			a := agpLine{componentType: "N", gapLength: gap.Length(), linkageEvidence: "na"}
+			a.gapType, a.linkage = agpGapType(gap.GapType)
+			if a.linkage == "yes" {
+				a.linkageEvidence = "unspecified"
+			}
+			if gap.EstimatedLength == "unknown" {
+				a.componentType = "U"
+				if a.gapLength != agpUnknownGapLength {
+					resizedGaps++
+					a.gapLength = agpUnknownGapLength
+				}
+			}
+			addLine(a, a.gapLength)
+			seqPosition = gap.End + 1
+		}
+		addContig(seqEnd)
+
+		for _, a := range lines {
+			if _, err := fout.WriteString(a.String()); err != nil {
+				return fmt.Errorf("error writing to file %v: %w", outfile, err)
+			}
+		}
+	}
+	if trimmedSeqs > 0 {
+		log.Printf("Warning: %d sequence(s) start or end with a gap, which is not allowed in AGP. Their objects in %v do not include those gaps", trimmedSeqs, outfile)
+	}
+	if resizedGaps > 0 {
+		log.Printf("Warning: %d gap(s) of unknown length are not 100 long, but are written as 100 long because AGP requires it. Object coordinates in %v after them are different to the sequence coordinates", resizedGaps, outfile)
+	}
+	return nil
+}
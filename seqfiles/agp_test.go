@@ -0,0 +1,128 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAGPLine(t *testing.T) {
+	got, err := parseAGPLine("scaf1\t16\t24\t3\tW\tctg2\t2\t10\t-\n")
+	require.NoError(t, err, "Error parsing AGP component line")
+	require.Equal(t, agpLine{object: "scaf1", objectBeg: 16, objectEnd: 24, partNumber: 3, componentType: "W", componentID: "ctg2", componentBeg: 2, componentEnd: 10, orientation: "-"}, got, "Wrong AGP component line")
+	require.Equal(t, "scaf1\t16\t24\t3\tW\tctg2\t2\t10\t-\n", got.String(), "Wrong AGP component line string")
+
+	got, err = parseAGPLine("scaf1\t11\t15\t2\tN\t5\tscaffold\tyes\tpaired-ends\r\n")
+	require.NoError(t, err, "Error parsing AGP gap line")
+	require.True(t, got.isGap(), "Should be a gap line")
+	require.Equal(t, agpLine{object: "scaf1", objectBeg: 11, objectEnd: 15, partNumber: 2, componentType: "N", gapLength: 5, gapType: "scaffold", linkage: "yes", linkageEvidence: "paired-ends"}, got, "Wrong AGP gap line")
+
+	bad := []string{
+		"scaf1\t1\t10\t1\tW\tctg1\t1\t10",
+		"scaf1\t0\t10\t1\tW\tctg1\t1\t10\t+",
+		"scaf1\t10\t1\t1\tW\tctg1\t1\t10\t+",
+		"scaf1\t1\t10\t1\tW\tctg1\t1\t9\t+",
+		"scaf1\t1\t10\t1\tW\tctg1\t1\t10\tx",
+		"scaf1\t1\t10\t1\tN\t9\tscaffold\tyes\tna",
+		"scaf1\t1\t10\t1\tN\tx\tscaffold\tyes\tna",
+	}
+	for _, line := range bad {
+		_, err := parseAGPLine(line)
+		require.Error(t, err, "Should not parse AGP line %q", line)
+	}
+}
+
+func TestGapTypeConversion(t *testing.T) {
+	for _, agpType := range []string{"scaffold", "contig", "centromere", "telomere"} {
+		linkage := "no"
+		if agpType == "scaffold" {
+			linkage = "yes"
+		}
+		gotType, gotLinkage := agpGapType(genbankGapType(agpType, linkage))
+		require.Equal(t, agpType, gotType, "Wrong AGP gap type after conversion")
+		require.Equal(t, linkage, gotLinkage, "Wrong AGP linkage after conversion")
+	}
+	require.Equal(t, "repeat within scaffold", genbankGapType("repeat", "yes"), "Wrong repeat gap type")
+	require.Equal(t, "repeat between scaffolds", genbankGapType("repeat", "no"), "Wrong repeat gap type")
+	gotType, gotLinkage := agpGapType("unknown")
+	require.Equal(t, "scaffold", gotType, "Wrong default AGP gap type")
+	require.Equal(t, "yes", gotLinkage, "Wrong default AGP linkage")
+}
+
+func TestLiftFeature(t *testing.T) {
+	feature := Feature{SeqID: "ctg", Source: "src", Type: "gene", Start: 3, End: 8, Score: ".", Strand: "+", Phase: ".", Attributes: []Attribute{{Key: "ID", Values: []string{"gene1"}}}}
+	got := liftFeature(feature, "scaf", 2, 10, 16, false)
+	require.Equal(t, "scaf", got.SeqID, "Wrong lifted sequence")
+	require.Equal(t, 17, got.Start, "Wrong lifted start")
+	require.Equal(t, 22, got.End, "Wrong lifted end")
+	require.Equal(t, "+", got.Strand, "Wrong lifted strand")
+
+	got = liftFeature(feature, "scaf", 2, 10, 16, true)
+	require.Equal(t, 18, got.Start, "Wrong reverse lifted start")
+	require.Equal(t, 23, got.End, "Wrong reverse lifted end")
+	require.Equal(t, "-", got.Strand, "Wrong reverse lifted strand")
+	got.Attributes[0].Values[0] = "changed"
+	require.Equal(t, "gene1", feature.Attributes[0].Values[0], "Original feature attributes changed")
+}
+
+func TestImportWithAGP(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "agp.in.fa")
+	outprefix := "tmp.test.importWithAGP"
+	options := DefaultImportOptions()
+	options.AnnotationFile = filepath.Join("seqfiles_testdata", "agp.in.gff")
+	options.AGPFile = filepath.Join("seqfiles_testdata", "agp.in.agp")
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file with AGP")
+
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, suffix := range []string{".fa", ".gff"} {
+		expectFile := filepath.Join("seqfiles_testdata", "agp.expect"+suffix)
+		filesEqual, err := cmp.CompareFile(expectFile, outprefix+suffix)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+suffix)
+		require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+suffix)
+	}
+
+	agpOut := outprefix + ".agp"
+	require.NoError(t, WriteAGP(outprefix+".fa", outprefix+".gff", agpOut, 1, ""), "Error writing AGP file")
+	expectFile := filepath.Join("seqfiles_testdata", "agp.expect.agp")
+	filesEqual, err := cmp.CompareFile(expectFile, agpOut)
+	require.NoError(t, err, "Error comparing files %s, %s", expectFile, agpOut)
+	require.True(t, filesEqual, "File %s expected contents incorrect", agpOut)
+
+	badAGP := outprefix + ".bad.agp"
+	require.NoError(t, os.WriteFile(badAGP, []byte("scaf1\t1\t10\t1\tW\tnot_a_contig\t1\t10\t+\n"), 0644), "Error writing file %v", badAGP)
+	options.AGPFile = badAGP
+	require.ErrorIs(t, ParseSeqFileWithOptions(infile, outprefix, options), ErrBadFormat, "Should fail with unknown AGP component")
+	require.False(t, utils.FileExists(outprefix+".tmp.agp.fa"), "Temporary file not deleted")
+
+	require.NoError(t, os.WriteFile(badAGP, []byte("scaf1\t2\t11\t1\tW\tctg1\t1\t10\t+\n"), 0644), "Error writing file %v", badAGP)
+	require.ErrorIs(t, ParseSeqFileWithOptions(infile, outprefix, options), ErrBadFormat, "Should fail with AGP object not starting at 1")
+
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".agp", ".bad.agp"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
+
+func TestWriteAGPTerminalAndUnknownGaps(t *testing.T) {
+	// gaps at the start and end of seq1, which are left out, an unknown
+	// length gap that is not 100 long, and a sequence that is all gap
+	fastaFile := "tmp.test.writeAGPGaps.fa"
+	annotFile := "tmp.test.writeAGPGaps.gff"
+	agpFile := "tmp.test.writeAGPGaps.agp"
+	require.NoError(t, os.WriteFile(fastaFile, []byte(">seq1\nNNACGTNNNNNACGTACNNN\n>seq2\nNNNN\n>seq3\nACGT\n"), 0644), "Error writing file %v", fastaFile)
+	require.NoError(t, os.WriteFile(annotFile, []byte("##gff-version 3\nseq1\tAGP\tassembly_gap\t7\t11\t.\t+\t.\tgap_type=within scaffold;estimated_length=unknown\n"), 0644), "Error writing file %v", annotFile)
+	require.NoError(t, WriteAGP(fastaFile, annotFile, agpFile, 1, ""), "Error writing AGP file")
+	got, err := os.ReadFile(agpFile)
+	require.NoError(t, err, "Error reading file %v", agpFile)
+	expect := "##agp-version\t2.1\n" +
+		"seq1\t1\t4\t1\tW\tseq1_1\t1\t4\t+\n" +
+		"seq1\t5\t104\t2\tU\t100\tscaffold\tyes\tunspecified\n" +
+		"seq1\t105\t110\t3\tW\tseq1_2\t1\t6\t+\n" +
+		"seq3\t1\t4\t1\tW\tseq3_1\t1\t4\t+\n"
+	require.Equal(t, expect, string(got), "Wrong AGP file")
+	for _, filename := range []string{fastaFile, annotFile, agpFile} {
+		utils.DeleteFileIfExists(filename)
+	}
+}
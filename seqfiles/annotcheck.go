@@ -0,0 +1,169 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// What to do with annotation that does not match the imported sequences
+type AnnotationPolicy uint64
+
+const (
+	// Features that go past the end of their sequence are clipped to
+	// the sequence length. Features on unknown sequences are dropped
+	AnnotClip AnnotationPolicy = iota
+	// Features on unknown sequences, or that go past the end of their
+	// sequence, are dropped
+	AnnotDrop
+	// Any feature on an unknown sequence, or that goes past the end of
+	// its sequence, is a fatal error
+	AnnotFail
+)
+
+func ParseAnnotationPolicy(policy string) (AnnotationPolicy, error) {
+	switch policy {
+	case "clip":
+		return AnnotClip, nil
+	case "drop":
+		return AnnotDrop, nil
+	case "fail":
+		return AnnotFail, nil
+	}
+	return AnnotClip, fmt.Errorf("Unknown annotation policy '%v'. Must be one of: clip, drop, fail", policy)
+}
+
+type annotationProblem struct {
+	lineNumber int
+	seqID      string
+	featType   string
+	start      int
+	end        int
+	problem    string
+	action     string
+}
+
+// Returns the sequence names in the order they appear in the file, and a
+// map of name -> sequence length. Assumes that each sequence is on one line
+func getSeqLengthsFromSingleLineFasta(infile string) ([]string, map[string]int, error) {
+	names := []string{}
+	lengths := map[string]int{}
+	err := forEachFastaPiece(infile, func(line string, seqOffset int64) error {
+		names = append(names, strings.TrimPrefix(strings.Fields(line)[0], ">"))
+		lengths[names[len(names)-1]] = 0
+		return nil
+	}, func(piece []byte) error {
+		if len(names) > 0 {
+			lengths[names[len(names)-1]] += len(piece)
+		}
+		return nil
+	})
+	return names, lengths, err
+}
+
+// Checks that every feature in annotFile is on a sequence in fastaFile,
+// and does not go past the end of the sequence. Also checks the
+// ##sequence-region pragmas. Problems are dealt with according to policy,
+// and annotFile is rewritten. If there were any problems, they are written
+// to reportFile. Otherwise reportFile is deleted if it exists.
+// Returns the number of problems found
+func checkAnnotation(fastaFile string, annotFile string, reportFile string, policy AnnotationPolicy) (int, error) {
+	if err := utils.DeleteFileIfExists(reportFile); err != nil {
+		return 0, err
+	}
+	if !utils.FileExists(annotFile) {
+		return 0, nil
+	}
+	_, seqLengths, err := getSeqLengthsFromSingleLineFasta(fastaFile)
+	if err != nil {
+		return 0, err
+	}
+	problems := []annotationProblem{}
+	lineNumber := 0
+
+	addProblem := func(p annotationProblem) error {
+		if policy == AnnotFail {
+			return fmt.Errorf("%w: annotation file %v line %d (%v %v:%d-%d): %v", ErrAnnotationMismatch, annotFile, p.lineNumber, p.featType, p.seqID, p.start, p.end, p.problem)
+		}
+		problems = append(problems, p)
+		return nil
+	}
+
+	err = rewriteFileLines(annotFile, func(line string) (string, error) {
+		lineNumber++
+		if strings.HasPrefix(line, "##sequence-region") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				return "", addProblem(annotationProblem{lineNumber, "", "##sequence-region", 0, 0, "pragma does not have 3 values", "removed pragma"})
+			}
+			start, _ := strconv.Atoi(fields[2])
+			end, _ := strconv.Atoi(fields[3])
+			length, exists := seqLengths[fields[1]]
+			if !exists {
+				return "", addProblem(annotationProblem{lineNumber, fields[1], "##sequence-region", start, end, "sequence not found", "removed pragma"})
+			}
+			if start != 1 || end != length {
+				if err := addProblem(annotationProblem{lineNumber, fields[1], "##sequence-region", start, end, fmt.Sprintf("sequence length is %d", length), "changed pragma to match sequence"}); err != nil {
+					return "", err
+				}
+			}
+			return fmt.Sprintf("##sequence-region %v 1 %d\n", fields[1], length), nil
+		} else if strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+
+		feature, gffProblems := parseGFF3Line(line, lineNumber)
+		if len(gffProblems) > 0 && !gffProblems[len(gffProblems)-1].fixed {
+			return "", fmt.Errorf("%w: annotation file %v, %v", ErrBadFormat, annotFile, gffProblems[len(gffProblems)-1])
+		}
+		problem := annotationProblem{lineNumber, feature.SeqID, feature.Type, feature.Start, feature.End, "", ""}
+		length, exists := seqLengths[feature.SeqID]
+		if !exists {
+			problem.problem = "sequence not found"
+			problem.action = "removed feature"
+			return "", addProblem(problem)
+		}
+		if feature.End <= length {
+			return line, nil
+		}
+
+		problem.problem = fmt.Sprintf("feature goes past end of sequence (length %d)", length)
+		if policy == AnnotDrop || feature.Start > length {
+			problem.action = "removed feature"
+			return "", addProblem(problem)
+		}
+		problem.action = fmt.Sprintf("changed end to %d", length)
+		if err := addProblem(problem); err != nil {
+			return "", err
+		}
+		feature.End = length
+		return feature.GFF3Line(), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(problems) > 0 {
+		if err := writeAnnotationProblems(problems, reportFile); err != nil {
+			return 0, err
+		}
+		log.Printf("Warning: %d annotation line(s) did not match the sequences. Details written to %v", len(problems), reportFile)
+	}
+	return len(problems), nil
+}
+
+func writeAnnotationProblems(problems []annotationProblem, outfile string) (err error) {
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	fout.WriteString("line\tseqid\ttype\tstart\tend\tproblem\taction\n")
+	for _, p := range problems {
+		fout.WriteString(fmt.Sprintf("%d\t%v\t%v\t%d\t%d\t%v\t%v\n", p.lineNumber, p.seqID, p.featType, p.start, p.end, p.problem, p.action))
+	}
+	return nil
+}
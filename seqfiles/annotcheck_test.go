@@ -0,0 +1,60 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAnnotationPolicy(t *testing.T) {
+	for name, expect := range map[string]AnnotationPolicy{"clip": AnnotClip, "drop": AnnotDrop, "fail": AnnotFail} {
+		got, err := ParseAnnotationPolicy(name)
+		require.NoError(t, err, "Error parsing annotation policy %v", name)
+		require.Equal(t, expect, got, "Wrong annotation policy from %v", name)
+	}
+	_, err := ParseAnnotationPolicy("foo")
+	require.Error(t, err, "Should have got error parsing bad annotation policy")
+}
+
+func TestGetSeqLengthsFromSingleLineFasta(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "checkAnnotation.in.fa")
+	names, lengths, err := getSeqLengthsFromSingleLineFasta(infile)
+	require.NoError(t, err, "Error getting sequence lengths")
+	require.Equal(t, []string{"seq1", "seq2"}, names, "Wrong sequence names")
+	require.Equal(t, map[string]int{"seq1": 10, "seq2": 8}, lengths, "Wrong sequence lengths")
+}
+
+func TestCheckAnnotation(t *testing.T) {
+	fastaFile := filepath.Join("seqfiles_testdata", "checkAnnotation.in.fa")
+	annotFile := "tmp.test.checkAnnotation.gff"
+	reportFile := "tmp.test.checkAnnotation.tsv"
+	cmp := equalfile.New(nil, equalfile.Options{})
+
+	for _, policy := range []string{"clip", "drop"} {
+		require.NoError(t, utils.CopyFile(filepath.Join("seqfiles_testdata", "checkAnnotation.in.gff"), annotFile), "Error copying file")
+		p, _ := ParseAnnotationPolicy(policy)
+		problems, err := checkAnnotation(fastaFile, annotFile, reportFile, p)
+		require.NoError(t, err, "Error checking annotation")
+		require.Equal(t, 5, problems, "Wrong number of annotation problems")
+
+		expectFile := filepath.Join("seqfiles_testdata", "checkAnnotation."+policy+".expect.gff")
+		filesEqual, err := cmp.CompareFile(expectFile, annotFile)
+		require.NoError(t, err, "Error comparing annotation files %s, %s", expectFile, annotFile)
+		require.True(t, filesEqual, "Annotation file %s expected contents incorrect", annotFile)
+
+		expectFile = filepath.Join("seqfiles_testdata", "checkAnnotation."+policy+".expect.tsv")
+		filesEqual, err = cmp.CompareFile(expectFile, reportFile)
+		require.NoError(t, err, "Error comparing report files %s, %s", expectFile, reportFile)
+		require.True(t, filesEqual, "Report file %s expected contents incorrect", reportFile)
+	}
+
+	// running again on the fixed file should find no problems and delete
+	// the old report
+	problems, err := checkAnnotation(fastaFile, annotFile, reportFile, AnnotFail)
+	require.NoError(t, err, "Error checking annotation")
+	require.Equal(t, 0, problems, "Should be no annotation problems")
+	require.False(t, utils.FileExists(reportFile), "Report file should have been deleted %v", reportFile)
+	utils.DeleteFileIfExists(annotFile)
+}
@@ -0,0 +1,169 @@
+package seqfiles
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Returns true if the line is a BED header line that has no feature
+func isBEDHeaderLine(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") || len(strings.TrimSpace(line)) == 0
+}
+
+func splitBEDLine(line string) []string {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.Contains(line, "\t") {
+		return strings.Split(line, "\t")
+	}
+	return strings.Fields(line)
+}
+
+// Returns true if the line looks like a BED feature line: at least 3
+// columns, where the second and third are start and end coordinates
+func isBEDLine(line string) bool {
+	fields := splitBEDLine(line)
+	if len(fields) < 3 || len(fields) > 12 {
+		return false
+	}
+	start, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	end, err := strconv.Atoi(fields[2])
+	return err == nil && 0 <= start && start <= end
+}
+
+func parseBEDIntList(s string) ([]int, error) {
+	values := []int{}
+	for _, field := range strings.Split(strings.TrimRight(s, ","), ",") {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Converts one line of a BED file to GFF3 features. BED12 lines give a
+// transcript feature plus one exon feature per block. Other lines give
+// one region feature. Zero-length intervals (eg insertion sites) give no
+// features, because they cannot be GFF3 features
+func bedLineToFeatures(fields []string, usedIDs map[string]bool) ([]Feature, error) {
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected at least 3 columns but got %d", len(fields))
+	}
+	start, err := strconv.Atoi(fields[1])
+	if err != nil || start < 0 {
+		return nil, fmt.Errorf("start coordinate must be an integer >= 0, got '%v'", fields[1])
+	}
+	end, err := strconv.Atoi(fields[2])
+	if err != nil || end < start {
+		return nil, fmt.Errorf("end coordinate must be an integer >= start, got '%v'", fields[2])
+	} else if end == start {
+		return []Feature{}, nil
+	}
+	feature := Feature{
+		SeqID:  fields[0],
+		Source: ".",
+		Type:   "region",
+		Start:  start + 1,
+		End:    end,
+		Score:  ".",
+		Strand: ".",
+		Phase:  ".",
+	}
+
+	name := ""
+	if len(fields) > 3 && fields[3] != "." && fields[3] != "" {
+		name = fields[3]
+	}
+	if len(fields) > 4 {
+		if _, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			feature.Score = fields[4]
+		}
+	}
+	if len(fields) > 5 && (fields[5] == "+" || fields[5] == "-") {
+		feature.Strand = fields[5]
+	}
+
+	var blockSizes, blockStarts []int
+	if len(fields) >= 12 {
+		blockCount, err := strconv.Atoi(fields[9])
+		if err != nil {
+			return nil, fmt.Errorf("block count must be an integer, got '%v'", fields[9])
+		}
+		blockSizes, err = parseBEDIntList(fields[10])
+		if err != nil {
+			return nil, fmt.Errorf("error getting block sizes from '%v'", fields[10])
+		}
+		blockStarts, err = parseBEDIntList(fields[11])
+		if err != nil {
+			return nil, fmt.Errorf("error getting block starts from '%v'", fields[11])
+		}
+		if len(blockSizes) != blockCount || len(blockStarts) != blockCount {
+			return nil, fmt.Errorf("expected %d block sizes and starts, got '%v' and '%v'", blockCount, fields[10], fields[11])
+		}
+		feature.Type = "transcript"
+	}
+
+	idBase := name
+	if idBase == "" {
+		idBase = fmt.Sprintf("%v:%d-%d", feature.SeqID, feature.Start, feature.End)
+	}
+	id := uniqueID(idBase, usedIDs)
+	feature.Attributes = []Attribute{{Key: "ID", Values: []string{id}}}
+	if name != "" {
+		feature.Attributes = append(feature.Attributes, Attribute{Key: "Name", Values: []string{name}})
+	}
+	features := []Feature{feature}
+
+	for i := range blockStarts {
+		exon := feature
+		exon.Type = "exon"
+		exon.Score = "."
+		exon.Start = start + blockStarts[i] + 1
+		exon.End = start + blockStarts[i] + blockSizes[i]
+		if exon.Start < feature.Start || exon.End > feature.End {
+			return nil, fmt.Errorf("block %d (%d-%d) is outside the feature", i+1, exon.Start, exon.End)
+		}
+		exon.Attributes = []Attribute{
+			{Key: "ID", Values: []string{uniqueID(fmt.Sprintf("%v.exon%d", id, i+1), usedIDs)}},
+			{Key: "Parent", Values: []string{id}},
+		}
+		features = append(features, exon)
+	}
+	return features, nil
+}
+
+// Reads a BED file (BED3 up to BED12) and returns its features converted
+// to GFF3
+func bedToFeatures(infile string) ([]Feature, error) {
+	features := []Feature{}
+	usedIDs := map[string]bool{}
+	lineNumber := 0
+	zeroLength := 0
+
+	err := forEachLine(infile, func(line string) error {
+		lineNumber++
+		if isBEDHeaderLine(line) {
+			return nil
+		}
+
+		newFeatures, err := bedLineToFeatures(splitBEDLine(line), usedIDs)
+		if err != nil {
+			log.Printf("Warning: problem in BED file %v, line %d: %v (line skipped)", infile, lineNumber, err)
+			return nil
+		} else if len(newFeatures) == 0 {
+			zeroLength++
+		}
+		features = append(features, newFeatures...)
+		return nil
+	})
+	if zeroLength > 0 {
+		log.Printf("Warning: skipped %d zero-length interval(s) in BED file %v, because they cannot be GFF3 features", zeroLength, infile)
+	}
+	return features, err
+}
@@ -0,0 +1,56 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsBEDLine(t *testing.T) {
+	require.True(t, isBEDLine("chr1\t0\t10\n"), "Should be BED line")
+	require.True(t, isBEDLine("chr1 0 10 name\n"), "Should be BED line")
+	require.False(t, isBEDLine("chr1\t10\t1\n"), "Should not be BED line")
+	require.False(t, isBEDLine("chr1\tx\t10\n"), "Should not be BED line")
+	require.False(t, isBEDLine("chr1\t1\n"), "Should not be BED line")
+}
+
+func TestBedLineToFeatures(t *testing.T) {
+	usedIDs := map[string]bool{}
+	got, err := bedLineToFeatures([]string{"chr1", "0", "10"}, usedIDs)
+	require.NoError(t, err, "Error converting BED line")
+	require.Equal(t, 1, len(got), "Wrong number of features from BED line")
+	require.Equal(t, "chr1\t.\tregion\t1\t10\t.\t.\t.\tID=chr1:1-10\n", got[0].GFF3Line(), "Error converting BED line")
+
+	got, err = bedLineToFeatures([]string{"chr1", "10", "10", "insertion"}, usedIDs)
+	require.NoError(t, err, "Zero-length BED interval should not be an error")
+	require.Equal(t, 0, len(got), "Zero-length BED interval should give no features")
+
+	for _, bad := range [][]string{
+		{"chr1", "0"},
+		{"chr1", "-1", "10"},
+		{"chr1", "10", "9"},
+		{"chr1", "0", "100", "name", "0", "+", "0", "100", "0", "2", "10,20", "0"},
+		{"chr1", "0", "100", "name", "0", "+", "0", "100", "0", "1", "10", "95"},
+	} {
+		_, err = bedLineToFeatures(bad, usedIDs)
+		require.Error(t, err, "Should have got error converting BED line %v", bad)
+	}
+}
+
+func TestBEDToGFF3(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "bed.in.bed")
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
+	require.Equal(t, BED, fileType, "Did not get filetype of BED")
+	outfile := "tmp.test.bedToGFF3.gff"
+	utils.DeleteFileIfExists(outfile)
+	require.NoError(t, ConvertAnnotationFile(infile, outfile, false), "Error converting annotation file")
+	expectFile := filepath.Join("seqfiles_testdata", "bed.expect.gff")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outfile)
+	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFile, outfile)
+	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfile)
+	utils.DeleteFileIfExists(outfile)
+}
@@ -0,0 +1,220 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Length of the gap made for "gap()" in a CONTIG location, which is a gap
+// of unknown length
+const unknownContigGapLength = 100
+
+// Returns the parts of a CONTIG location, eg
+// "join(AB000001.1:1..20,gap(5),complement(AB000002.1:1..10))" gives
+// "AB000001.1:1..20", "gap(5)" and "complement(AB000002.1:1..10)"
+func contigLocationParts(contig string) []string {
+	contig = strings.Join(strings.Fields(contig), "")
+	if strings.HasPrefix(contig, "join(") && strings.HasSuffix(contig, ")") {
+		contig = contig[len("join(") : len(contig)-1]
+	}
+	parts := []string{}
+	depth := 0
+	start := 0
+	for i, c := range contig {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, contig[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, contig[start:])
+}
+
+// Returns the AGP lines that describe how the scaffold called object is
+// made, from its CONTIG location. Gaps of unknown length, "gap()" or
+// "gap(unk100)", are type U gaps. Component IDs are the accessions in the
+// location
+func agpLinesFromContig(contig string, object string) ([]agpLine, error) {
+	lines := []agpLine{}
+	position := 1
+	for i, part := range contigLocationParts(contig) {
+		a := agpLine{object: object, objectBeg: position, partNumber: i + 1}
+		if gap, isGap := strings.CutPrefix(part, "gap("); isGap {
+			gap = strings.TrimSuffix(gap, ")")
+			a.componentType = "N"
+			a.gapType = "scaffold"
+			a.linkage = "yes"
+			if gap == "" {
+				a.componentType = "U"
+				gap = strconv.Itoa(unknownContigGapLength)
+			} else if length, isUnknown := strings.CutPrefix(gap, "unk"); isUnknown {
+				a.componentType = "U"
+				gap = length
+			}
+			var err error
+			a.gapLength, err = strconv.Atoi(gap)
+			if err != nil || a.gapLength < 1 {
+				return nil, fmt.Errorf("bad gap '%v'", part)
+			}
+			a.objectEnd = position + a.gapLength - 1
+		} else {
+			a.componentType = "W"
+			a.orientation = "+"
+			if inner, isComplement := strings.CutPrefix(part, "complement("); isComplement {
+				part = strings.TrimSuffix(inner, ")")
+				a.orientation = "-"
+			}
+			i := strings.LastIndex(part, ":")
+			begString, endString, hasEnd := strings.Cut(part[i+1:], "..")
+			beg, err1 := strconv.Atoi(begString)
+			end, err2 := strconv.Atoi(endString)
+			if i < 1 || !hasEnd || err1 != nil || err2 != nil || beg < 1 || end < beg {
+				return nil, fmt.Errorf("expected accession:start..end but got '%v'", part)
+			}
+			a.componentID = part[:i]
+			a.componentBeg = beg
+			a.componentEnd = end
+			a.objectEnd = position + end - beg
+		}
+		lines = append(lines, a)
+		position = a.objectEnd + 1
+	}
+	return lines, nil
+}
+
+// Deals with the genbank/EMBL records that were imported without a
+// sequence, which are found using their metadata. WGS master records, and
+// records with a CONTIG location that uses records that are not in
+// fastaFile, are skipped: their features are removed from annotFile, and
+// their metadata is marked as skipped. Other records with a CONTIG
+// location are made from the records it uses, in the same way as
+// scaffolds from an AGP file, and replace them in fastaFile and annotFile
+func resolveContigRecords(fastaFile string, annotFile string, metadata []SeqMetadata) error {
+	// CONTIG locations use accession.version, but the records could be
+	// named using another identifier
+	recordNames := map[string]string{}
+	for _, m := range metadata {
+		for _, id := range []string{m.Name, m.Version, m.Accession, m.Locus} {
+			if _, exists := recordNames[id]; m.hasSeq && id != "" && !exists {
+				recordNames[id] = m.Name
+			}
+		}
+	}
+
+	agpLines := []agpLine{}
+	skipped := map[string]bool{}
+	contigRecords := map[string]bool{}
+	for i := range metadata {
+		m := &metadata[i]
+		if m.hasSeq {
+			continue
+		} else if m.Contig == "" {
+			if m.WGS != "" {
+				log.Printf("Warning: record %v is a WGS master record (WGS %v), which has no sequence. Record skipped", m.Name, m.WGS)
+			} else {
+				log.Printf("Warning: record %v has no sequence. Record skipped", m.Name)
+			}
+			m.Skipped = true
+			skipped[m.Name] = true
+			continue
+		}
+
+		lines, err := agpLinesFromContig(m.Contig, m.Name)
+		missing := []string{}
+		for j := range lines {
+			if lines[j].isGap() {
+				continue
+			} else if name, exists := recordNames[lines[j].componentID]; exists {
+				lines[j].componentID = name
+			} else {
+				missing = append(missing, lines[j].componentID)
+			}
+		}
+		if err != nil {
+			log.Printf("Warning: record %v has no sequence, and cannot be made from its CONTIG location because of a bad part: %v. Record skipped", m.Name, err)
+		} else if len(missing) > 0 {
+			log.Printf("Warning: record %v has no sequence, and cannot be made from its CONTIG location because these records are not in the file: %v. Record skipped", m.Name, strings.Join(missing, ", "))
+		} else {
+			log.Printf("Record %v has no sequence, so making it from the %d part(s) of its CONTIG location", m.Name, len(lines))
+			agpLines = append(agpLines, lines...)
+			contigRecords[m.Name] = true
+			continue
+		}
+		m.Skipped = true
+		skipped[m.Name] = true
+	}
+	if len(agpLines) == 0 && len(skipped) == 0 {
+		return nil
+	}
+
+	records, err := readSeqsAndAnnotation(fastaFile, annotFile)
+	if err != nil {
+		return err
+	}
+	scaffolds, usedRecords, err := buildScaffolds(agpLines, records, "CONTIG locations")
+	if err != nil {
+		return err
+	}
+	scaffoldsByName := map[string]*SeqRecord{}
+	for _, scaffold := range scaffolds {
+		scaffoldsByName[scaffold.Name] = scaffold
+	}
+	newRecords := scaffolds
+	for _, record := range records {
+		if scaffold, exists := scaffoldsByName[record.Name]; exists && record.Seq == "" {
+			// The features of the record with the CONTIG location are
+			// already in scaffold coordinates
+			addContigRecordFeatures(scaffold, record)
+		} else if !usedRecords[record.Name] && !skipped[record.Name] && !contigRecords[record.Name] {
+			newRecords = append(newRecords, record)
+		}
+	}
+
+	tmpFasta := fastaFile + ".tmp.contig"
+	tmpAnnot := annotFile + ".tmp.contig"
+	if err := writeSeqsAndAnnotation(newRecords, tmpFasta, tmpAnnot); err != nil {
+		utils.DeleteFileIfExists(tmpFasta)
+		utils.DeleteFileIfExists(tmpAnnot)
+		return err
+	}
+	if err := utils.RenameFile(tmpFasta, fastaFile); err != nil {
+		return err
+	}
+	return utils.RenameFile(tmpAnnot, annotFile)
+}
+
+// Adds the features of a record with a CONTIG location to the scaffold
+// made from it. The contig and assembly_gap features made when building
+// the scaffold get the source "CONTIG". The assembly_gap features, and the
+// source features of the records used, are removed if the record has its
+// own
+func addContigRecordFeatures(scaffold *SeqRecord, record *SeqRecord) {
+	hasType := map[string]bool{}
+	for _, f := range record.Features {
+		hasType[f.Type] = true
+	}
+	features := []Feature{}
+	for _, f := range scaffold.Features {
+		if f.Source == "AGP" {
+			if hasType["assembly_gap"] && f.Type == "assembly_gap" {
+				continue
+			}
+			f.Source = "CONTIG"
+		} else if hasType["source"] && f.Type == "source" {
+			continue
+		}
+		features = append(features, f)
+	}
+	scaffold.Features = append(features, record.Features...)
+	sortFeatures(scaffold.Features)
+	scaffold.Circular = record.Circular
+}
@@ -0,0 +1,43 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestAgpLinesFromContig(t *testing.T) {
+	got, err := agpLinesFromContig("join(AB1.1:1..20,gap(5),complement(AB2.1:3..10),gap(),gap(unk50), AB3:1..2)", "scaf")
+	require.NoError(t, err, "Error getting AGP lines")
+	expect := []agpLine{
+		{object: "scaf", objectBeg: 1, objectEnd: 20, partNumber: 1, componentType: "W", componentID: "AB1.1", componentBeg: 1, componentEnd: 20, orientation: "+"},
+		{object: "scaf", objectBeg: 21, objectEnd: 25, partNumber: 2, componentType: "N", gapLength: 5, gapType: "scaffold", linkage: "yes"},
+		{object: "scaf", objectBeg: 26, objectEnd: 33, partNumber: 3, componentType: "W", componentID: "AB2.1", componentBeg: 3, componentEnd: 10, orientation: "-"},
+		{object: "scaf", objectBeg: 34, objectEnd: 133, partNumber: 4, componentType: "U", gapLength: 100, gapType: "scaffold", linkage: "yes"},
+		{object: "scaf", objectBeg: 134, objectEnd: 183, partNumber: 5, componentType: "U", gapLength: 50, gapType: "scaffold", linkage: "yes"},
+		{object: "scaf", objectBeg: 184, objectEnd: 185, partNumber: 6, componentType: "W", componentID: "AB3", componentBeg: 1, componentEnd: 2, orientation: "+"},
+	}
+	require.Equal(t, expect, got, "Wrong AGP lines")
+
+	for _, contig := range []string{"join(AB1.1:1..20,gap(x))", "join(AB1.1:20..1)", "join(AB1.1)", "join(1..20)"} {
+		_, err := agpLinesFromContig(contig, "scaf")
+		require.Error(t, err, "Should not get AGP lines from %v", contig)
+	}
+}
+
+func TestImportContigRecords(t *testing.T) {
+	outprefix := "tmp.test.importContigRecords"
+	require.NoError(t, ParseSeqFile(filepath.Join("seqfiles_testdata", "contig.in.gbk"), outprefix), "Error importing file")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, suffix := range []string{".fa", ".gff", ".metadata.json"} {
+		expectFile := filepath.Join("seqfiles_testdata", "contig.expect"+suffix)
+		filesEqual, err := cmp.CompareFile(expectFile, outprefix+suffix)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+suffix)
+		require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+suffix)
+	}
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".annotation_problems.tsv", ".metadata.json", ".faa"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
@@ -0,0 +1,226 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Region is part of a sequence to extract. Start and End are 1-based and
+// inclusive. End = 0 means the end of the sequence
+type Region struct {
+	SeqName string
+	Start   int
+	End     int
+	// Name of the extracted sequence. If empty, it is made from the
+	// sequence name and coordinates
+	Name string
+	// If true, the region is reverse complemented
+	Reverse bool
+}
+
+// Returns the name of the extracted sequence, which has the given length.
+// This is Name if set, otherwise seqname:start-end (or seqname for a
+// whole sequence), with _rc added if reverse complemented. End = 0 is
+// named using the end of the sequence
+func (r *Region) outputName(seqLength int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	name := r.SeqName
+	if r.Start != 1 || (r.End != 0 && r.End != seqLength) {
+		end := r.End
+		if end == 0 {
+			end = seqLength
+		}
+		name = fmt.Sprintf("%v:%d-%d", r.SeqName, r.Start, end)
+	}
+	if r.Reverse {
+		name += "_rc"
+	}
+	return name
+}
+
+var regionCoordsRegex = regexp.MustCompile(`^[0-9,]+(-[0-9,]+)?$`)
+
+// ParseRegion parses a region in the same style as samtools faidx:
+// name (the whole sequence), name:start (start to the end of the
+// sequence), or name:start-end. Coordinates are 1-based and can contain
+// commas. A name can contain ':' if it is not followed by coordinates
+func ParseRegion(region string) (Region, error) {
+	r := Region{SeqName: region, Start: 1}
+	i := strings.LastIndex(region, ":")
+	if i < 0 || !regionCoordsRegex.MatchString(region[i+1:]) {
+		return r, nil
+	}
+	r.SeqName = region[:i]
+	if r.SeqName == "" {
+		return r, fmt.Errorf("%w: no sequence name in region '%v'", ErrBadRegion, region)
+	}
+	startString, endString, hasEnd := strings.Cut(strings.ReplaceAll(region[i+1:], ",", ""), "-")
+	var err error
+	r.Start, err = strconv.Atoi(startString)
+	if err != nil || r.Start < 1 {
+		return r, fmt.Errorf("%w: start of region '%v' must be an integer >= 1", ErrBadRegion, region)
+	}
+	if hasEnd {
+		r.End, err = strconv.Atoi(endString)
+		if err != nil || r.End < r.Start {
+			return r, fmt.Errorf("%w: end of region '%v' must be an integer >= start", ErrBadRegion, region)
+		}
+	}
+	return r, nil
+}
+
+// RegionsFromBED returns the regions in a BED file. The name (column 4)
+// is used as the name of the extracted sequence, and regions on the -
+// strand (column 6) are reverse complemented
+func RegionsFromBED(filename string) ([]Region, error) {
+	regions := []Region{}
+	lineNumber := 0
+	err := forEachLine(filename, func(line string) error {
+		lineNumber++
+		if isBEDHeaderLine(line) {
+			return nil
+		}
+		fields := splitBEDLine(line)
+		if !isBEDLine(line) || fields[1] == fields[2] {
+			return fmt.Errorf("%w: BED file %v, line %d: expected name, start, end with start < end", ErrBadFormat, filename, lineNumber)
+		}
+		start, _ := strconv.Atoi(fields[1])
+		end, _ := strconv.Atoi(fields[2])
+		r := Region{SeqName: fields[0], Start: start + 1, End: end}
+		if len(fields) > 3 && fields[3] != "." {
+			r.Name = fields[3]
+		}
+		r.Reverse = len(fields) > 5 && fields[5] == "-"
+		regions = append(regions, r)
+		return nil
+	})
+	return regions, err
+}
+
+// One line of a FASTA index (.fai) file
+type faiEntry struct {
+	length    int
+	offset    int64
+	lineBases int
+	lineWidth int
+}
+
+func readFastaIndex(filename string) (map[string]faiEntry, error) {
+	index := map[string]faiEntry{}
+	lineNumber := 0
+	err := forEachLine(filename, func(line string) error {
+		lineNumber++
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		if len(fields) < 5 {
+			return fmt.Errorf("%w: FASTA index file %v, line %d: expected 5 columns", ErrBadFormat, filename, lineNumber)
+		}
+		numbers := make([]int64, 4)
+		for i := range numbers {
+			var err error
+			numbers[i], err = strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: FASTA index file %v, line %d: column %d is not an integer", ErrBadFormat, filename, lineNumber, i+2)
+			}
+		}
+		index[fields[0]] = faiEntry{length: int(numbers[0]), offset: numbers[1], lineBases: int(numbers[2]), lineWidth: int(numbers[3])}
+		return nil
+	})
+	return index, err
+}
+
+// Returns bases start to end (1-based, inclusive) of an indexed sequence
+func readIndexedSeq(fasta *os.File, entry faiEntry, start int, end int) (string, error) {
+	if entry.lineBases == 0 {
+		return "", nil
+	}
+	fileOffset := func(position int) int64 {
+		return entry.offset + int64(position/entry.lineBases)*int64(entry.lineWidth) + int64(position%entry.lineBases)
+	}
+	first := fileOffset(start - 1)
+	buffer := make([]byte, fileOffset(end-1)-first+1)
+	if _, err := fasta.ReadAt(buffer, first); err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading file %v: %w", fasta.Name(), err)
+	}
+	seq := strings.NewReplacer("\n", "", "\r", "").Replace(string(buffer))
+	if len(seq) != end-start+1 {
+		return "", fmt.Errorf("%w: FASTA file %v does not match its index", ErrBadFormat, fasta.Name())
+	}
+	return seq, nil
+}
+
+// ExtractRegions writes the regions of the imported sequences in
+// inprefix.fa (which must have the index inprefix.fa.fai) to outprefix.fa.
+// Features from inprefix.gff that overlap a region are written to
+// outprefix.gff, clipped to the region and with coordinates in the
+// extracted sequence
+func ExtractRegions(inprefix string, regions []Region, outprefix string) error {
+	fastaFile := inprefix + ".fa"
+	index, err := readFastaIndex(fastaFile + ".fai")
+	if err != nil {
+		return err
+	}
+	annotation := map[string][]Feature{}
+	if utils.FileExists(inprefix + ".gff") {
+		annotRecords, err := readAllSeqRecords(inprefix+".gff", GFF3)
+		if err != nil {
+			return err
+		}
+		for _, record := range annotRecords {
+			annotation[record.Name] = append(annotation[record.Name], record.Features...)
+		}
+	}
+	fasta, err := os.Open(fastaFile)
+	if err != nil {
+		return fmt.Errorf("error opening file %v: %w", fastaFile, err)
+	}
+	defer fasta.Close()
+
+	records := []*SeqRecord{}
+	usedNames := map[string]bool{}
+	for _, r := range regions {
+		entry, exists := index[r.SeqName]
+		if !exists {
+			return fmt.Errorf("%w: sequence %v not found in %v", ErrBadRegion, r.SeqName, fastaFile)
+		}
+		if r.Start > entry.length {
+			return fmt.Errorf("%w: start %d of region on %v is past the end of the sequence (length %d)", ErrBadRegion, r.Start, r.SeqName, entry.length)
+		} else if r.End > entry.length {
+			return fmt.Errorf("%w: end %d of region on %v is past the end of the sequence (length %d)", ErrBadRegion, r.End, r.SeqName, entry.length)
+		}
+		record := &SeqRecord{Name: r.outputName(entry.length)}
+		if r.End == 0 {
+			r.End = entry.length
+		}
+		record.RegionLength = r.End - r.Start + 1
+		if usedNames[record.Name] {
+			return fmt.Errorf("%w: more than one extracted region is called %v", ErrDuplicateNames, record.Name)
+		}
+		usedNames[record.Name] = true
+		record.Seq, err = readIndexedSeq(fasta, entry, r.Start, r.End)
+		if err != nil {
+			return err
+		}
+		if r.Reverse {
+			record.Seq = string(utils.ReverseComplement([]byte(record.Seq)))
+		}
+
+		region := []seqPiece{{name: record.Name, seqID: r.SeqName, start: r.Start, end: r.End}}
+		for _, feature := range annotation[r.SeqName] {
+			for _, part := range splitFeature(feature, region) {
+				if r.Reverse {
+					part = liftFeature(part, record.Name, 1, record.RegionLength, 1, true)
+				}
+				record.Features = append(record.Features, part)
+			}
+		}
+		records = append(records, record)
+	}
+	return writeSeqsAndAnnotation(records, outprefix+".fa", outprefix+".gff")
+}
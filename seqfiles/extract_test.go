@@ -0,0 +1,76 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		expect Region
+	}{
+		{"seq1", Region{SeqName: "seq1", Start: 1}},
+		{"seq1:10", Region{SeqName: "seq1", Start: 10}},
+		{"seq1:10-20", Region{SeqName: "seq1", Start: 10, End: 20}},
+		{"seq1:1,000-2,000", Region{SeqName: "seq1", Start: 1000, End: 2000}},
+		{"HLA:01:1-5", Region{SeqName: "HLA:01", Start: 1, End: 5}},
+		{"HLA:A*01", Region{SeqName: "HLA:A*01", Start: 1}},
+	}
+	for _, test := range tests {
+		got, err := ParseRegion(test.region)
+		require.NoError(t, err, "Error parsing region %v", test.region)
+		require.Equal(t, test.expect, got, "Wrong region from %v", test.region)
+	}
+	for _, region := range []string{":1-10", "seq1:0-10", "seq1:10-9"} {
+		_, err := ParseRegion(region)
+		require.ErrorIs(t, err, ErrBadRegion, "Should not parse region %v", region)
+	}
+}
+
+func TestExtractRegions(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "split.in.gff")
+	inprefix := "tmp.test.extractRegions.in"
+	require.NoError(t, ParseSeqFile(infile, inprefix, 0), "Error importing file")
+	regions := []Region{{SeqName: "scaf1", Start: 8, End: 25}, {SeqName: "scaf3", Start: 1}}
+	bedRegions, err := RegionsFromBED(filepath.Join("seqfiles_testdata", "extract.in.bed"))
+	require.NoError(t, err, "Error reading BED file")
+	require.Equal(t, []Region{{SeqName: "scaf1", Start: 8, End: 25, Name: "myregion", Reverse: true}}, bedRegions, "Wrong regions from BED file")
+	regions = append(regions, bedRegions...)
+
+	outprefix := "tmp.test.extractRegions.out"
+	require.NoError(t, ExtractRegions(inprefix, regions, outprefix), "Error extracting regions")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, suffix := range []string{".fa", ".gff"} {
+		expectFile := filepath.Join("seqfiles_testdata", "extract.expect"+suffix)
+		filesEqual, err := cmp.CompareFile(expectFile, outprefix+suffix)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+suffix)
+		require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+suffix)
+	}
+
+	require.ErrorIs(t, ExtractRegions(inprefix, []Region{{SeqName: "notaseq", Start: 1}}, outprefix), ErrBadRegion, "Should fail with unknown sequence")
+	require.ErrorIs(t, ExtractRegions(inprefix, []Region{{SeqName: "scaf3", Start: 5, End: 10}}, outprefix), ErrBadRegion, "Should fail with region past end of sequence")
+	require.ErrorIs(t, ExtractRegions(inprefix, []Region{{SeqName: "scaf3", Start: 1}, {SeqName: "scaf3", Start: 1}}, outprefix), ErrDuplicateNames, "Should fail with duplicated region names")
+
+	// a region with no end is named using the end of the sequence, and
+	// an end past the end of the sequence is not clipped
+	index, err := readFastaIndex(inprefix + ".fa.fai")
+	require.NoError(t, err, "Error reading FASTA index")
+	length := index["scaf1"].length
+	require.NoError(t, ExtractRegions(inprefix, []Region{{SeqName: "scaf1", Start: 5}}, outprefix), "Error extracting region")
+	records, err := readAllSeqRecords(outprefix+".fa", FASTA)
+	require.NoError(t, err, "Error reading extracted sequences")
+	require.Equal(t, fmt.Sprintf("scaf1:5-%d", length), records[0].Name, "Wrong name of region with no end")
+	require.Equal(t, length-4, len(records[0].Seq), "Wrong length of region with no end")
+	require.ErrorIs(t, ExtractRegions(inprefix, []Region{{SeqName: "scaf1", Start: 5, End: length + 1}}, outprefix), ErrBadRegion, "Should fail with end past end of sequence")
+
+	for _, prefix := range []string{inprefix, outprefix} {
+		for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".faa"} {
+			utils.DeleteFileIfExists(prefix + suffix)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package seqfiles
+
+import (
+	"io"
+	"log"
+	"strings"
+)
+
+// Offset of FASTQ quality characters: quality = character - 33
+const fastqQualityOffset = 33
+
+// Removes bases from the start and end of a FASTQ record that have
+// quality below minQuality
+func trimByQuality(record *SeqRecord, minQuality int) {
+	start := 0
+	for start < len(record.Quality) && int(record.Quality[start])-fastqQualityOffset < minQuality {
+		start++
+	}
+	end := len(record.Quality)
+	for end > start && int(record.Quality[end-1])-fastqQualityOffset < minQuality {
+		end--
+	}
+	record.Seq = record.Seq[start:end]
+	record.Quality = record.Quality[start:end]
+}
+
+// Changes bases of a FASTQ record with quality below minQuality to N.
+// Returns the number of bases changed
+func maskByQuality(record *SeqRecord, minQuality int) int {
+	seq := []byte(record.Seq)
+	masked := 0
+	for i := range seq {
+		if int(record.Quality[i])-fastqQualityOffset < minQuality {
+			seq[i] = 'N'
+			masked++
+		}
+	}
+	record.Seq = string(seq)
+	return masked
+}
+
+// Converts a FASTQ file to FASTA, with the sequences in upper case unless
+// soft-masked runs are wanted. Reads are trimmed and masked by quality,
+// then reads shorter than the minimum length are skipped, using the
+// Fastq* settings in options
+func parseFastqFile(infile string, outfile string, options ImportOptions) (err error) {
+	reader, err := newReaderWithFormat(infile, FASTQ)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := NewFastaWriter(outfile)
+	if err != nil {
+		return err
+	}
+	defer closeWriter(writer, outfile, &err)
+	trimmed := 0
+	masked := 0
+	skipped := 0
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if options.FastqTrimQuality > 0 {
+			length := len(record.Seq)
+			trimByQuality(record, options.FastqTrimQuality)
+			trimmed += length - len(record.Seq)
+		}
+		if options.FastqMaskQuality > 0 {
+			masked += maskByQuality(record, options.FastqMaskQuality)
+		}
+		if len(record.Seq) == 0 || len(record.Seq) < options.FastqMinLength {
+			skipped++
+			continue
+		}
+		if !options.keepCase() {
+			record.Seq = strings.ToUpper(record.Seq)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	if trimmed+masked+skipped > 0 {
+		log.Printf("FASTQ import of %v: %d bases trimmed, %d bases masked to N, %d reads skipped for being too short", infile, trimmed, masked, skipped)
+	}
+	return nil
+}
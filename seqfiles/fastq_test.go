@@ -0,0 +1,66 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrimAndMaskByQuality(t *testing.T) {
+	record := SeqRecord{Name: "seq", Seq: "ACGTACGT", Quality: "#I#III!#"}
+	trimByQuality(&record, 10)
+	require.Equal(t, SeqRecord{Name: "seq", Seq: "CGTAC", Quality: "I#III"}, record, "Error trimming by quality")
+	require.Equal(t, 1, maskByQuality(&record, 10), "Wrong number of masked bases")
+	require.Equal(t, SeqRecord{Name: "seq", Seq: "CNTAC", Quality: "I#III"}, record, "Error masking by quality")
+
+	record = SeqRecord{Name: "seq", Seq: "AC", Quality: "##"}
+	trimByQuality(&record, 10)
+	require.Equal(t, "", record.Seq, "All bases should be trimmed")
+}
+
+func TestReaderFastqMultiline(t *testing.T) {
+	records := readAllRecords(t, filepath.Join("seqfiles_testdata", "parseFastq.multiline.in.fq"))
+	require.Equal(t, 4, len(records), "Wrong number of records")
+	require.Equal(t, SeqRecord{Name: "read1", Seq: "ACGTACGTAC", Quality: "II#IIIII#!"}, *records[0], "Wrong first record")
+	require.Equal(t, SeqRecord{Name: "read2", Seq: "AAAAAAAAAA", Quality: "@@@@@@@@@@"}, *records[1], "Wrong second record")
+	require.Equal(t, SeqRecord{Name: "read4", Seq: "acgtNNacgt", Quality: "!!IIIIII!!"}, *records[3], "Wrong last record")
+
+	infile := "tmp.test.ReaderFastqMultiline.fq"
+	for _, bad := range []string{
+		"@read1\nACGT\n+read2\nIIII\n",
+		"@read1\nACGT\n+\nIII\n",
+		"@read1\nACGT\n+\nIIIII\n",
+		"@read1\nACGT\n+\nII I\n",
+		"@read1\nACGT\n",
+	} {
+		require.NoError(t, os.WriteFile(infile, []byte(bad), 0644), "Error writing file %v", infile)
+		reader, err := newReaderWithFormat(infile, FASTQ)
+		require.NoError(t, err, "Error making reader for file %v", infile)
+		_, err = reader.Read()
+		require.ErrorIs(t, err, ErrBadFormat, "Should be error reading FASTQ %q", bad)
+		reader.Close()
+	}
+	utils.DeleteFileIfExists(infile)
+}
+
+func TestParseFastqWithOptions(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "parseFastq.multiline.in.fq")
+	outprefix := "tmp.test.ParseFastqWithOptions"
+	options := DefaultImportOptions()
+	options.MinGapLen = 0
+	options.FastqTrimQuality = 10
+	options.FastqMaskQuality = 20
+	options.FastqMinLength = 5
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+	expectFile := filepath.Join("seqfiles_testdata", "parseFastq.multiline.expect.fa")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outprefix+".fa")
+	require.NoError(t, err, "Error comparing FASTA files %s, %s", expectFile, outprefix+".fa")
+	require.True(t, filesEqual, "FASTA file %s expected contents incorrect", outprefix+".fa")
+	utils.DeleteFileIfExists(outprefix + ".fa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+}
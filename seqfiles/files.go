@@ -0,0 +1,159 @@
+package seqfiles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"io"
+	"strings"
+)
+
+// Calls processLine on each line of a file, in order. Lines include the
+// line ending, if there is one, but not a byte order mark. Stops at the
+// first error returned by processLine, and returns it
+func forEachLine(filename string, processLine func(line string) error) error {
+	reader, err := xopen.Ropen(filename)
+	if err != nil {
+		return fmt.Errorf("error opening file %v: %w", filename, err)
+	}
+	defer reader.Close()
+
+	for lineNumber := 0; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading file %v: %w", filename, err)
+		}
+		if lineNumber == 0 {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+		if len(line) > 0 {
+			if err := processLine(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Size of the pieces that long lines are read in by forEachLinePiece, and
+// that sequences are read in when finding gaps
+var readChunkSize = 1 << 20
+
+// Calls processPiece on each line of a file, in order, in the same way as
+// forEachLine, except that lines longer than readChunkSize are passed in
+// pieces, so that memory use does not depend on the length of the lines.
+// lineStart and lineEnd are true for the first and last piece of each
+// line. piece can only be used until processPiece returns
+func forEachLinePiece(filename string, processPiece func(piece []byte, lineStart bool, lineEnd bool) error) error {
+	fin, err := xopen.Ropen(filename)
+	if err != nil {
+		return fmt.Errorf("error opening file %v: %w", filename, err)
+	}
+	defer fin.Close()
+	reader := bufio.NewReaderSize(fin, readChunkSize)
+
+	lineStart := true
+	for first := true; ; first = false {
+		piece, err := reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return fmt.Errorf("error reading file %v: %w", filename, err)
+		}
+		if first {
+			piece = bytes.TrimPrefix(piece, []byte(utf8BOM))
+		}
+		lineEnd := err != bufio.ErrBufferFull
+		// an empty piece is only passed to end a line that filled the
+		// last piece
+		if len(piece) > 0 || !lineStart {
+			if err := processPiece(piece, lineStart, lineEnd); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		lineStart = lineEnd
+	}
+}
+
+// Calls processHeader on each header line of a FASTA file that has each
+// sequence on one line, and processSeq on each piece of the sequence
+// lines. The header line includes the line ending, and seqOffset is the
+// position in the file of the line after it. Sequence pieces do not
+// include line endings. Only the headers are held in memory
+func forEachFastaPiece(filename string, processHeader func(line string, seqOffset int64) error, processSeq func(piece []byte) error) error {
+	var offset int64
+	// Only set while reading a header line
+	var header []byte
+	return forEachLinePiece(filename, func(piece []byte, lineStart bool, lineEnd bool) error {
+		offset += int64(len(piece))
+		if lineStart && piece[0] == '>' {
+			header = []byte{}
+		}
+		if header == nil {
+			return processSeq(bytes.TrimRight(piece, "\r\n"))
+		}
+		header = append(header, piece...)
+		if lineEnd {
+			line := string(header)
+			header = nil
+			return processHeader(line, offset)
+		}
+		return nil
+	})
+}
+
+// For use with defer. Closes a file that was opened for writing, and if
+// there was not already an error, sets err to any error from closing
+func closeWriter(fout io.Closer, filename string, err *error) {
+	if closeErr := fout.Close(); closeErr != nil && *err == nil {
+		*err = fmt.Errorf("error closing file %v: %w", filename, closeErr)
+	}
+}
+
+// Rewrites a file by applying a function to each line. Lines passed to
+// the function include the line ending, and the function must return the
+// new line including its line ending, or "" to remove the line. If the
+// function returns an error, the file is not changed
+func rewriteFileLines(filename string, changeLine func(string) (string, error)) error {
+	var line []byte
+	return rewriteFilePieces(filename, func(piece []byte, lineStart bool, lineEnd bool) ([]byte, error) {
+		line = append(line, piece...)
+		if !lineEnd {
+			return nil, nil
+		}
+		newLine, err := changeLine(string(line))
+		line = line[:0]
+		return []byte(newLine), err
+	})
+}
+
+// Rewrites a file by applying a function to each piece of each line, as
+// passed by forEachLinePiece. The function returns the new piece, which
+// can be empty. If the function returns an error, the file is not changed
+func rewriteFilePieces(filename string, changePiece func(piece []byte, lineStart bool, lineEnd bool) ([]byte, error)) (err error) {
+	tmpOut := filename + ".tmp"
+	fout, err := xopen.Wopen(tmpOut)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", tmpOut, err)
+	}
+
+	err = forEachLinePiece(filename, func(piece []byte, lineStart bool, lineEnd bool) error {
+		newPiece, err := changePiece(piece, lineStart, lineEnd)
+		if err != nil {
+			return err
+		}
+		_, err = fout.Write(newPiece)
+		return err
+	})
+	closeWriter(fout, tmpOut, &err)
+	if err != nil {
+		utils.DeleteFileIfExists(tmpOut)
+		return err
+	}
+	return utils.RenameFile(tmpOut, filename)
+}
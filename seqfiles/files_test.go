@@ -0,0 +1,81 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestForEachLinePiece(t *testing.T) {
+	// a line exactly one chunk long with no newline at the end, a line
+	// longer than a chunk, and an empty line
+	lines := []string{"short\n", strings.Repeat("A", 40) + "\r\n", "\n", strings.Repeat("C", 16)}
+	infile := "tmp.test.forEachLinePiece.txt"
+	require.NoError(t, os.WriteFile(infile, []byte(utf8BOM+strings.Join(lines, "")), 0644), "Error writing file")
+	defer func(chunkSize int) { readChunkSize = chunkSize }(readChunkSize)
+
+	for _, chunkSize := range []int{16, 17, 1 << 20} {
+		readChunkSize = chunkSize
+		got := []string{}
+		err := forEachLinePiece(infile, func(piece []byte, lineStart bool, lineEnd bool) error {
+			require.LessOrEqual(t, len(piece), chunkSize, "Piece longer than chunk size %d", chunkSize)
+			if lineStart {
+				got = append(got, "")
+			}
+			got[len(got)-1] += string(piece)
+			if lineEnd {
+				got[len(got)-1] += "|"
+			}
+			return nil
+		})
+		require.NoError(t, err, "Error reading file in pieces")
+		expect := []string{}
+		for _, line := range lines {
+			expect = append(expect, line+"|")
+		}
+		require.Equal(t, expect, got, "Wrong lines with chunk size %d", chunkSize)
+
+		require.NoError(t, rewriteFileLines(infile, func(line string) (string, error) {
+			if strings.HasPrefix(line, "short") {
+				return "", nil
+			}
+			return strings.ToLower(line), nil
+		}), "Error rewriting file")
+		got2, err := os.ReadFile(infile)
+		require.NoError(t, err, "Error reading file %v", infile)
+		require.Equal(t, strings.ToLower(strings.Join(lines[1:], "")), string(got2), "Wrong rewritten file with chunk size %d", chunkSize)
+		require.NoError(t, os.WriteFile(infile, []byte(utf8BOM+strings.Join(lines, "")), 0644), "Error writing file")
+	}
+	utils.DeleteFileIfExists(infile)
+}
+
+func TestSingleLineFastaStatsInChunks(t *testing.T) {
+	// a header longer than a chunk, windows line endings, an empty
+	// sequence, and no newline at the end of the file
+	fasta := ">seq1 with a description that is longer than one chunk\r\n" +
+		strings.Repeat("ACGTN", 7) + "\r\n" +
+		">empty\n\n" +
+		">seq2\nGGccN"
+	infile := "tmp.test.singleLineFastaStats.fa"
+	require.NoError(t, os.WriteFile(infile, []byte(fasta), 0644), "Error writing file")
+	expectStats := []seqStats{
+		{name: "seq1", length: 35, offset: 56, gcCount: 14, nCount: 7},
+		{name: "empty", length: 0, offset: 100},
+		{name: "seq2", length: 5, offset: 107, gcCount: 4, nCount: 1},
+	}
+	defer func(chunkSize int) { readChunkSize = chunkSize }(readChunkSize)
+
+	for _, chunkSize := range []int{16, 17, 1 << 20} {
+		readChunkSize = chunkSize
+		stats, err := getSeqStatsFromSingleLineFasta(infile)
+		require.NoError(t, err, "Error getting sequence stats")
+		require.Equal(t, expectStats, stats, "Wrong stats with chunk size %d", chunkSize)
+		names, lengths, err := getSeqLengthsFromSingleLineFasta(infile)
+		require.NoError(t, err, "Error getting sequence lengths")
+		require.Equal(t, []string{"seq1", "empty", "seq2"}, names, "Wrong names with chunk size %d", chunkSize)
+		require.Equal(t, map[string]int{"seq1": 35, "empty": 0, "seq2": 5}, lengths, "Wrong lengths with chunk size %d", chunkSize)
+	}
+	utils.DeleteFileIfExists(infile)
+}
@@ -0,0 +1,461 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/shenwei356/xopen"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Lines in genbank and EMBL files are at most this long
+const flatfileLineWidth = 79
+
+// Feature keys (from the INSDC feature table definition) that can be
+// written as they are. Other GFF3 types are either converted using
+// featureKeysFromSO, or written as misc_feature
+var insdcFeatureKeys = map[string]bool{
+	"assembly_gap": true, "C_region": true, "CDS": true, "centromere": true,
+	"D-loop": true, "D_segment": true, "exon": true, "gap": true, "gene": true,
+	"iDNA": true, "intron": true, "J_segment": true, "mat_peptide": true,
+	"misc_binding": true, "misc_difference": true, "misc_feature": true,
+	"misc_recomb": true, "misc_RNA": true, "misc_structure": true,
+	"mobile_element": true, "modified_base": true, "mRNA": true, "ncRNA": true,
+	"N_region": true, "old_sequence": true, "operon": true, "oriT": true,
+	"polyA_site": true, "precursor_RNA": true, "prim_transcript": true,
+	"primer_bind": true, "propeptide": true, "protein_bind": true,
+	"regulatory": true, "repeat_region": true, "rep_origin": true, "rRNA": true,
+	"S_region": true, "sig_peptide": true, "source": true, "stem_loop": true,
+	"STS": true, "telomere": true, "tmRNA": true, "transit_peptide": true,
+	"tRNA": true, "unsure": true, "V_region": true, "V_segment": true,
+	"variation": true, "3'UTR": true, "5'UTR": true,
+}
+
+// Sequence ontology types used in GFF3 files that have a different name
+// for the same thing in genbank/EMBL files
+var featureKeysFromSO = map[string]string{
+	"five_prime_UTR":        "5'UTR",
+	"three_prime_UTR":       "3'UTR",
+	"origin_of_replication": "rep_origin",
+	"pseudogene":            "gene",
+	"signal_peptide":        "sig_peptide",
+}
+
+// Attributes that are not written as qualifiers. These are either only
+// meaningful in GFF3 files, or are added by TNA when importing, in which
+// case they are made again when the exported file is imported
+var attributesNotQualifiers = map[string]bool{
+	"ID":            true,
+	"Parent":        true,
+	"Name":          true,
+	"Alias":         true,
+	"Target":        true,
+	"Gap":           true,
+	"Derives_from":  true,
+	"Ontology_term": true,
+	"Is_circular":   true,
+	"partial":       true,
+	"start_range":   true,
+	"end_range":     true,
+}
+
+// GFF3 attributes that are the same as a qualifier with a different name.
+// This includes qualifiers that start with an uppercase letter, which is
+// changed to lowercase when importing (see gff3AttributeNameFromQualifier)
+var qualifierNamesFromAttributes = map[string]string{
+	"Note":      "note",
+	"Dbxref":    "db_xref",
+	"eC_number": "EC_number",
+}
+
+// Qualifiers that have no value, eg /pseudo. They are imported with the
+// value "true"
+var qualifiersWithoutValue = map[string]bool{
+	"environmental_sample": true,
+	"focus":                true,
+	"germline":             true,
+	"macronuclear":         true,
+	"proviral":             true,
+	"pseudo":               true,
+	"rearranged":           true,
+	"ribosomal_slippage":   true,
+	"trans_splicing":       true,
+	"transgenic":           true,
+}
+
+// Qualifiers whose values are not put in quotes
+var unquotedQualifiers = map[string]bool{
+	"anticodon":        true,
+	"citation":         true,
+	"codon_start":      true,
+	"compare":          true,
+	"direction":        true,
+	"estimated_length": true,
+	"mod_base":         true,
+	"number":           true,
+	"rpt_type":         true,
+	"rpt_unit_range":   true,
+	"tag_peptide":      true,
+	"transl_except":    true,
+	"transl_table":     true,
+}
+
+// Returns the genbank/EMBL feature key for a GFF3 type. If the type has no
+// matching key, returns "misc_feature" and the type, which should be
+// added as a note
+func featureKeyFromGFF3Type(featureType string) (string, string) {
+	if insdcFeatureKeys[featureType] {
+		return featureType, ""
+	} else if key, exists := featureKeysFromSO[featureType]; exists {
+		return key, ""
+	}
+	return "misc_feature", featureType
+}
+
+// Returns the location of one GFF3 feature as a genbank/EMBL location,
+// ignoring the strand. start_range and end_range attributes are used to
+// mark partial ends with < and >
+func locationSegmentString(f Feature) string {
+	start := strconv.Itoa(f.Start)
+	end := strconv.Itoa(f.End)
+	if f.GetAttribute("start_range") != "" {
+		start = "<" + start
+	}
+	if f.GetAttribute("end_range") != "" {
+		end = ">" + end
+	}
+	if start == end {
+		return start
+	}
+	return start + ".." + end
+}
+
+// Returns the genbank/EMBL location of a feature made of the given GFF3
+// features, which must be in the order they are joined. If they are all
+// on the - strand, the location is complement(join(...)). Otherwise, each
+// part on the - strand is in its own complement(...)
+func locationFromGFF3(segments []Feature) string {
+	allReverse := true
+	for _, s := range segments {
+		allReverse = allReverse && s.Strand == "-"
+	}
+	parts := make([]string, len(segments))
+	for i, s := range segments {
+		if allReverse {
+			parts[len(segments)-i-1] = locationSegmentString(s)
+		} else if s.Strand == "-" {
+			parts[i] = "complement(" + locationSegmentString(s) + ")"
+		} else {
+			parts[i] = locationSegmentString(s)
+		}
+	}
+	location := parts[0]
+	if len(parts) > 1 {
+		location = "join(" + strings.Join(parts, ",") + ")"
+	}
+	if allReverse {
+		location = "complement(" + location + ")"
+	}
+	return location
+}
+
+// Returns a qualifier value as it is written in a file, ie in quotes
+// with any quotes inside doubled, unless it is one of unquotedQualifiers
+func encodeQualifierValue(name string, value string) string {
+	if unquotedQualifiers[name] {
+		return value
+	}
+	return "\"" + strings.ReplaceAll(value, "\"", "\"\"") + "\""
+}
+
+// Returns the qualifiers made from the attributes of a GFF3 feature, which
+// is the first (5') part of the feature. Each value of an attribute with
+// more than one value is a separate qualifier. If the feature is a CDS
+// with a phase but no codon_start attribute, /codon_start is added
+func qualifiersFromGFF3(f Feature) []genbankQualifier {
+	qualifiers := []genbankQualifier{}
+	hasCodonStart := false
+	for _, a := range f.Attributes {
+		if attributesNotQualifiers[a.Key] {
+			continue
+		}
+		name := a.Key
+		if newName, exists := qualifierNamesFromAttributes[name]; exists {
+			name = newName
+		}
+		hasCodonStart = hasCodonStart || name == "codon_start"
+		for _, value := range a.Values {
+			if qualifiersWithoutValue[name] && value == "true" {
+				qualifiers = append(qualifiers, genbankQualifier{name: name})
+			} else {
+				qualifiers = append(qualifiers, genbankQualifier{name: name, value: encodeQualifierValue(name, value)})
+			}
+		}
+	}
+	if phase, err := strconv.Atoi(f.Phase); f.Type == "CDS" && !hasCodonStart && err == nil {
+		qualifiers = append(qualifiers, genbankQualifier{name: "codon_start", value: strconv.Itoa(phase + 1)})
+	}
+	return qualifiers
+}
+
+// Returns the genbank/EMBL features of a record. GFF3 features with the
+// same type and ID are the parts of one feature, and must be in the order
+// they are joined. Features made by TNA when importing (with source
+// "TNA", ie gaps and soft-masked regions) are skipped. If there is no
+// source feature, one is added
+func flatfileFeatures(record *SeqRecord) []genbankFeature {
+	type typeAndID struct {
+		featureType string
+		id          string
+	}
+	groups := [][]Feature{}
+	groupIndexes := map[typeAndID]int{}
+	hasSource := false
+	for _, f := range record.Features {
+		if f.Source == "TNA" {
+			continue
+		}
+		hasSource = hasSource || f.Type == "source"
+		key := typeAndID{f.Type, f.GetAttribute("ID")}
+		if i, exists := groupIndexes[key]; exists && key.id != "" {
+			groups[i] = append(groups[i], f)
+		} else {
+			groupIndexes[key] = len(groups)
+			groups = append(groups, []Feature{f})
+		}
+	}
+
+	features := []genbankFeature{}
+	if !hasSource {
+		features = append(features, genbankFeature{
+			key:        "source",
+			location:   fmt.Sprintf("1..%d", len(record.Seq)),
+			qualifiers: []genbankQualifier{{name: "mol_type", value: "\"genomic DNA\""}},
+		})
+	}
+	for _, segments := range groups {
+		key, note := featureKeyFromGFF3Type(segments[0].Type)
+		feature := genbankFeature{key: key, location: locationFromGFF3(segments)}
+		if note != "" {
+			feature.qualifiers = append(feature.qualifiers, genbankQualifier{name: "note", value: encodeQualifierValue("note", note)})
+		}
+		feature.qualifiers = append(feature.qualifiers, qualifiersFromGFF3(segments[0])...)
+		if segments[0].Type == "pseudogene" && segments[0].GetAttribute("pseudo") == "" {
+			feature.qualifiers = append(feature.qualifiers, genbankQualifier{name: "pseudo"})
+		}
+		features = append(features, feature)
+	}
+	return features
+}
+
+// Splits text into lines of at most width characters. Lines are broken
+// at the last of the breakAfter characters that fits, or at width if
+// there is not one
+func wrapText(text string, width int, breakAfter string) []string {
+	lines := []string{}
+	for len(text) > width {
+		i := strings.LastIndexAny(text[:width], breakAfter)
+		if text[width] == ' ' && strings.Contains(breakAfter, " ") {
+			i = width
+		}
+		if i <= 0 {
+			i = width
+		} else if text[i] != ' ' {
+			i++
+		}
+		lines = append(lines, text[:i])
+		text = strings.TrimLeft(text[i:], " ")
+	}
+	return append(lines, text)
+}
+
+// Returns the lines of one feature, each starting with prefix, which is
+// 5 spaces for genbank and "FT   " for EMBL. Locations are wrapped after
+// commas, and qualifiers at spaces. Translations have no spaces, so are
+// wrapped at the line width
+func flatfileFeatureLines(f genbankFeature, prefix string) string {
+	var lines strings.Builder
+	indent := prefix + strings.Repeat(" ", 21-len(prefix))
+	width := flatfileLineWidth - len(indent)
+	for i, line := range wrapText(f.location, width, ",") {
+		if i == 0 {
+			lines.WriteString(fmt.Sprintf("%v%-16v%v\n", prefix, f.key, line))
+		} else {
+			lines.WriteString(indent + line + "\n")
+		}
+	}
+	for _, q := range f.qualifiers {
+		text := "/" + q.name
+		if q.value != "" {
+			text += "=" + q.value
+		}
+		for _, line := range wrapText(text, width, " ") {
+			lines.WriteString(indent + line + "\n")
+		}
+	}
+	return lines.String()
+}
+
+// Returns the organism from the /organism qualifier of the source
+// feature, or "." if there is not one
+func flatfileOrganism(features []genbankFeature) string {
+	for _, f := range features {
+		if f.key != "source" {
+			continue
+		}
+		if values := f.qualifierValues("organism"); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return "."
+}
+
+// Returns the sequence lines of a genbank or EMBL file: 60 bases per line
+// in blocks of 10. Genbank lines start with the position of their first
+// base, and EMBL lines end with the position of their last base
+func flatfileSeqLines(seq string, format FileFormat) string {
+	var lines strings.Builder
+	seq = strings.ToLower(seq)
+	for start := 0; start < len(seq); start += 60 {
+		blocks := []string{}
+		end := min(start+60, len(seq))
+		for i := start; i < end; i += 10 {
+			blocks = append(blocks, seq[i:min(i+10, end)])
+		}
+		if format == GENBANK {
+			lines.WriteString(fmt.Sprintf("%9d %v\n", start+1, strings.Join(blocks, " ")))
+		} else {
+			lines.WriteString(fmt.Sprintf("     %-65v %9d\n", strings.Join(blocks, " "), end))
+		}
+	}
+	return lines.String()
+}
+
+func genbankRecordString(record *SeqRecord, features []genbankFeature) string {
+	var lines strings.Builder
+	topology := "linear"
+	if record.Circular {
+		topology = "circular"
+	}
+	organism := flatfileOrganism(features)
+	lines.WriteString(fmt.Sprintf("LOCUS       %-16v %11d bp    DNA     %-8v UNK 01-JAN-1980\n", record.Name, len(record.Seq), topology))
+	lines.WriteString("DEFINITION  " + record.Name + ".\n")
+	lines.WriteString("ACCESSION   " + record.Name + "\n")
+	lines.WriteString("KEYWORDS    .\n")
+	lines.WriteString("SOURCE      " + organism + "\n")
+	lines.WriteString("  ORGANISM  " + organism + "\n")
+	lines.WriteString("FEATURES             Location/Qualifiers\n")
+	for _, f := range features {
+		lines.WriteString(flatfileFeatureLines(f, "     "))
+	}
+	lines.WriteString("ORIGIN\n")
+	lines.WriteString(flatfileSeqLines(record.Seq, GENBANK))
+	lines.WriteString("//\n")
+	return lines.String()
+}
+
+func emblRecordString(record *SeqRecord, features []genbankFeature) string {
+	var lines strings.Builder
+	topology := "linear"
+	if record.Circular {
+		topology = "circular"
+	}
+	lines.WriteString(fmt.Sprintf("ID   %v; SV 1; %v; genomic DNA; STD; UNC; %d BP.\n", record.Name, topology, len(record.Seq)))
+	lines.WriteString("XX\n")
+	lines.WriteString("AC   " + record.Name + ";\n")
+	lines.WriteString("XX\n")
+	lines.WriteString("DE   " + record.Name + "\n")
+	lines.WriteString("XX\n")
+	lines.WriteString("OS   " + flatfileOrganism(features) + "\n")
+	lines.WriteString("XX\n")
+	lines.WriteString("FH   Key             Location/Qualifiers\n")
+	lines.WriteString("FH\n")
+	for _, f := range features {
+		lines.WriteString(flatfileFeatureLines(f, "FT   "))
+	}
+	lines.WriteString("XX\n")
+	counts := map[rune]int{}
+	for _, c := range strings.ToLower(record.Seq) {
+		counts[c]++
+	}
+	other := len(record.Seq) - counts['a'] - counts['c'] - counts['g'] - counts['t']
+	lines.WriteString(fmt.Sprintf("SQ   Sequence %d BP; %d A; %d C; %d G; %d T; %d other;\n", len(record.Seq), counts['a'], counts['c'], counts['g'], counts['t'], other))
+	lines.WriteString(flatfileSeqLines(record.Seq, EMBL))
+	lines.WriteString("//\n")
+	return lines.String()
+}
+
+// FlatfileWriter writes SeqRecords, including their features, to a
+// genbank or EMBL file
+type FlatfileWriter struct {
+	filename string
+	format   FileFormat
+	writer   *xopen.Writer
+}
+
+// NewFlatfileWriter opens a file for writing. format must be GENBANK or
+// EMBL
+func NewFlatfileWriter(filename string, format FileFormat) (*FlatfileWriter, error) {
+	if format != GENBANK && format != EMBL {
+		return nil, fmt.Errorf("%w: can only write genbank or EMBL files", ErrUnknownFormat)
+	}
+	writer, err := xopen.Wopen(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for writing %v: %w", filename, err)
+	}
+	return &FlatfileWriter{filename: filename, format: format, writer: writer}, nil
+}
+
+func (w *FlatfileWriter) Write(record *SeqRecord) error {
+	features := flatfileFeatures(record)
+	var text string
+	if w.format == GENBANK {
+		text = genbankRecordString(record, features)
+	} else {
+		text = emblRecordString(record, features)
+	}
+	if _, err := w.writer.WriteString(text); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", w.filename, err)
+	}
+	return nil
+}
+
+func (w *FlatfileWriter) Close() error {
+	return w.writer.Close()
+}
+
+// ParseFlatfileFormat returns GENBANK or EMBL from their name (not case
+// sensitive)
+func ParseFlatfileFormat(format string) (FileFormat, error) {
+	switch strings.ToLower(format) {
+	case "genbank":
+		return GENBANK, nil
+	case "embl":
+		return EMBL, nil
+	}
+	return Unknown, fmt.Errorf("Unknown export format '%v'. Must be one of: genbank, embl", format)
+}
+
+// ExportFlatfile writes an imported genome, which has files prefix.fa and
+// optionally prefix.gff, to a genbank or EMBL file
+func ExportFlatfile(prefix string, outfile string, format FileFormat) (err error) {
+	records, err := readSeqsAndAnnotation(prefix+".fa", prefix+".gff")
+	if err != nil {
+		return err
+	}
+	writer, err := NewFlatfileWriter(outfile, format)
+	if err != nil {
+		return err
+	}
+	defer closeWriter(writer, outfile, &err)
+	for _, record := range records {
+		if record.Seq == "" {
+			log.Printf("Warning: not exporting annotation on %v, because it is not in %v.fa", record.Name, prefix)
+			continue
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocationFromGFF3(t *testing.T) {
+	partialStart := []Attribute{{Key: "start_range", Values: []string{".", "5"}}}
+	partialEnd := []Attribute{{Key: "end_range", Values: []string{"20", "."}}}
+	tests := []struct {
+		segments []Feature
+		expect   string
+	}{
+		{[]Feature{{Start: 5, End: 20, Strand: "+"}}, "5..20"},
+		{[]Feature{{Start: 5, End: 5, Strand: "+"}}, "5"},
+		{[]Feature{{Start: 5, End: 20, Strand: "-", Attributes: partialStart}}, "complement(<5..20)"},
+		{[]Feature{{Start: 5, End: 20, Strand: "+", Attributes: partialEnd}, {Start: 30, End: 40, Strand: "+"}}, "join(5..>20,30..40)"},
+		{[]Feature{{Start: 30, End: 40, Strand: "-"}, {Start: 5, End: 20, Strand: "-"}}, "complement(join(5..20,30..40))"},
+		{[]Feature{{Start: 30, End: 40, Strand: "-"}, {Start: 5, End: 20, Strand: "+"}}, "join(complement(30..40),5..20)"},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expect, locationFromGFF3(test.segments), "Wrong location")
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	require.Equal(t, []string{"abc"}, wrapText("abc", 5, " "), "Short text should not be wrapped")
+	require.Equal(t, []string{"ab cd", "ef"}, wrapText("ab cd ef", 5, " "), "Wrong wrapping at space")
+	require.Equal(t, []string{"abcde", "fgh"}, wrapText("abcdefgh", 5, " "), "Wrong wrapping with no spaces")
+	require.Equal(t, []string{"1,23,", "4,5"}, wrapText("1,23,4,5", 5, ","), "Wrong wrapping after commas")
+}
+
+func TestFlatfileFeatures(t *testing.T) {
+	record := &SeqRecord{Name: "seq1", Seq: strings.Repeat("A", 100), Features: []Feature{
+		{SeqID: "seq1", Source: "src", Type: "CDS", Start: 10, End: 30, Strand: "+", Phase: "1", Attributes: []Attribute{
+			{Key: "ID", Values: []string{"cds1"}},
+			{Key: "Note", Values: []string{"a", "b"}},
+			{Key: "pseudo", Values: []string{"true"}},
+		}},
+		{SeqID: "seq1", Source: "TNA", Type: "gap", Start: 40, End: 50, Strand: "+", Phase: "."},
+		{SeqID: "seq1", Source: "src", Type: "three_prime_UTR", Start: 31, End: 35, Strand: "+", Phase: "."},
+		{SeqID: "seq1", Source: "src", Type: "contig", Start: 1, End: 39, Strand: "+", Phase: "."},
+	}}
+	features := flatfileFeatures(record)
+	require.Equal(t, 4, len(features), "Wrong number of features")
+	require.Equal(t, genbankFeature{key: "source", location: "1..100", qualifiers: []genbankQualifier{{"mol_type", "\"genomic DNA\""}}}, features[0], "Wrong source feature")
+	require.Equal(t, genbankFeature{key: "CDS", location: "10..30", qualifiers: []genbankQualifier{{"note", "\"a\""}, {"note", "\"b\""}, {"pseudo", ""}, {"codon_start", "2"}}}, features[1], "Wrong CDS feature")
+	require.Equal(t, genbankFeature{key: "3'UTR", location: "31..35"}, features[2], "Wrong UTR feature")
+	require.Equal(t, genbankFeature{key: "misc_feature", location: "1..39", qualifiers: []genbankQualifier{{"note", "\"contig\""}}}, features[3], "Wrong contig feature")
+}
+
+func TestExportFlatfile(t *testing.T) {
+	prefix := "tmp.test.exportFlatfile"
+	require.NoError(t, ParseSeqFile(filepath.Join("seqfiles_testdata", "parseGenbank.in.gbk"), prefix), "Error importing file")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	tests := []struct {
+		format FileFormat
+		suffix string
+	}{{GENBANK, ".gbk"}, {EMBL, ".embl"}}
+	for _, test := range tests {
+		outfile := prefix + test.suffix
+		require.NoError(t, ExportFlatfile(prefix, outfile, test.format), "Error exporting %v", outfile)
+		expectFile := filepath.Join("seqfiles_testdata", "export.expect"+test.suffix)
+		filesEqual, err := cmp.CompareFile(expectFile, outfile)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, outfile)
+		require.True(t, filesEqual, "File %s expected contents incorrect", outfile)
+
+		// importing the exported file should give the same sequences and
+		// annotation as the original import
+		reimported := prefix + ".reimport"
+		require.NoError(t, ParseSeqFile(outfile, reimported), "Error importing exported file %v", outfile)
+		for _, suffix := range []string{".fa", ".gff"} {
+			filesEqual, err := cmp.CompareFile(prefix+suffix, reimported+suffix)
+			require.NoError(t, err, "Error comparing files %s, %s", prefix+suffix, reimported+suffix)
+			require.True(t, filesEqual, "File %s is different after exporting and importing again", reimported+suffix)
+		}
+		for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".annotation_problems.tsv", ".metadata.json", ".faa"} {
+			utils.DeleteFileIfExists(reimported + suffix)
+		}
+		utils.DeleteFileIfExists(outfile)
+	}
+
+	_, err := NewFlatfileWriter(prefix+".fa", GFF3)
+	require.ErrorIs(t, err, ErrUnknownFormat, "Should not be able to export GFF3")
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".annotation_problems.tsv", ".metadata.json", ".faa"} {
+		utils.DeleteFileIfExists(prefix + suffix)
+	}
+}
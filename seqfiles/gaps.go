@@ -0,0 +1,266 @@
+package seqfiles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default characters that are counted as gap in a sequence
+const DefaultGapChars = "N"
+
+// Gap is a run of gap characters in a sequence. GapType and
+// EstimatedLength are taken from the annotation, eg from a genbank
+// assembly_gap feature, and are "" if not known
+type Gap struct {
+	SeqName         string
+	Start           int
+	End             int
+	GapType         string
+	EstimatedLength string
+}
+
+func (g *Gap) Length() int {
+	return g.End - g.Start + 1
+}
+
+// Returns a lookup table of the gap characters, in upper and lower case
+func gapCharTable(gapChars string) [256]bool {
+	table := [256]bool{}
+	for _, c := range []byte(strings.ToUpper(gapChars) + strings.ToLower(gapChars)) {
+		table[c] = true
+	}
+	return table
+}
+
+// Number of sequences that are searched for gaps at the same time
+var gapScanWorkers = runtime.GOMAXPROCS(0)
+
+// Name of a sequence in a FASTA file that has each sequence on one line,
+// the offset in the file of the start of its sequence line, and the
+// offset of the end of the record, ie of the next header line or the
+// end of the file
+type fastaSeqOffset struct {
+	name   string
+	offset int64
+	end    int64
+}
+
+// Returns the name and offset of every sequence in a FASTA file that has
+// each sequence on one line. The file is read in chunks, so that long
+// sequence lines are never held in memory
+func singleLineFastaOffsets(r io.Reader) ([]fastaSeqOffset, error) {
+	reader := bufio.NewReaderSize(r, readChunkSize)
+	seqs := []fastaSeqOffset{}
+	var offset int64
+	atLineStart := true
+	// Only set while reading a header line
+	var header []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return nil, err
+		}
+		line := chunk
+		if offset == 0 {
+			line = bytes.TrimPrefix(line, []byte(utf8BOM))
+		}
+		if atLineStart && len(line) > 0 && line[0] == '>' {
+			header = []byte{}
+			if len(seqs) > 0 {
+				seqs[len(seqs)-1].end = offset
+			}
+		}
+		if header != nil {
+			header = append(header, line...)
+		}
+		offset += int64(len(chunk))
+		atLineStart = err != bufio.ErrBufferFull
+		if header != nil && atLineStart {
+			name := strings.TrimPrefix(strings.Fields(string(header))[0], ">")
+			seqs = append(seqs, fastaSeqOffset{name: name, offset: offset})
+			header = nil
+		}
+		if err == io.EOF {
+			if len(seqs) > 0 {
+				seqs[len(seqs)-1].end = offset
+			}
+			return seqs, nil
+		}
+	}
+}
+
+// Returns the runs of gap characters at least minGapLen long in the
+// sequence that starts at seq.offset in f, and ends at the next newline or
+// seq.end. The sequence is read in chunks into buf, so gaps
+// that cross from one chunk to the next are joined up
+func scanSeqForGaps(f io.ReaderAt, seq fastaSeqOffset, minGapLen int, isGap *[256]bool, buf []byte) ([]Gap, error) {
+	gaps := []Gap{}
+	addGap := func(start int, end int) {
+		if end-start >= minGapLen {
+			gaps = append(gaps, Gap{SeqName: seq.name, Start: start + 1, End: end})
+		}
+	}
+	// 0-based position in the sequence of the start of the current chunk,
+	// and of the start of the current run of gap characters
+	position := 0
+	gapStart := -1
+	offset := seq.offset
+	for offset < seq.end {
+		n, err := f.ReadAt(buf[:min(int64(len(buf)), seq.end-offset)], offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunk := buf[:n]
+		lineEnd := bytes.IndexByte(chunk, '\n')
+		if lineEnd >= 0 {
+			chunk = chunk[:lineEnd]
+		}
+		for i, c := range chunk {
+			if isGap[c] {
+				if gapStart == -1 {
+					gapStart = position + i
+				}
+			} else if gapStart != -1 {
+				addGap(gapStart, position+i)
+				gapStart = -1
+			}
+		}
+		position += len(chunk)
+		offset += int64(n)
+		if lineEnd >= 0 || err == io.EOF {
+			break
+		}
+	}
+	if gapStart != -1 {
+		addGap(gapStart, position)
+	}
+	return gaps, nil
+}
+
+// Returns the runs of gap characters at least minGapLen long in a
+// FASTA file that has each sequence on one line. Gap characters are not
+// case sensitive. Returns no gaps if minGapLen <= 0. The file is read in
+// chunks, and up to gapScanWorkers sequences are searched at the same
+// time, so memory use does not depend on the length of the sequences
+func getGapsFromSingleLineFasta(infile string, minGapLen int, gapChars string) ([]Gap, error) {
+	gaps := []Gap{}
+	if minGapLen <= 0 {
+		return gaps, nil
+	}
+	f, err := os.Open(infile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %v: %w", infile, err)
+	}
+	defer f.Close()
+	seqs, err := singleLineFastaOffsets(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %v: %w", infile, err)
+	}
+
+	isGap := gapCharTable(gapChars)
+	seqGaps := make([][]Gap, len(seqs))
+	errs := make([]error, len(seqs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(gapScanWorkers, 1), len(seqs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, readChunkSize)
+			for i := range jobs {
+				seqGaps[i], errs[i] = scanSeqForGaps(f, seqs[i], minGapLen, &isGap, buf)
+			}
+		}()
+	}
+	for i := range seqs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := range seqs {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("error reading file %v: %w", infile, errs[i])
+		}
+		gaps = append(gaps, seqGaps[i]...)
+	}
+	return gaps, nil
+}
+
+// Sets the gap type and estimated length of each gap that overlaps an
+// assembly_gap feature in a GFF3 file, from the gap_type and
+// estimated_length attributes of the feature
+func addGapInfoFromAnnotation(gaps []Gap, annotFile string) error {
+	assemblyGaps := map[string][]Feature{}
+	err := forEachLine(annotFile, func(line string) error {
+		if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+			return nil
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) < 3 || fields[2] != "assembly_gap" {
+			return nil
+		}
+		feature, problems := parseGFF3Line(line, 0)
+		if len(problems) == 0 || problems[len(problems)-1].fixed {
+			assemblyGaps[feature.SeqID] = append(assemblyGaps[feature.SeqID], feature)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range gaps {
+		for _, feature := range assemblyGaps[gaps[i].SeqName] {
+			if feature.Start <= gaps[i].End && gaps[i].Start <= feature.End {
+				gaps[i].GapType = feature.GetAttribute("gap_type")
+				gaps[i].EstimatedLength = feature.GetAttribute("estimated_length")
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Returns a GFF3 gap feature for each gap. IDs are the sequence name
+// followed by .gap_1, .gap_2, etc
+func gapFeatures(gaps []Gap) []Feature {
+	features := []Feature{}
+	counts := map[string]int{}
+	for _, gap := range gaps {
+		counts[gap.SeqName]++
+		attributes := []Attribute{
+			{Key: "ID", Values: []string{fmt.Sprintf("%v.gap_%d", gap.SeqName, counts[gap.SeqName])}},
+			{Key: "length", Values: []string{strconv.Itoa(gap.Length())}},
+		}
+		if gap.GapType != "" {
+			attributes = append(attributes, Attribute{Key: "gap_type", Values: []string{gap.GapType}})
+		}
+		if gap.EstimatedLength != "" {
+			attributes = append(attributes, Attribute{Key: "estimated_length", Values: []string{gap.EstimatedLength}})
+		}
+		features = append(features, Feature{
+			SeqID:      gap.SeqName,
+			Source:     "TNA",
+			Type:       "gap",
+			Start:      gap.Start,
+			End:        gap.End,
+			Score:      ".",
+			Strand:     "+",
+			Phase:      ".",
+			Attributes: attributes,
+		})
+	}
+	return features
+}
+
+func addGapsToAnnotFile(gaps []Gap, filename string) error {
+	return appendFeaturesToAnnotFile(gapFeatures(gaps), filename)
+}
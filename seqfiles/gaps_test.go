@@ -0,0 +1,130 @@
+package seqfiles
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGapCharsAndGapInfo(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "gapInfo.in.gbk")
+	outprefix := "tmp.test.gapInfo"
+	options := DefaultImportOptions()
+	options.GapChars = "NX-"
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+
+	expectFile := filepath.Join("seqfiles_testdata", "gapInfo.expect.gff")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outprefix+".gff")
+	require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+".gff")
+	require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+".gff")
+
+	gaps, err := getGapsFromSingleLineFasta(outprefix+".fa", 1, "n")
+	require.NoError(t, err, "Error getting gaps")
+	require.Equal(t, []Gap{{SeqName: "scaf1", Start: 11, End: 20}}, gaps, "Wrong gaps using only N")
+	require.NoError(t, addGapInfoFromAnnotation(gaps, outprefix+".gff"), "Error adding gap info")
+	require.Equal(t, "within scaffold", gaps[0].GapType, "Wrong gap type")
+	require.Equal(t, "10", gaps[0].EstimatedLength, "Wrong estimated length")
+	require.Equal(t, 10, gaps[0].Length(), "Wrong gap length")
+
+	utils.DeleteFileIfExists(outprefix + ".fa")
+	utils.DeleteFileIfExists(outprefix + ".gff")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+	utils.DeleteFileIfExists(outprefix + ".metadata.json")
+}
+
+func TestGetGapsFromSingleLineFastaInChunks(t *testing.T) {
+	// gaps that cross chunk boundaries, a header longer than one chunk,
+	// windows line endings, an empty sequence and no newline at the end
+	// of the file
+	fasta := utf8BOM + ">seq_with_a_long_name and a description longer than a chunk\r\n" +
+		"ACGTNNNNNNNNNNNNNNNNNNNNACGTNNAC\r\n" +
+		">s2\n" + "NNNN" + strings.Repeat("A", 14) + strings.Repeat("n", 34) + "\n" +
+		">empty\n\n" +
+		">s3\nACN"
+	infile := "tmp.test.getGapsInChunks.fa"
+	require.NoError(t, os.WriteFile(infile, []byte(fasta), 0644), "Error writing file")
+	expect := []Gap{
+		{SeqName: "seq_with_a_long_name", Start: 5, End: 24},
+		{SeqName: "seq_with_a_long_name", Start: 29, End: 30},
+		{SeqName: "s2", Start: 1, End: 4},
+		{SeqName: "s2", Start: 19, End: 52},
+	}
+
+	defer func(bufferSize int, workers int) {
+		readChunkSize = bufferSize
+		gapScanWorkers = workers
+	}(readChunkSize, gapScanWorkers)
+	for _, bufferSize := range []int{16, 17, 1 << 20} {
+		for _, workers := range []int{1, 3} {
+			readChunkSize = bufferSize
+			gapScanWorkers = workers
+			got, err := getGapsFromSingleLineFasta(infile, 2, DefaultGapChars)
+			require.NoError(t, err, "Error getting gaps")
+			require.Equal(t, expect, got, "Wrong gaps with buffer size %d and %d workers", bufferSize, workers)
+			got, err = getGapsFromSingleLineFasta(infile, 1, DefaultGapChars)
+			require.NoError(t, err, "Error getting gaps")
+			require.Equal(t, append(expect, Gap{SeqName: "s3", Start: 3, End: 3}), got, "Wrong gaps with buffer size %d and %d workers", bufferSize, workers)
+		}
+	}
+	utils.DeleteFileIfExists(infile)
+}
+
+// Writes a FASTA file of numSeqs sequences, each seqLength long, with a
+// gap of gapLength every gapEvery bases. The file is written in pieces,
+// so that large files can be made without holding them in memory
+func writeGappedFasta(filename string, numSeqs int, seqLength int, gapEvery int, gapLength int) error {
+	fout, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+	piece := []byte(strings.Repeat("ACGT", (gapEvery-gapLength)/4+1)[:gapEvery-gapLength] + strings.Repeat("N", gapLength))
+	writer := bufio.NewWriter(fout)
+	for i := 0; i < numSeqs; i++ {
+		fmt.Fprintf(writer, ">seq%d\n", i+1)
+		for written := 0; written < seqLength; written += len(piece) {
+			writer.Write(piece[:min(len(piece), seqLength-written)])
+		}
+		writer.WriteString("\n")
+	}
+	return writer.Flush()
+}
+
+// Throughput of finding gaps, reported in MB/s of sequence. Allocated
+// bytes per operation depend on the number of sequences and gaps, not on
+// the length of the sequences. The largest input is 2.56 Gb, so run with
+// a fixed number of iterations, eg:
+// go test -run XXX -bench GetGaps -benchtime 3x
+func BenchmarkGetGapsFromSingleLineFasta(b *testing.B) {
+	benchmarks := []struct {
+		name      string
+		numSeqs   int
+		seqLength int
+	}{
+		{"one_256Mb_chromosome", 1, 256_000_000},
+		{"sixteen_160Mb_chromosomes", 16, 160_000_000},
+		{"10000_100kb_contigs", 10_000, 100_000},
+	}
+	infile := "tmp.bench.getGaps.fa"
+	defer utils.DeleteFileIfExists(infile)
+	for _, bm := range benchmarks {
+		require.NoError(b, writeGappedFasta(infile, bm.numSeqs, bm.seqLength, 50_000, 100), "Error writing file")
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(bm.numSeqs) * int64(bm.seqLength))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				gaps, err := getGapsFromSingleLineFasta(infile, 1, DefaultGapChars)
+				require.NoError(b, err, "Error getting gaps")
+				require.Equal(b, bm.numSeqs*(bm.seqLength/50_000), len(gaps), "Wrong number of gaps")
+			}
+		})
+	}
+}
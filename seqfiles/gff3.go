@@ -0,0 +1,236 @@
+package seqfiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Feature is one record (line) of a GFF3 file. Start and End are 1-based
+// and inclusive. Attribute keys and values are stored unescaped.
+type Feature struct {
+	SeqID      string
+	Source     string
+	Type       string
+	Start      int
+	End        int
+	Score      string
+	Strand     string
+	Phase      string
+	Attributes []Attribute
+}
+
+// Attribute is one tag=value pair from column 9 of a GFF3 file. A tag can
+// have more than one value, which are comma-separated in the file.
+type Attribute struct {
+	Key    string
+	Values []string
+}
+
+// Problem found when reading a line of a GFF3 file. Problems that were
+// fixed have fixed=true. Otherwise the line could not be used
+type gff3Problem struct {
+	lineNumber int
+	fixed      bool
+	message    string
+}
+
+func (p gff3Problem) String() string {
+	if p.fixed {
+		return fmt.Sprintf("line %d: %v (fixed)", p.lineNumber, p.message)
+	}
+	return fmt.Sprintf("line %d: %v (line skipped)", p.lineNumber, p.message)
+}
+
+// Returns the first value of the attribute with the given key, or the
+// empty string if the feature does not have that attribute
+func (f *Feature) GetAttribute(key string) string {
+	for _, a := range f.Attributes {
+		if a.Key == key && len(a.Values) > 0 {
+			return a.Values[0]
+		}
+	}
+	return ""
+}
+
+// Sets the attribute with the given key to have the one given value.
+// Adds the attribute to the end of the list if it does not exist
+func (f *Feature) SetAttribute(key string, value string) {
+	for i := range f.Attributes {
+		if f.Attributes[i].Key == key {
+			f.Attributes[i].Values = []string{value}
+			return
+		}
+	}
+	f.Attributes = append(f.Attributes, Attribute{Key: key, Values: []string{value}})
+}
+
+// Returns the feature as a GFF3 line, including the trailing newline,
+// with attributes escaped
+func (f *Feature) GFF3Line() string {
+	return fmt.Sprintf("%v\t%v\t%v\t%d\t%d\t%v\t%v\t%v\t%v\n", f.SeqID, f.Source, f.Type, f.Start, f.End, f.Score, f.Strand, f.Phase, gff3AttributesString(f.Attributes))
+}
+
+// Returns attributes as column 9 of a GFF3 line, with keys and values
+// escaped. Returns "." if there are no attributes
+func gff3AttributesString(attributes []Attribute) string {
+	columns := make([]string, len(attributes))
+	for i, a := range attributes {
+		values := make([]string, len(a.Values))
+		for j, v := range a.Values {
+			values[j] = escapeGFF3AttributeValue(v)
+		}
+		columns[i] = escapeGFF3AttributeValue(a.Key) + "=" + strings.Join(values, ",")
+	}
+	if len(columns) == 0 {
+		return "."
+	}
+	return strings.Join(columns, ";")
+}
+
+// Percent-encodes the characters that are not allowed to appear
+// unescaped in a GFF3 attribute value
+func escapeGFF3AttributeValue(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7f || c == ';' || c == '=' || c == '&' || c == ',' || c == '%' {
+			escaped.WriteString(fmt.Sprintf("%%%02X", c))
+		} else {
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
+
+// Decodes %XX escapes. A % that is not followed by two hex digits is
+// kept, and the returned bool is false
+func unescapeGFF3(s string) (string, bool) {
+	if !strings.Contains(s, "%") {
+		return s, true
+	}
+	ok := true
+	var unescaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				unescaped.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		if s[i] == '%' {
+			ok = false
+		}
+		unescaped.WriteByte(s[i])
+	}
+	return unescaped.String(), ok
+}
+
+// Parses one line of a GFF3 file that is not a comment or pragma.
+// Problems that can be fixed are fixed and returned. If the line cannot
+// be used, the last problem returned has fixed=false
+func parseGFF3Line(line string, lineNumber int) (Feature, []gff3Problem) {
+	problems := []gff3Problem{}
+	addProblem := func(fixed bool, format string, a ...any) {
+		problems = append(problems, gff3Problem{lineNumber: lineNumber, fixed: fixed, message: fmt.Sprintf(format, a...)})
+	}
+	feature := Feature{}
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(fields) == 8 {
+		addProblem(true, "no attributes column")
+		fields = append(fields, ".")
+	} else if len(fields) != 9 {
+		addProblem(false, "expected 9 tab-separated columns but got %d", len(fields))
+		return feature, problems
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	feature.SeqID = fields[0]
+	feature.Source = fields[1]
+	feature.Type = fields[2]
+	if feature.SeqID == "" || feature.Type == "" {
+		addProblem(false, "sequence ID and type must not be empty")
+		return feature, problems
+	}
+	if feature.Source == "" {
+		feature.Source = "."
+	}
+
+	var err error
+	feature.Start, err = strconv.Atoi(fields[3])
+	if err != nil || feature.Start < 1 {
+		addProblem(false, "start coordinate must be an integer >= 1, got '%v'", fields[3])
+		return feature, problems
+	}
+	feature.End, err = strconv.Atoi(fields[4])
+	if err != nil || feature.End < 1 {
+		addProblem(false, "end coordinate must be an integer >= 1, got '%v'", fields[4])
+		return feature, problems
+	}
+	if feature.Start > feature.End {
+		addProblem(true, "start %d > end %d, swapped them", feature.Start, feature.End)
+		feature.Start, feature.End = feature.End, feature.Start
+	}
+
+	feature.Score = fields[5]
+	if _, err := strconv.ParseFloat(feature.Score, 64); err != nil && feature.Score != "." {
+		addProblem(true, "score must be a number or '.', got '%v', changed to '.'", feature.Score)
+		feature.Score = "."
+	}
+
+	feature.Strand = fields[6]
+	if !(feature.Strand == "+" || feature.Strand == "-" || feature.Strand == "." || feature.Strand == "?") {
+		addProblem(true, "unknown strand '%v', changed to '.'", feature.Strand)
+		feature.Strand = "."
+	}
+
+	feature.Phase = fields[7]
+	if !(feature.Phase == "." || feature.Phase == "0" || feature.Phase == "1" || feature.Phase == "2") {
+		addProblem(true, "phase must be 0, 1, 2 or '.', got '%v', changed to '.'", feature.Phase)
+		feature.Phase = "."
+	}
+
+	if fields[8] == "." || fields[8] == "" {
+		return feature, problems
+	}
+
+	for _, attribute := range strings.Split(fields[8], ";") {
+		attribute = strings.TrimSpace(attribute)
+		if attribute == "" {
+			continue
+		}
+		key, value, found := strings.Cut(attribute, "=")
+		if !found {
+			if len(feature.Attributes) == 0 {
+				addProblem(true, "attribute '%v' has no '=', removed it", attribute)
+				continue
+			}
+			// assume the ";" should have been escaped and is part of the
+			// previous value
+			addProblem(true, "unescaped ';' in attribute value, escaped it")
+			previous := &feature.Attributes[len(feature.Attributes)-1]
+			unescaped, _ := unescapeGFF3(attribute)
+			previous.Values[len(previous.Values)-1] += ";" + unescaped
+			continue
+		}
+		if strings.Contains(value, "=") {
+			addProblem(true, "unescaped '=' in value of attribute '%v', escaped it", key)
+		}
+		key, ok := unescapeGFF3(key)
+		if !ok {
+			addProblem(true, "unescaped '%%' in attribute key '%v', escaped it", key)
+		}
+		values := strings.Split(value, ",")
+		for i := range values {
+			values[i], ok = unescapeGFF3(values[i])
+			if !ok {
+				addProblem(true, "unescaped '%%' in value of attribute '%v', escaped it", key)
+			}
+		}
+		feature.Attributes = append(feature.Attributes, Attribute{Key: key, Values: values})
+	}
+	return feature, problems
+}
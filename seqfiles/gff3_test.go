@@ -0,0 +1,91 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestEscapeGFF3AttributeValue(t *testing.T) {
+	require.Equal(t, "abc", escapeGFF3AttributeValue("abc"), "Error escaping GFF3 attribute")
+	require.Equal(t, "a b%3Bc%3Dd%26e%2Cf%25g%09h", escapeGFF3AttributeValue("a b;c=d&e,f%g\th"), "Error escaping GFF3 attribute")
+}
+
+func TestUnescapeGFF3(t *testing.T) {
+	got, ok := unescapeGFF3("a%3Bb%2C%25")
+	require.True(t, ok, "Error unescaping GFF3")
+	require.Equal(t, "a;b,%", got, "Error unescaping GFF3")
+	got, ok = unescapeGFF3("50% done%")
+	require.False(t, ok, "Should have bad escape")
+	require.Equal(t, "50% done%", got, "Error unescaping GFF3")
+}
+
+func TestParseGFF3Line(t *testing.T) {
+	feature, problems := parseGFF3Line("seq1\tsrc\tCDS\t3\t7\t0.5\t-\t0\tID=cds1;Parent=gene1,gene2;note=a%3Bb\n", 1)
+	require.Equal(t, 0, len(problems), "Should be no problems with GFF3 line")
+	expect := Feature{
+		SeqID:  "seq1",
+		Source: "src",
+		Type:   "CDS",
+		Start:  3,
+		End:    7,
+		Score:  "0.5",
+		Strand: "-",
+		Phase:  "0",
+		Attributes: []Attribute{
+			{Key: "ID", Values: []string{"cds1"}},
+			{Key: "Parent", Values: []string{"gene1", "gene2"}},
+			{Key: "note", Values: []string{"a;b"}},
+		},
+	}
+	require.Equal(t, expect, feature, "Error parsing GFF3 line")
+	require.Equal(t, "gene1", feature.GetAttribute("Parent"), "Error getting attribute")
+	require.Equal(t, "", feature.GetAttribute("not_there"), "Error getting attribute")
+	require.Equal(t, "seq1\tsrc\tCDS\t3\t7\t0.5\t-\t0\tID=cds1;Parent=gene1,gene2;note=a%3Bb\n", feature.GFF3Line(), "Error making GFF3 line")
+
+	feature, problems = parseGFF3Line("seq1\t.\tgene\t7\t3\tx\tfoo\t4\tno_equals;ID=g1;note=a;b;c=d=e", 2)
+	require.Equal(t, 7, len(problems), "Wrong number of problems with GFF3 line")
+	for _, p := range problems {
+		require.True(t, p.fixed, "Problem should be fixed: %v", p)
+		require.Equal(t, 2, p.lineNumber, "Wrong line number in problem: %v", p)
+	}
+	require.Equal(t, "seq1\t.\tgene\t3\t7\t.\t.\t.\tID=g1;note=a%3Bb;c=d%3De\n", feature.GFF3Line(), "Error fixing GFF3 line")
+
+	feature, problems = parseGFF3Line("seq1\t.\tgene\t3\t7\t.\t+\t.", 3)
+	require.Equal(t, 1, len(problems), "Wrong number of problems with GFF3 line")
+	require.True(t, problems[0].fixed, "Problem should be fixed")
+	require.Equal(t, "seq1\t.\tgene\t3\t7\t.\t+\t.\t.\n", feature.GFF3Line(), "Error fixing GFF3 line")
+
+	for _, bad := range []string{"seq1\t.\tgene\t3", "seq1\t.\tgene\tx\t7\t.\t+\t.\t.", "seq1\t.\tgene\t3\t0\t.\t+\t.\t.", "\t.\tgene\t3\t7\t.\t+\t.\t."} {
+		_, problems = parseGFF3Line(bad, 4)
+		require.False(t, problems[len(problems)-1].fixed, "Problem should not be fixed: %v", bad)
+	}
+}
+
+func TestParseGFF3Messy(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "parseGFF3.messy.in.gff")
+	outprefix := "tmp.test.ParseGFF3Messy"
+	outfileFa := outprefix + ".fa"
+	outfileAnnot := outprefix + ".gff"
+	utils.DeleteFileIfExists(outfileFa)
+	utils.DeleteFileIfExists(outfileAnnot)
+	require.NoError(t, ParseSeqFile(infile, outprefix, 0), "Error importing file")
+
+	cmp := equalfile.New(nil, equalfile.Options{})
+	expectFileFa := filepath.Join("seqfiles_testdata", "parseGFF3.messy.expect.fa")
+	filesEqual, err := cmp.CompareFile(expectFileFa, outfileFa)
+	require.NoError(t, err, "Error comparing FASTA files %s, %s", expectFileFa, outfileFa)
+	require.True(t, filesEqual, "FASTA file %s expected contents incorrect", outfileFa)
+	utils.DeleteFileIfExists(outfileFa)
+
+	expectFileAnnot := filepath.Join("seqfiles_testdata", "parseGFF3.messy.expect.gff")
+	filesEqual, err = cmp.CompareFile(expectFileAnnot, outfileAnnot)
+	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFileAnnot, outfileAnnot)
+	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfileAnnot)
+	utils.DeleteFileIfExists(outfileAnnot)
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+	utils.DeleteFileIfExists(outprefix + ".faa")
+}
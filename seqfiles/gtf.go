@@ -0,0 +1,222 @@
+package seqfiles
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Parses column 9 of a GTF line, eg `gene_id "g1"; transcript_id "t1";`.
+// Keys that appear more than once get more than one value
+func parseGTFAttributes(column string) ([]Attribute, error) {
+	attributes := []Attribute{}
+	indexes := map[string]int{}
+	column = strings.TrimSpace(column)
+	for len(column) > 0 {
+		key, rest, found := strings.Cut(column, " ")
+		if !found {
+			return nil, fmt.Errorf("attribute '%v' has no value", key)
+		}
+		rest = strings.TrimLeft(rest, " ")
+		value := ""
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end == -1 {
+				return nil, fmt.Errorf("no closing quote in value of attribute '%v'", key)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ";")
+			value = strings.TrimSpace(value)
+			rest = ";" + rest
+		}
+		rest = strings.TrimLeft(rest, " ")
+		if len(rest) > 0 && !strings.HasPrefix(rest, ";") {
+			return nil, fmt.Errorf("expected ';' after value of attribute '%v'", key)
+		}
+		column = strings.TrimLeft(strings.TrimPrefix(rest, ";"), " ")
+
+		if i, exists := indexes[key]; exists {
+			attributes[i].Values = append(attributes[i].Values, value)
+		} else {
+			indexes[key] = len(attributes)
+			attributes = append(attributes, Attribute{Key: key, Values: []string{value}})
+		}
+	}
+	return attributes, nil
+}
+
+// Returns true if the line looks like a GTF feature line: 9 tab-separated
+// columns, where the last one has `key "value";` attributes
+func isGTFLine(line string) bool {
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(fields) != 9 || !strings.Contains(fields[8], "\"") {
+		return false
+	}
+	attributes, err := parseGTFAttributes(fields[8])
+	return err == nil && len(attributes) > 0
+}
+
+// Features from a GTF file for one gene_id, with its transcripts in the
+// order they first appear
+type gtfGene struct {
+	gene          *Feature
+	transcriptIDs []string
+	transcripts   map[string]*Feature
+	children      map[string][]Feature
+}
+
+// Makes a feature that spans all the given features, with the seqid,
+// source, strand of the first one
+func spanningFeature(features []Feature, featType string) *Feature {
+	span := Feature{
+		SeqID:  features[0].SeqID,
+		Source: features[0].Source,
+		Type:   featType,
+		Start:  features[0].Start,
+		End:    features[0].End,
+		Score:  ".",
+		Strand: features[0].Strand,
+		Phase:  ".",
+	}
+	for _, f := range features[1:] {
+		span.Start = min(span.Start, f.Start)
+		span.End = max(span.End, f.End)
+	}
+	return &span
+}
+
+// Reads a GTF file and returns its features converted to GFF3. gene_id
+// and transcript_id become ID and Parent attributes. Gene and transcript
+// features are made if the GTF file does not have them
+func gtfToFeatures(infile string) ([]Feature, error) {
+	geneIDs := []string{}
+	genes := map[string]*gtfGene{}
+	noGene := []Feature{}
+	lineNumber := 0
+
+	err := forEachLine(infile, func(line string) error {
+		lineNumber++
+		line = strings.TrimRight(line, "\r\n")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			return nil
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 9 {
+			log.Printf("Warning: problem in GTF file %v, line %d: expected 9 tab-separated columns but got %d (line skipped)", infile, lineNumber, len(fields))
+			return nil
+		}
+		feature, problems := parseGFF3Line(strings.Join(fields[:8], "\t")+"\t.", lineNumber)
+		for _, problem := range problems {
+			log.Printf("Warning: problem in GTF file %v, %v", infile, problem)
+		}
+		if len(problems) > 0 && !problems[len(problems)-1].fixed {
+			return nil
+		}
+		var err error
+		feature.Attributes, err = parseGTFAttributes(fields[8])
+		if err != nil {
+			log.Printf("Warning: problem in GTF file %v, line %d: %v (line skipped)", infile, lineNumber, err)
+			return nil
+		}
+
+		geneID := feature.GetAttribute("gene_id")
+		if geneID == "" {
+			noGene = append(noGene, feature)
+			return nil
+		}
+		gene, exists := genes[geneID]
+		if !exists {
+			gene = &gtfGene{transcripts: map[string]*Feature{}, children: map[string][]Feature{}}
+			genes[geneID] = gene
+			geneIDs = append(geneIDs, geneID)
+		}
+		// gene rows can have a transcript_id, but they are not part of the
+		// transcript
+		transcriptID := feature.GetAttribute("transcript_id")
+		if transcriptID != "" && feature.Type != "gene" {
+			if _, exists := gene.transcripts[transcriptID]; !exists {
+				gene.transcripts[transcriptID] = nil
+				gene.transcriptIDs = append(gene.transcriptIDs, transcriptID)
+			}
+		}
+
+		if feature.Type == "gene" {
+			gene.gene = &feature
+		} else if (feature.Type == "transcript" || feature.Type == "mRNA") && transcriptID != "" {
+			gene.transcripts[transcriptID] = &feature
+		} else {
+			gene.children[transcriptID] = append(gene.children[transcriptID], feature)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usedIDs := map[string]bool{}
+	features := []Feature{}
+	for _, geneID := range geneIDs {
+		gene := genes[geneID]
+		if gene.gene == nil {
+			all := append([]Feature{}, gene.children[""]...)
+			for _, transcriptID := range gene.transcriptIDs {
+				if gene.transcripts[transcriptID] != nil {
+					all = append(all, *gene.transcripts[transcriptID])
+				}
+				all = append(all, gene.children[transcriptID]...)
+			}
+			gene.gene = spanningFeature(all, "gene")
+			gene.gene.Attributes = []Attribute{{Key: "gene_id", Values: []string{geneID}}}
+		}
+		geneGFFID := uniqueID(geneID, usedIDs)
+		gene.gene.Attributes = append([]Attribute{{Key: "ID", Values: []string{geneGFFID}}}, gene.gene.Attributes...)
+		features = append(features, *gene.gene)
+
+		// features with a gene_id but no transcript_id belong to the gene
+		transcriptIDs := append([]string{""}, gene.transcriptIDs...)
+		for _, transcriptID := range transcriptIDs {
+			parentID := geneGFFID
+			if transcriptID != "" {
+				transcript := gene.transcripts[transcriptID]
+				if transcript == nil {
+					transcript = spanningFeature(gene.children[transcriptID], "transcript")
+					transcript.Attributes = []Attribute{
+						{Key: "gene_id", Values: []string{geneID}},
+						{Key: "transcript_id", Values: []string{transcriptID}},
+					}
+				}
+				parentID = uniqueID(transcriptID, usedIDs)
+				transcript.Attributes = append([]Attribute{
+					{Key: "ID", Values: []string{parentID}},
+					{Key: "Parent", Values: []string{geneGFFID}},
+				}, transcript.Attributes...)
+				features = append(features, *transcript)
+			}
+
+			typeCounts := map[string]int{}
+			cdsID := ""
+			for _, child := range gene.children[transcriptID] {
+				childID := ""
+				if child.Type == "CDS" {
+					// CDS rows of one transcript share an ID, as in GFF3
+					if cdsID == "" {
+						cdsID = uniqueID(parentID+".CDS", usedIDs)
+					}
+					childID = cdsID
+				} else {
+					typeCounts[child.Type]++
+					childID = uniqueID(fmt.Sprintf("%v.%v%d", parentID, child.Type, typeCounts[child.Type]), usedIDs)
+				}
+				child.Attributes = append([]Attribute{
+					{Key: "ID", Values: []string{childID}},
+					{Key: "Parent", Values: []string{parentID}},
+				}, child.Attributes...)
+				features = append(features, child)
+			}
+		}
+	}
+	return append(features, noGene...), nil
+}
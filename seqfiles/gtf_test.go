@@ -0,0 +1,63 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGTFAttributes(t *testing.T) {
+	got, err := parseGTFAttributes(`gene_id "g1"; transcript_id "t;1"; exon_number 2; tag "a"; tag "b";`)
+	require.NoError(t, err, "Error parsing GTF attributes")
+	expect := []Attribute{
+		{Key: "gene_id", Values: []string{"g1"}},
+		{Key: "transcript_id", Values: []string{"t;1"}},
+		{Key: "exon_number", Values: []string{"2"}},
+		{Key: "tag", Values: []string{"a", "b"}},
+	}
+	require.Equal(t, expect, got, "Error parsing GTF attributes")
+
+	for _, bad := range []string{`gene_id`, `gene_id "g1`, `gene_id "g1" transcript_id "t1"`} {
+		_, err = parseGTFAttributes(bad)
+		require.Error(t, err, "Should have got error parsing GTF attributes %v", bad)
+	}
+}
+
+func TestIsGTFLine(t *testing.T) {
+	require.True(t, isGTFLine("chr1\tsrc\texon\t1\t10\t.\t+\t.\tgene_id \"g1\";\n"), "Should be GTF line")
+	require.False(t, isGTFLine("chr1\tsrc\texon\t1\t10\t.\t+\t.\tID=g1\n"), "Should not be GTF line")
+	require.False(t, isGTFLine("chr1\t1\t10\n"), "Should not be GTF line")
+}
+
+func TestGTFToGFF3(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "gtf.in.gtf")
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
+	require.Equal(t, GTF, fileType, "Did not get filetype of GTF")
+	outfile := "tmp.test.gtfToGFF3.gff"
+	utils.DeleteFileIfExists(outfile)
+	require.NoError(t, ConvertAnnotationFile(infile, outfile, false), "Error converting annotation file")
+	err = ParseSeqFile(infile, "tmp.test.gtfToGFF3")
+	require.ErrorIs(t, err, ErrUnknownFormat, "Should not import a GTF file as the main input")
+	expectFile := filepath.Join("seqfiles_testdata", "gtf.expect.gff")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outfile)
+	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFile, outfile)
+	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfile)
+	utils.DeleteFileIfExists(outfile)
+}
+
+func TestGTFGeneWithTranscriptID(t *testing.T) {
+	// a gene row with a transcript_id, and no rows for the transcript
+	infile := "tmp.test.gtfGeneWithTranscriptID.gtf"
+	require.NoError(t, os.WriteFile(infile, []byte("c1\tsrc\tgene\t1\t20\t.\t+\t.\tgene_id \"g1\"; transcript_id \"t1\";\n"), 0644), "Error writing file")
+	features, err := gtfToFeatures(infile)
+	require.NoError(t, err, "Error reading GTF file")
+	require.Equal(t, 1, len(features), "Wrong number of features")
+	require.Equal(t, "gene", features[0].Type, "Wrong feature type")
+	require.Equal(t, "g1", features[0].GetAttribute("ID"), "Wrong gene ID")
+	utils.DeleteFileIfExists(infile)
+}
@@ -0,0 +1,172 @@
+package seqfiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// One contiguous part of a genbank/EMBL (INSDC) feature location.
+// start <= end always. startPartial and endPartial refer to start and
+// end, ie the lower and upper coordinates, whatever the strand.
+// accession is only set for remote references like "ACC:1..10"
+type locationSegment struct {
+	accession    string
+	start        int
+	end          int
+	strand       string
+	startPartial bool
+	endPartial   bool
+	between      bool
+}
+
+// Segments are in the biological order, ie the order they are joined
+// to make the feature. For complement(join(a,b)) this is b, a
+type insdcLocation struct {
+	operator string
+	segments []locationSegment
+}
+
+func (l *insdcLocation) isPartial() bool {
+	for _, s := range l.segments {
+		if s.startPartial || s.endPartial {
+			return true
+		}
+	}
+	return false
+}
+
+type locationParser struct {
+	s        string
+	pos      int
+	operator string
+}
+
+// Parses a genbank/EMBL location string, eg "complement(join(<1..20,30..>40))"
+func parseLocation(location string) (insdcLocation, error) {
+	p := locationParser{s: strings.Join(strings.Fields(location), "")}
+	segments, err := p.parseExpression()
+	if err != nil {
+		return insdcLocation{}, err
+	}
+	if p.pos != len(p.s) {
+		return insdcLocation{}, fmt.Errorf("unexpected character at position %d of location %v", p.pos+1, location)
+	}
+	return insdcLocation{operator: p.operator, segments: segments}, nil
+}
+
+func (p *locationParser) consume(prefix string) bool {
+	if strings.HasPrefix(p.s[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (p *locationParser) parseExpression() ([]locationSegment, error) {
+	if p.consume("complement(") {
+		segments, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ')' after complement in location %v", p.s)
+		}
+		complemented := make([]locationSegment, len(segments))
+		for i, s := range segments {
+			if s.strand == "-" {
+				s.strand = "+"
+			} else {
+				s.strand = "-"
+			}
+			complemented[len(segments)-1-i] = s
+		}
+		return complemented, nil
+	}
+
+	for _, operator := range []string{"join", "order"} {
+		if !p.consume(operator + "(") {
+			continue
+		}
+		if p.operator == "" {
+			p.operator = operator
+		}
+		segments := []locationSegment{}
+		for {
+			newSegments, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, newSegments...)
+			if p.consume(")") {
+				return segments, nil
+			}
+			if !p.consume(",") {
+				return nil, fmt.Errorf("expected ',' or ')' at position %d of location %v", p.pos+1, p.s)
+			}
+		}
+	}
+
+	end := strings.IndexAny(p.s[p.pos:], ",)")
+	if end == -1 {
+		end = len(p.s) - p.pos
+	}
+	segment, err := parseLocationSegment(p.s[p.pos : p.pos+end])
+	if err != nil {
+		return nil, err
+	}
+	p.pos += end
+	return []locationSegment{segment}, nil
+}
+
+// Parses one location that has no operators, eg "<1..20", "42", "10^11",
+// "ACC:1..10"
+func parseLocationSegment(s string) (locationSegment, error) {
+	segment := locationSegment{strand: "+"}
+	if accession, coords, found := strings.Cut(s, ":"); found {
+		segment.accession = accession
+		s = coords
+	}
+
+	separator := ".."
+	if strings.Contains(s, "^") {
+		separator = "^"
+		segment.between = true
+	} else if !strings.Contains(s, "..") && strings.Contains(s, ".") {
+		// old style "102.110": a single base somewhere in the range
+		separator = "."
+	}
+
+	startString, endString, found := strings.Cut(s, separator)
+	if !found {
+		endString = startString
+	}
+	var err error
+	segment.start, segment.startPartial, err = parseLocationCoord(startString)
+	if err != nil {
+		return segment, err
+	}
+	segment.end, segment.endPartial, err = parseLocationCoord(endString)
+	if err != nil {
+		return segment, err
+	}
+	if !found {
+		// a single base location like "<42" is partial at both ends
+		segment.endPartial = segment.startPartial || segment.endPartial
+		segment.startPartial = segment.endPartial
+	}
+	if segment.start > segment.end && !segment.between {
+		segment.start, segment.end = segment.end, segment.start
+		segment.startPartial, segment.endPartial = segment.endPartial, segment.startPartial
+	}
+	return segment, nil
+}
+
+func parseLocationCoord(s string) (int, bool, error) {
+	partial := strings.HasPrefix(s, "<") || strings.HasPrefix(s, ">")
+	coord, err := strconv.Atoi(strings.TrimLeft(s, "<>"))
+	if err != nil || coord < 1 {
+		return 0, false, fmt.Errorf("bad coordinate in location: '%v'", s)
+	}
+	return coord, partial, nil
+}
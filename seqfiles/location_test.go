@@ -0,0 +1,55 @@
+package seqfiles
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseLocation(t *testing.T) {
+	got, err := parseLocation("42")
+	require.NoError(t, err, "Error parsing location")
+	require.Equal(t, insdcLocation{segments: []locationSegment{{start: 42, end: 42, strand: "+"}}}, got, "Error parsing single base location")
+
+	got, err = parseLocation("<1..>206")
+	require.NoError(t, err, "Error parsing location")
+	expect := insdcLocation{segments: []locationSegment{{start: 1, end: 206, strand: "+", startPartial: true, endPartial: true}}}
+	require.Equal(t, expect, got, "Error parsing partial location")
+	require.True(t, got.isPartial(), "Location should be partial")
+
+	got, err = parseLocation("complement(join(1..10,20..30))")
+	require.NoError(t, err, "Error parsing location")
+	expect = insdcLocation{
+		operator: "join",
+		segments: []locationSegment{
+			{start: 20, end: 30, strand: "-"},
+			{start: 1, end: 10, strand: "-"},
+		},
+	}
+	require.Equal(t, expect, got, "Error parsing complement(join())")
+	require.False(t, got.isPartial(), "Location should not be partial")
+
+	got, err = parseLocation("join(complement(20..30),complement(1..10))")
+	require.NoError(t, err, "Error parsing location")
+	require.Equal(t, expect, got, "Error parsing join(complement(),complement())")
+
+	got, err = parseLocation("order(25..20,\n                     ACC.1:3..4,5^6)")
+	require.NoError(t, err, "Error parsing location")
+	expect = insdcLocation{
+		operator: "order",
+		segments: []locationSegment{
+			{start: 20, end: 25, strand: "+"},
+			{accession: "ACC.1", start: 3, end: 4, strand: "+"},
+			{start: 5, end: 6, strand: "+", between: true},
+		},
+	}
+	require.Equal(t, expect, got, "Error parsing order() location")
+
+	got, err = parseLocation("102.110")
+	require.NoError(t, err, "Error parsing location")
+	require.Equal(t, insdcLocation{segments: []locationSegment{{start: 102, end: 110, strand: "+"}}}, got, "Error parsing old style single base location")
+
+	for _, bad := range []string{"", "join(1..2", "complement(1..2", "1..x", "join(1..2)3", "0..10"} {
+		_, err = parseLocation(bad)
+		require.Error(t, err, "Should have got error parsing location '%v'", bad)
+	}
+}
@@ -0,0 +1,199 @@
+package seqfiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shenwei356/xopen"
+	"log"
+	"strings"
+)
+
+// Which identifier in a genbank or EMBL record is used as the sequence
+// name
+type SeqNameSource uint64
+
+const (
+	// The name from the LOCUS line (genbank) or ID line (EMBL)
+	NameFromLocus SeqNameSource = iota
+	// The first accession from the ACCESSION line (genbank) or AC line
+	// (EMBL)
+	NameFromAccession
+	// The accession.version from the VERSION line (genbank), or from the
+	// ID line or SV line (EMBL)
+	NameFromVersion
+)
+
+func ParseSeqNameSource(source string) (SeqNameSource, error) {
+	switch strings.ToLower(source) {
+	case "locus", "id":
+		return NameFromLocus, nil
+	case "accession", "ac":
+		return NameFromAccession, nil
+	case "version":
+		return NameFromVersion, nil
+	}
+	return NameFromLocus, fmt.Errorf("Unknown sequence name source '%v'. Must be one of: locus, accession, version", source)
+}
+
+// SeqMetadata is the information about one genbank or EMBL record that is
+// not in the imported sequence and annotation files. Name is the name the
+// sequence was imported with, before any renaming
+type SeqMetadata struct {
+	Name       string `json:"name"`
+	Locus      string `json:"locus"`
+	Accession  string `json:"accession"`
+	Version    string `json:"version"`
+	Definition string `json:"definition"`
+	Organism   string `json:"organism"`
+	Taxon      string `json:"taxon"`
+	// The CONTIG (genbank) or CO (EMBL) location of a record that is made
+	// from other records, and has no sequence of its own
+	Contig string `json:"contig,omitempty"`
+	// The range of WGS accessions of a WGS master record
+	WGS string `json:"wgs,omitempty"`
+	// True if the record was not imported because it has no sequence
+	Skipped bool `json:"skipped,omitempty"`
+	hasSeq  bool
+}
+
+// GenomeMetadata is written to outprefix.metadata.json when importing a
+// genbank or EMBL file. Title, Organism and Taxon are from the first
+// sequence that has them
+type GenomeMetadata struct {
+	Title     string        `json:"title"`
+	Organism  string        `json:"organism"`
+	Taxon     string        `json:"taxon"`
+	Sequences []SeqMetadata `json:"sequences"`
+}
+
+// Returns the identifier chosen by source. Falls back to the LOCUS/ID
+// name if the record does not have that identifier
+func (m *SeqMetadata) seqName(source SeqNameSource) string {
+	name := ""
+	switch source {
+	case NameFromAccession:
+		name = m.Accession
+	case NameFromVersion:
+		name = m.Version
+	}
+	if name == "" {
+		if source != NameFromLocus {
+			log.Printf("Warning: no accession or version found for record %v, so using its LOCUS/ID name", m.Locus)
+		}
+		return m.Locus
+	}
+	return name
+}
+
+// Adds the information from one header line of a genbank or EMBL record.
+// previousKeyword is the keyword of the previous line, which is needed
+// for DEFINITION lines that continue onto the next line. Returns the
+// keyword of this line
+func (m *SeqMetadata) addHeaderLine(line string, fformat FileFormat, previousKeyword string) string {
+	line = strings.TrimRight(line, "\r\n")
+	keyword := previousKeyword
+	value := ""
+	if fformat == GENBANK {
+		if strings.HasPrefix(line, "            ") {
+			value = strings.TrimSpace(line)
+		} else {
+			keyword, value, _ = strings.Cut(strings.TrimSpace(line), " ")
+			value = strings.TrimSpace(value)
+		}
+	} else {
+		keyword, value, _ = strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+	}
+	if value == "" {
+		return keyword
+	}
+	firstField := strings.TrimRight(strings.Fields(value)[0], ";")
+
+	switch keyword {
+	case "ACCESSION", "AC":
+		if m.Accession == "" {
+			m.Accession = firstField
+		}
+	case "VERSION", "SV":
+		m.Version = firstField
+	case "DEFINITION", "DE":
+		if keyword == previousKeyword && m.Definition != "" {
+			m.Definition += " " + value
+		} else {
+			m.Definition = value
+		}
+	case "ORGANISM", "OS":
+		if keyword != previousKeyword {
+			m.Organism = value
+		}
+	case "CONTIG", "CO":
+		if keyword == previousKeyword {
+			m.Contig += value
+		} else {
+			m.Contig = value
+		}
+	case "WGS":
+		m.WGS = value
+	}
+	return keyword
+}
+
+// Returns the accession.version from an EMBL ID line, eg
+// "ID   X56734; SV 1; linear; mRNA; STD; PLN; 1859 BP." gives X56734.1.
+// Returns "" if the line does not have a version
+func versionFromEmblIDLine(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[2] != "SV" {
+		return ""
+	}
+	return strings.TrimRight(fields[1], ";") + "." + strings.TrimRight(fields[3], ";")
+}
+
+// Adds the organism and taxon from the source feature, where they are
+// not already known from the header
+func (m *SeqMetadata) addSourceFeature(features []genbankFeature) {
+	for _, f := range features {
+		if f.key != "source" {
+			continue
+		}
+		if organisms := f.qualifierValues("organism"); m.Organism == "" && len(organisms) > 0 {
+			m.Organism = organisms[0]
+		}
+		for _, xref := range f.qualifierValues("db_xref") {
+			if taxon, found := strings.CutPrefix(xref, "taxon:"); found && m.Taxon == "" {
+				m.Taxon = taxon
+			}
+		}
+	}
+}
+
+func writeGenomeMetadata(sequences []SeqMetadata, outfile string) (err error) {
+	metadata := GenomeMetadata{Sequences: sequences}
+	for _, s := range sequences {
+		if metadata.Title == "" {
+			metadata.Title = strings.TrimSuffix(s.Definition, ".")
+		}
+		if metadata.Organism == "" {
+			metadata.Organism = s.Organism
+		}
+		if metadata.Taxon == "" {
+			metadata.Taxon = s.Taxon
+		}
+	}
+	if metadata.Title == "" {
+		metadata.Title = metadata.Organism
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error making JSON for file %v: %w", outfile, err)
+	}
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	if _, err := fout.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", outfile, err)
+	}
+	return nil
+}
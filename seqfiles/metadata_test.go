@@ -0,0 +1,81 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSeqNameSource(t *testing.T) {
+	tests := map[string]SeqNameSource{"locus": NameFromLocus, "ACCESSION": NameFromAccession, "ac": NameFromAccession, "version": NameFromVersion}
+	for name, expect := range tests {
+		got, err := ParseSeqNameSource(name)
+		require.NoError(t, err, "Error parsing name source %v", name)
+		require.Equal(t, expect, got, "Wrong name source for %v", name)
+	}
+	_, err := ParseSeqNameSource("definition")
+	require.Error(t, err, "Should fail with unknown name source")
+}
+
+func TestAddHeaderLine(t *testing.T) {
+	m := SeqMetadata{Locus: "seq1"}
+	keyword := ""
+	for _, line := range []string{
+		"DEFINITION  Foo bar chromosome,",
+		"            complete genome.",
+		"ACCESSION   NC_000001 REGION: 1..100",
+		"VERSION     NC_000001.2",
+		"SOURCE      Foo bar",
+		"  ORGANISM  Foo bar",
+		"            Bacteria; Foo.",
+	} {
+		keyword = m.addHeaderLine(line+"\n", GENBANK, keyword)
+	}
+	require.Equal(t, SeqMetadata{Locus: "seq1", Accession: "NC_000001", Version: "NC_000001.2", Definition: "Foo bar chromosome, complete genome.", Organism: "Foo bar"}, m, "Wrong metadata from genbank header")
+
+	m = SeqMetadata{Locus: "seq1"}
+	keyword = ""
+	for _, line := range []string{"XX", "AC   X56734; S46826;", "XX", "DE   Foo bar", "DE   plasmid", "XX", "OS   Foo bar"} {
+		keyword = m.addHeaderLine(line, EMBL, keyword)
+	}
+	require.Equal(t, SeqMetadata{Locus: "seq1", Accession: "X56734", Definition: "Foo bar plasmid", Organism: "Foo bar"}, m, "Wrong metadata from EMBL header")
+	require.Equal(t, "X56734.3", versionFromEmblIDLine("ID   X56734; SV 3; linear; mRNA; STD; PLN; 1859 BP."), "Wrong version from EMBL ID line")
+	require.Equal(t, "", versionFromEmblIDLine("ID   Contig1; foo; bar"), "Should be no version from EMBL ID line")
+}
+
+func TestImportWithSeqNameSource(t *testing.T) {
+	outprefix := "tmp.test.seqNameSource"
+	tests := []struct {
+		infile     string
+		nameSource SeqNameSource
+		expect     []string
+	}{
+		{"parseGenbank.in.gbk", NameFromAccession, []string{"U12345", "U54321"}},
+		{"parseGenbank.in.gbk", NameFromVersion, []string{"U12345.1", "U54321.1"}},
+		{"parseEMBL.in.embl", NameFromAccession, []string{"abc123", "123456"}},
+		{"parseEMBL.in.embl", NameFromVersion, []string{"Contig1", "Contig2.1"}},
+	}
+	for _, test := range tests {
+		options := DefaultImportOptions()
+		options.SeqNameSource = test.nameSource
+		require.NoError(t, ParseSeqFileWithOptions(filepath.Join("seqfiles_testdata", test.infile), outprefix, options), "Error importing file %v", test.infile)
+		records, err := readSeqsAndAnnotation(outprefix+".fa", outprefix+".gff")
+		require.NoError(t, err, "Error reading imported files")
+		require.Equal(t, len(test.expect), len(records), "Wrong number of sequences from %v", test.infile)
+		for i, record := range records {
+			require.Equal(t, test.expect[i], record.Name, "Wrong sequence name from %v", test.infile)
+			for _, f := range record.Features {
+				require.Equal(t, test.expect[i], f.SeqID, "Wrong feature sequence ID from %v", test.infile)
+			}
+		}
+	}
+
+	// importing FASTA should remove metadata from a previous import
+	require.True(t, utils.FileExists(outprefix+".metadata.json"), "Metadata file not written")
+	require.NoError(t, ParseSeqFile(filepath.Join("seqfiles_testdata", "parseFasta.in.fa.gz"), outprefix), "Error importing FASTA file")
+	require.False(t, utils.FileExists(outprefix+".metadata.json"), "Metadata file should be deleted")
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".annotation_problems.tsv", ".metadata.json", ".faa"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
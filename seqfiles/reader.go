@@ -0,0 +1,464 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SeqRecord is one sequence and its annotation, as read from a sequence
+// or annotation file by a Reader
+type SeqRecord struct {
+	Name string
+	// The sequence, with case kept as it is in the input file. Empty for
+	// records from annotation-only input, eg a GTF file, or GFF3
+	// features on a sequence that is not in the FASTA section
+	Seq string
+	// Base qualities, only set for FASTQ input
+	Quality string
+	// True if a genbank/EMBL record is circular, or a GFF3 region (or
+	// source, from imported genbank/EMBL) feature has Is_circular=true
+	Circular bool
+	// Length from the ##sequence-region pragma of GFF3 input, or zero
+	RegionLength int
+	Features     []Feature
+	// Identifiers and description of the record. Only set for genbank
+	// and EMBL input
+	Metadata *SeqMetadata
+}
+
+// Reader reads SeqRecords from a FASTA, FASTQ, GFF3, genbank, EMBL, GTF
+// or BED file. Use NewReader to make one, then call Read until it
+// returns io.EOF
+type Reader struct {
+	// If true, any problem in GFF3 input is an error. Otherwise problems
+	// are fixed where possible, lines that cannot be fixed are skipped,
+	// and each problem is logged as a warning. Set before the first call
+	// to Read
+	StrictGFF3 bool
+	// Which identifier of genbank and EMBL records is used as the
+	// sequence name. Set before the first call to Read
+	NameSource SeqNameSource
+
+	filename   string
+	format     FileFormat
+	reader     *xopen.Reader
+	lineNumber int
+	unread     bool
+	lastLine   string
+	started    bool
+	// genbank/EMBL feature IDs must be unique across all records
+	usedIDs map[string]bool
+	// annotation-only formats, and the annotation of GFF3 files, are
+	// read in full at the start. pending has the records in the order
+	// their sequences were first seen, and are returned after any
+	// sequences in the file
+	pending      []*SeqRecord
+	pendingIndex map[string]*SeqRecord
+}
+
+// NewReader opens a file for reading SeqRecords. The file format is
+// detected from the contents of the file
+func NewReader(filename string) (*Reader, error) {
+	if !utils.FileExists(filename) {
+		return nil, fmt.Errorf("file not found %v", filename)
+	}
+	format, err := GetFileType(filename)
+	if err != nil {
+		return nil, err
+	} else if format == Unknown {
+		return nil, fmt.Errorf("%w: could not determine type of file %v", ErrUnknownFormat, filename)
+	}
+	return newReaderWithFormat(filename, format)
+}
+
+func newReaderWithFormat(filename string, format FileFormat) (*Reader, error) {
+	reader, err := xopen.Ropen(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %v: %w", filename, err)
+	}
+	return &Reader{
+		filename:     filename,
+		format:       format,
+		reader:       reader,
+		usedIDs:      map[string]bool{},
+		pendingIndex: map[string]*SeqRecord{},
+	}, nil
+}
+
+// Format returns the format of the file being read
+func (r *Reader) Format() FileFormat {
+	return r.format
+}
+
+func (r *Reader) Close() error {
+	return r.reader.Close()
+}
+
+// Read returns the next record from the file. It returns io.EOF when
+// there are no more records
+func (r *Reader) Read() (*SeqRecord, error) {
+	if !r.started {
+		r.started = true
+		var features []Feature
+		var err error
+		switch r.format {
+		case GFF3:
+			features, err = r.readGFF3Annotation()
+		case GTF:
+			features, err = gtfToFeatures(r.filename)
+		case BED:
+			features, err = bedToFeatures(r.filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.addPendingFeatures(features)
+	}
+
+	var record *SeqRecord
+	var err error
+	switch r.format {
+	case FASTA, GFF3:
+		record, err = r.readFasta()
+	case FASTQ:
+		record, err = r.readFastq()
+	case GENBANK, EMBL:
+		record, err = r.readGenbankOrEmbl()
+	case GTF, BED:
+		err = io.EOF
+	default:
+		return nil, fmt.Errorf("%w: cannot read records from file %v", ErrUnknownFormat, r.filename)
+	}
+
+	if err == io.EOF && len(r.pending) > 0 {
+		record = r.pending[0]
+		r.pending = r.pending[1:]
+		return record, nil
+	}
+	return record, err
+}
+
+// Returns the next line, without the line ending or a byte order mark.
+// Returns io.EOF when there are no more lines
+func (r *Reader) readLine() (string, error) {
+	if r.unread {
+		r.unread = false
+		return r.lastLine, nil
+	}
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		if err != io.EOF {
+			return "", fmt.Errorf("error reading file %v: %w", r.filename, err)
+		}
+		if len(line) == 0 {
+			return "", io.EOF
+		}
+	}
+	if r.lineNumber == 0 {
+		line = strings.TrimPrefix(line, utf8BOM)
+	}
+	r.lineNumber++
+	r.lastLine = strings.TrimRight(line, "\r\n")
+	return r.lastLine, nil
+}
+
+// Makes the next call to readLine return the last line again
+func (r *Reader) unreadLine() {
+	r.unread = true
+}
+
+// Removes and returns the pending record with the given name, or returns
+// a new record if there is not one
+func (r *Reader) takePending(name string) *SeqRecord {
+	record, exists := r.pendingIndex[name]
+	if !exists {
+		return &SeqRecord{Name: name}
+	}
+	delete(r.pendingIndex, name)
+	for i := range r.pending {
+		if r.pending[i] == record {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			break
+		}
+	}
+	return record
+}
+
+func (r *Reader) getOrAddPending(name string) *SeqRecord {
+	record, exists := r.pendingIndex[name]
+	if !exists {
+		record = &SeqRecord{Name: name}
+		r.pendingIndex[name] = record
+		r.pending = append(r.pending, record)
+	}
+	return record
+}
+
+func (r *Reader) addPendingFeatures(features []Feature) {
+	for _, feature := range features {
+		record := r.getOrAddPending(feature.SeqID)
+		record.Features = append(record.Features, feature)
+		if (feature.Type == "region" || feature.Type == "source") && feature.GetAttribute("Is_circular") == "true" {
+			record.Circular = true
+		}
+	}
+}
+
+// Reads one record from a FASTA file, or the FASTA section of a GFF3 file.
+// The name is the first word of the header line. Blank lines and comment
+// lines starting with '#' are skipped
+func (r *Reader) readFasta() (*SeqRecord, error) {
+	line, err := r.readLine()
+	for err == nil && (len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#")) {
+		line, err = r.readLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, ">"))
+	if !strings.HasPrefix(line, ">") || len(fields) == 0 {
+		return nil, fmt.Errorf("%w: expected a FASTA header line starting with '>' followed by a name in file %v, line %d, but got: %v", ErrBadFormat, r.filename, r.lineNumber, line)
+	}
+	record := r.takePending(fields[0])
+	var seq strings.Builder
+
+	for {
+		line, err = r.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, ">") {
+			r.unreadLine()
+			break
+		} else if strings.HasPrefix(line, "#") {
+			continue
+		}
+		seq.WriteString(strings.TrimSpace(line))
+	}
+	record.Seq = seq.String()
+	return record, nil
+}
+
+// Reads one record from a FASTQ file. The name is the first word of the
+// header line. The sequence and qualities can each be split over more
+// than one line. If the '+' line has more than just '+', it must be the
+// same as the header line
+func (r *Reader) readFastq() (*SeqRecord, error) {
+	header, err := r.readLine()
+	for err == nil && len(strings.TrimSpace(header)) == 0 {
+		header, err = r.readLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+	headerLineNumber := r.lineNumber
+	fields := strings.Fields(strings.TrimPrefix(header, "@"))
+	if !strings.HasPrefix(header, "@") || len(fields) == 0 {
+		return nil, fmt.Errorf("%w: expected a FASTQ header line starting with '@' followed by a name in file %v, line %d, but got: %v", ErrBadFormat, r.filename, r.lineNumber, header)
+	}
+
+	var seq strings.Builder
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: incomplete FASTQ record at end of file %v, no '+' line after header on line %d: %v", ErrBadFormat, r.filename, headerLineNumber, header)
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "+") {
+			repeated := strings.TrimSpace(strings.TrimPrefix(line, "+"))
+			if repeated != "" && repeated != strings.TrimSpace(strings.TrimPrefix(header, "@")) {
+				return nil, fmt.Errorf("%w: FASTQ file %v, line %d: '+' line does not match header line %d: %v", ErrBadFormat, r.filename, r.lineNumber, headerLineNumber, line)
+			}
+			break
+		}
+		seq.WriteString(strings.TrimSpace(line))
+	}
+
+	// quality lines can start with '@' or '+', so use the sequence length
+	// to know when the qualities end
+	var quality strings.Builder
+	for quality.Len() < seq.Len() {
+		line, err := r.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		quality.WriteString(strings.TrimSpace(line))
+	}
+	if quality.Len() != seq.Len() {
+		return nil, fmt.Errorf("%w: FASTQ file %v, record starting on line %d: sequence length %d but quality length %d: %v", ErrBadFormat, r.filename, headerLineNumber, seq.Len(), quality.Len(), header)
+	}
+	for _, q := range quality.String() {
+		if q < '!' || q > '~' {
+			return nil, fmt.Errorf("%w: FASTQ file %v, record starting on line %d: bad quality character '%c': %v", ErrBadFormat, r.filename, headerLineNumber, q, header)
+		}
+	}
+	return &SeqRecord{Name: fields[0], Seq: seq.String(), Quality: quality.String()}, nil
+}
+
+var genbankSeqReplaceRe = regexp.MustCompile(`[\s0-9]`)
+
+// Reads one record from a genbank or EMBL file. The features are
+// converted to GFF3 features
+func (r *Reader) readGenbankOrEmbl() (*SeqRecord, error) {
+	var record *SeqRecord
+	features := []genbankFeature{}
+	var seq strings.Builder
+	inHeader := false
+	inFeatures := false
+	inTrailer := false
+	inSeq := false
+	headerKeyword := ""
+
+	finishRecord := func() *SeqRecord {
+		if inHeader {
+			record.Name = record.Metadata.seqName(r.NameSource)
+			record.Metadata.Name = record.Name
+		}
+		record.Metadata.addSourceFeature(features)
+		if len(features) > 0 {
+			record.Features = genbankFeaturesToGFF3(record.Name, record.Circular, features, r.usedIDs)
+		}
+		record.Seq = seq.String()
+		record.Metadata.hasSeq = record.Seq != ""
+		return record
+	}
+
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			if record == nil {
+				return nil, io.EOF
+			}
+			return finishRecord(), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		seqname, err := seqnameFromLineGenbankOrEMBL(line, r.format)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			if seqname != "" {
+				record = &SeqRecord{Name: seqname, Circular: isCircularGenbankOrEMBL(line), Metadata: &SeqMetadata{Locus: seqname}}
+				if r.format == EMBL {
+					record.Metadata.Version = versionFromEmblIDLine(line)
+				}
+				inHeader = true
+			}
+			continue
+		} else if seqname != "" {
+			// new record without a "//" line to end the previous one
+			r.unreadLine()
+			return finishRecord(), nil
+		}
+
+		if line == "//" {
+			return finishRecord(), nil
+		} else if inSeq {
+			seq.WriteString(genbankSeqReplaceRe.ReplaceAllString(line, ""))
+			continue
+		} else if lineMarksGebnkaOrEmblSequenceStart(line, r.format) {
+			if inHeader {
+				record.Name = record.Metadata.seqName(r.NameSource)
+				record.Metadata.Name = record.Name
+			}
+			inHeader = false
+			inFeatures = false
+			inTrailer = false
+			inSeq = true
+			continue
+		}
+
+		// Genbank and EMBL feature lines are the same, except that EMBL
+		// startswith "FT", whereas Genbank is spaces
+		if inFeatures && r.format == EMBL && strings.HasPrefix(line, "FT ") {
+			line = strings.Replace(line, "FT", "  ", 1)
+		}
+		if inFeatures && len(line) > 0 && line[0] != ' ' {
+			// the features are followed by more keyword lines, eg
+			// CONTIG in genbank or CO in EMBL, before the sequence
+			inFeatures = false
+			inTrailer = true
+		}
+
+		if inHeader {
+			if endGenbankOrEmblHeader(line, r.format) {
+				inHeader = false
+				inFeatures = true
+				record.Name = record.Metadata.seqName(r.NameSource)
+				record.Metadata.Name = record.Name
+			} else {
+				headerKeyword = record.Metadata.addHeaderLine(line, r.format, headerKeyword)
+			}
+		} else if inTrailer {
+			headerKeyword = record.Metadata.addHeaderLine(line, r.format, headerKeyword)
+		} else if inFeatures && strings.HasPrefix(line, "     ") {
+			features = addGenbankOrEmblFeatureLine(features, line)
+		}
+	}
+}
+
+// Reads the annotation part of a GFF3 file, up to the FASTA section if
+// there is one. Lengths from ##sequence-region pragmas are put into
+// pending records, and the features are returned
+func (r *Reader) readGFF3Annotation() ([]Feature, error) {
+	features := []Feature{}
+
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(line, "##FASTA") {
+			break
+		} else if strings.HasPrefix(line, ">") {
+			// the FASTA section is allowed to start without a ##FASTA line
+			r.unreadLine()
+			break
+		} else if strings.HasPrefix(line, "##sequence-region") {
+			fields := strings.Fields(line)
+			end := 0
+			if len(fields) == 4 {
+				end, err = strconv.Atoi(fields[3])
+			}
+			if len(fields) != 4 || err != nil || end < 1 {
+				if r.StrictGFF3 {
+					return nil, fmt.Errorf("%w: GFF3 file %v, line %d: bad ##sequence-region pragma: %v", ErrBadFormat, r.filename, r.lineNumber, line)
+				}
+				log.Printf("Warning: problem in GFF3 file %v, line %d: bad ##sequence-region pragma (line skipped): %v", r.filename, r.lineNumber, line)
+				continue
+			}
+			r.getOrAddPending(fields[1]).RegionLength = end
+			continue
+		} else if len(strings.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+
+		feature, problems := parseGFF3Line(line, r.lineNumber)
+		for _, problem := range problems {
+			if r.StrictGFF3 {
+				return nil, fmt.Errorf("%w: GFF3 file %v, %v", ErrBadFormat, r.filename, problem)
+			}
+			log.Printf("Warning: problem in GFF3 file %v, %v", r.filename, problem)
+		}
+		if len(problems) == 0 || problems[len(problems)-1].fixed {
+			features = append(features, feature)
+		}
+	}
+	return features, nil
+}
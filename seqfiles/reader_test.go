@@ -0,0 +1,85 @@
+package seqfiles
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// Returns all the records from a file
+func readAllRecords(t *testing.T, filename string) []*SeqRecord {
+	reader, err := NewReader(filename)
+	require.NoError(t, err, "Error making reader for file %v", filename)
+	defer reader.Close()
+	records := []*SeqRecord{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err, "Error reading record from file %v", filename)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestReaderFasta(t *testing.T) {
+	records := readAllRecords(t, filepath.Join("seqfiles_testdata", "parseFasta.in.fa.gz"))
+	require.Equal(t, 2, len(records), "Wrong number of records")
+	require.Equal(t, SeqRecord{Name: "seq1", Seq: "aCGTgT"}, *records[0], "Wrong first record")
+	require.Equal(t, SeqRecord{Name: "seq2", Seq: "ATG"}, *records[1], "Wrong second record")
+
+	_, err := NewReader(filepath.Join("seqfiles_testdata", "notafile"))
+	require.Error(t, err, "Should be error making reader for file that does not exist")
+}
+
+func TestReaderFastq(t *testing.T) {
+	records := readAllRecords(t, filepath.Join("seqfiles_testdata", "parseFastq.in.fq"))
+	require.Equal(t, 2, len(records), "Wrong number of records")
+	require.Equal(t, SeqRecord{Name: "seq1", Seq: "ACGT", Quality: "IHGF"}, *records[0], "Wrong first record")
+	require.Equal(t, SeqRecord{Name: "seq2", Seq: "aaAATG", Quality: "IIIIII"}, *records[1], "Wrong second record")
+}
+
+func TestReaderGFF3(t *testing.T) {
+	records := readAllRecords(t, filepath.Join("seqfiles_testdata", "parseGFF3.in.gff"))
+	require.Equal(t, 2, len(records), "Wrong number of records")
+	require.Equal(t, "seq1", records[0].Name, "Wrong name of first record")
+	require.Equal(t, "ACGTACGTAC", records[0].Seq, "Wrong sequence of first record")
+	require.Equal(t, 10, records[0].RegionLength, "Wrong region length of first record")
+	require.Equal(t, 1, len(records[0].Features), "Wrong number of features in first record")
+	require.Equal(t, "name1", records[0].Features[0].GetAttribute("name"), "Wrong name attribute of feature")
+	require.Equal(t, "seq2", records[1].Name, "Wrong name of second record")
+	require.Equal(t, "ttgagaAG", records[1].Seq, "Wrong sequence of second record")
+	require.Equal(t, 2, len(records[1].Features), "Wrong number of features in second record")
+
+	// annotation only, so records have no sequence
+	records = readAllRecords(t, filepath.Join("seqfiles_testdata", "checkAnnotation.in.gff"))
+	require.Equal(t, 3, len(records), "Wrong number of records")
+	for i, name := range []string{"seq1", "seq2", "seq3"} {
+		require.Equal(t, name, records[i].Name, "Wrong record name")
+		require.Equal(t, "", records[i].Seq, "Record should have no sequence")
+	}
+	require.Equal(t, 3, len(records[0].Features), "Wrong number of features in first record")
+	require.Equal(t, 20, records[1].RegionLength, "Wrong region length of second record")
+}
+
+func TestReaderGenbank(t *testing.T) {
+	records := readAllRecords(t, filepath.Join("seqfiles_testdata", "parseGenbank.in.gbk"))
+	require.Equal(t, 2, len(records), "Wrong number of records")
+	require.Equal(t, "Contig1", records[0].Name, "Wrong name of first record")
+	require.False(t, records[0].Circular, "First record should not be circular")
+	require.Equal(t, 166, len(records[0].Seq), "Wrong sequence length of first record")
+	require.Equal(t, 11, len(records[0].Features), "Wrong number of features in first record")
+	require.Equal(t, "Contig2", records[1].Name, "Wrong name of second record")
+	require.True(t, records[1].Circular, "Second record should be circular")
+	require.Equal(t, 13, len(records[1].Features), "Wrong number of features in second record")
+}
+
+func TestReaderGTF(t *testing.T) {
+	records := readAllRecords(t, filepath.Join("seqfiles_testdata", "gtf.in.gtf"))
+	require.Equal(t, 2, len(records), "Wrong number of records")
+	require.Equal(t, "chr1", records[0].Name, "Wrong name of first record")
+	require.Equal(t, "", records[0].Seq, "Record should have no sequence")
+	require.Equal(t, "g1", records[0].Features[0].GetAttribute("ID"), "Wrong ID of first feature")
+}
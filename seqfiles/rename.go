@@ -0,0 +1,135 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"log"
+	"strings"
+)
+
+// Returns the new name for each of the given sequence names, in the same
+// order. Each name gets the prefix added. If makeUnique is true, then
+// names that are already used get ".N" added, using the smallest N >= 2
+// that makes a name that is not used and is not one of the other names.
+// If makeUnique is false and there are duplicate names, they are returned
+// as the second value
+func newSequenceNames(names []string, prefix string, makeUnique bool) ([]string, []string) {
+	allNames := map[string]bool{}
+	for _, name := range names {
+		allNames[prefix+name] = true
+	}
+	newNames := make([]string, len(names))
+	used := map[string]bool{}
+	duplicates := []string{}
+
+	for i, name := range names {
+		newName := prefix + name
+		if used[newName] {
+			if !makeUnique {
+				duplicates = append(duplicates, name)
+				newNames[i] = newName
+				continue
+			}
+			base := newName
+			for n := 2; used[newName] || allNames[newName]; n++ {
+				newName = fmt.Sprintf("%v.%d", base, n)
+			}
+		}
+		used[newName] = true
+		newNames[i] = newName
+	}
+	return newNames, duplicates
+}
+
+// Changes the sequence names in fastaFile and the matching sequence IDs
+// in annotFile, by adding the prefix to each name, and making duplicated
+// names unique if makeUnique is true. It is an error to have duplicated
+// names when makeUnique is false. If any names changed, a table of
+// original and new names is written to tsvFile. Otherwise tsvFile is
+// deleted if it exists. Annotation on a duplicated name stays with the
+// first sequence that has that name
+func renameSequences(fastaFile string, annotFile string, tsvFile string, prefix string, makeUnique bool) (err error) {
+	if err := utils.DeleteFileIfExists(tsvFile); err != nil {
+		return err
+	}
+	names, _, err := getSeqLengthsFromSingleLineFasta(fastaFile)
+	if err != nil {
+		return err
+	}
+	newNames, duplicates := newSequenceNames(names, prefix, makeUnique)
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w in %v: %v. Use the option to make names unique, or rename the sequences", ErrDuplicateNames, fastaFile, strings.Join(duplicates, ", "))
+	}
+	nameMap := map[string]string{}
+	changed := false
+	for i, name := range names {
+		if name != newNames[i] {
+			changed = true
+		}
+		if _, exists := nameMap[name]; exists {
+			log.Printf("Warning: duplicated sequence name %v renamed to %v. Any annotation for %v is kept on %v", name, newNames[i], name, nameMap[name])
+		} else {
+			nameMap[name] = newNames[i]
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	// sequence lines are rewritten in pieces, so that long sequences are
+	// not held in memory
+	seqIndex := 0
+	inHeader := false
+	err = rewriteFilePieces(fastaFile, func(piece []byte, lineStart bool, lineEnd bool) ([]byte, error) {
+		if lineStart {
+			inHeader = len(piece) > 0 && piece[0] == '>'
+			if inHeader {
+				seqIndex++
+				return []byte(">" + newNames[seqIndex-1] + "\n"), nil
+			}
+		}
+		if inHeader {
+			return nil, nil
+		}
+		return piece, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if utils.FileExists(annotFile) {
+		err = rewriteFileLines(annotFile, func(line string) (string, error) {
+			if strings.HasPrefix(line, "##sequence-region") {
+				fields := strings.Fields(line)
+				if len(fields) > 1 {
+					if newName, exists := nameMap[fields[1]]; exists {
+						fields[1] = newName
+					}
+				}
+				return strings.Join(fields, " ") + "\n", nil
+			} else if strings.HasPrefix(line, "#") {
+				return line, nil
+			}
+			seqID, rest, _ := strings.Cut(line, "\t")
+			if newName, exists := nameMap[seqID]; exists {
+				return newName + "\t" + rest, nil
+			}
+			return line, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fout, err := xopen.Wopen(tsvFile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", tsvFile, err)
+	}
+	defer closeWriter(fout, tsvFile, &err)
+	fout.WriteString("original_name\tnew_name\n")
+	for i, name := range names {
+		fout.WriteString(name + "\t" + newNames[i] + "\n")
+	}
+	return nil
+}
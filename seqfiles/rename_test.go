@@ -0,0 +1,59 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSequenceNames(t *testing.T) {
+	names := []string{"a", "b", "a", "a.2", "a"}
+	got, duplicates := newSequenceNames(names, "", false)
+	require.Equal(t, names, got, "Names should not change")
+	require.Equal(t, []string{"a", "a"}, duplicates, "Wrong duplicated names")
+
+	got, duplicates = newSequenceNames(names, "", true)
+	require.Equal(t, []string{"a", "b", "a.3", "a.2", "a.4"}, got, "Wrong unique names")
+	require.Equal(t, 0, len(duplicates), "Should be no duplicated names")
+
+	got, duplicates = newSequenceNames(names, "g1.", true)
+	require.Equal(t, []string{"g1.a", "g1.b", "g1.a.3", "g1.a.2", "g1.a.4"}, got, "Wrong prefixed unique names")
+	require.Equal(t, 0, len(duplicates), "Should be no duplicated names")
+}
+
+func TestRenameSequences(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "renameSequences.in.gff")
+	outprefix := "tmp.test.renameSequences"
+	options := DefaultImportOptions()
+	options.MinGapLen = 0
+	options.UniqueNames = true
+	options.NamePrefix = "g1."
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+	cmp := equalfile.New(nil, equalfile.Options{})
+
+	for _, suffix := range []string{"fa", "gff", "names.tsv"} {
+		expectFile := filepath.Join("seqfiles_testdata", "renameSequences.expect."+suffix)
+		gotFile := outprefix + "." + suffix
+		filesEqual, err := cmp.CompareFile(expectFile, gotFile)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, gotFile)
+		require.True(t, filesEqual, "File %s expected contents incorrect", gotFile)
+		utils.DeleteFileIfExists(gotFile)
+	}
+
+	// duplicate names are an error unless making them unique
+	options.UniqueNames = false
+	err := ParseSeqFileWithOptions(infile, outprefix, options)
+	require.ErrorIs(t, err, ErrDuplicateNames, "Should have got error from duplicate names")
+	utils.DeleteFileIfExists(outprefix + ".gff")
+
+	// no names change, so should be no names table
+	fastaFile := filepath.Join("seqfiles_testdata", "parseFasta.expect.fa")
+	options.NamePrefix = ""
+	require.NoError(t, ParseSeqFileWithOptions(fastaFile, outprefix, options), "Error importing file")
+	require.False(t, utils.FileExists(outprefix+".names.tsv"), "Names file should not exist")
+	utils.DeleteFileIfExists(outprefix + ".fa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+}
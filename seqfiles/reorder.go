@@ -0,0 +1,284 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// One match from the blast output file made by blast.RunBlast. The query
+// is from g1 and the reference is from g2. Coordinates are 1-based
+type blastHit struct {
+	qry      string
+	ref      string
+	qryStart int
+	qryEnd   int
+	refStart int
+	refEnd   int
+}
+
+func (h *blastHit) reverse() bool {
+	return h.refStart > h.refEnd
+}
+
+// Where a g1 contig goes relative to g2. Contigs with no blast matches
+// have ref = ""
+type contigPlacement struct {
+	name         string
+	length       int
+	ref          string
+	refStart     int
+	reverse      bool
+	alignedBases int
+}
+
+// Reads the blast output file made by blast.RunBlast. Only the names and
+// coordinates (the first 7 columns) are used
+func readBlastHits(filename string) ([]blastHit, error) {
+	hits := []blastHit{}
+	lineNumber := 0
+	err := forEachLine(filename, func(line string) error {
+		lineNumber++
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		if len(fields) < 7 {
+			return fmt.Errorf("%w: blast file %v, line %d: expected at least 7 columns", ErrBadFormat, filename, lineNumber)
+		}
+		hit := blastHit{qry: fields[0], ref: fields[1]}
+		for i, value := range []*int{&hit.qryStart, &hit.qryEnd, &hit.refStart, &hit.refEnd} {
+			var err error
+			*value, err = strconv.Atoi(fields[i+3])
+			if err != nil {
+				return fmt.Errorf("%w: blast file %v, line %d: column %d is not an integer", ErrBadFormat, filename, lineNumber, i+4)
+			}
+		}
+		hits = append(hits, hit)
+		return nil
+	})
+	return hits, err
+}
+
+// Returns where each g1 contig goes. A contig is placed on the g2
+// sequence that it has the most bases matching, in the orientation with
+// the most matching bases, at the position of its longest match. Placed
+// contigs are sorted by g2 sequence (in the order of refNames) and then
+// position. Contigs with no matches are put at the end, in their
+// original order
+func contigLayout(hits []blastHit, qryNames []string, qryLengths map[string]int, refNames []string) []contigPlacement {
+	refOrder := map[string]int{}
+	for i, name := range refNames {
+		refOrder[name] = i
+	}
+	type refAndStrand struct {
+		ref     string
+		reverse bool
+	}
+	alignedBases := map[string]map[refAndStrand]int{}
+	longest := map[string]map[refAndStrand]blastHit{}
+	for _, hit := range hits {
+		if alignedBases[hit.qry] == nil {
+			alignedBases[hit.qry] = map[refAndStrand]int{}
+			longest[hit.qry] = map[refAndStrand]blastHit{}
+		}
+		key := refAndStrand{hit.ref, hit.reverse()}
+		length := hit.qryEnd - hit.qryStart + 1
+		alignedBases[hit.qry][key] += length
+		if best, exists := longest[hit.qry][key]; !exists || length > best.qryEnd-best.qryStart+1 {
+			longest[hit.qry][key] = hit
+		}
+	}
+
+	placed := []contigPlacement{}
+	unplaced := []contigPlacement{}
+	for _, name := range qryNames {
+		placement := contigPlacement{name: name, length: qryLengths[name]}
+		refBases := map[string]int{}
+		for key, bases := range alignedBases[name] {
+			refBases[key.ref] += bases
+		}
+		for ref, bases := range refBases {
+			if bases > placement.alignedBases || (bases == placement.alignedBases && refOrder[ref] < refOrder[placement.ref]) {
+				placement.ref = ref
+				placement.alignedBases = bases
+			}
+		}
+		if placement.ref == "" {
+			unplaced = append(unplaced, placement)
+			continue
+		}
+		forward := alignedBases[name][refAndStrand{placement.ref, false}]
+		reverse := alignedBases[name][refAndStrand{placement.ref, true}]
+		placement.reverse = reverse > forward
+		hit := longest[name][refAndStrand{placement.ref, placement.reverse}]
+		placement.refStart = min(hit.refStart, hit.refEnd)
+		placed = append(placed, placement)
+	}
+	sort.SliceStable(placed, func(i, j int) bool {
+		if placed[i].ref != placed[j].ref {
+			return refOrder[placed[i].ref] < refOrder[placed[j].ref]
+		}
+		return placed[i].refStart < placed[j].refStart
+	})
+	return append(placed, unplaced...)
+}
+
+func writeContigLayout(layout []contigPlacement, outfile string) (err error) {
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	if _, err := fout.WriteString("name\tlength\tstrand\tref\tref_start\taligned_bases\n"); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", outfile, err)
+	}
+	for _, p := range layout {
+		strand := "+"
+		if p.reverse {
+			strand = "-"
+		}
+		line := fmt.Sprintf("%v\t%d\t%v\t.\t.\t0\n", p.name, p.length, strand)
+		if p.ref != "" {
+			line = fmt.Sprintf("%v\t%d\t%v\t%v\t%d\t%d\n", p.name, p.length, strand, p.ref, p.refStart, p.alignedBases)
+		}
+		if _, err := fout.WriteString(line); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", outfile, err)
+		}
+	}
+	return nil
+}
+
+// ReorderContigs puts the contigs of g1 in the order and orientation of
+// their best matches to g2. workingDir must have the files g1.fa, g2.fa
+// and the blast output file blast made by blast.RunBlast, and can have
+// g1.gff. Contigs that match the - strand of g2 are reverse complemented,
+// along with their annotation. g1.fa, g1.gff, g1.fa.fai and
+// g1.summary.tsv are rewritten, and the chosen layout is written to
+// reportFile. The blast file does not match the new g1.fa, so blast needs
+// running again
+func ReorderContigs(workingDir string, reportFile string) error {
+	fastaFile := filepath.Join(workingDir, "g1.fa")
+	annotFile := filepath.Join(workingDir, "g1.gff")
+	hits, err := readBlastHits(filepath.Join(workingDir, "blast"))
+	if err != nil {
+		return err
+	}
+	refNames, _, err := getSeqLengthsFromSingleLineFasta(filepath.Join(workingDir, "g2.fa"))
+	if err != nil {
+		return err
+	}
+	records, err := readSeqsAndAnnotation(fastaFile, annotFile)
+	if err != nil {
+		return err
+	}
+	qryNames := []string{}
+	qryLengths := map[string]int{}
+	recordsByName := map[string]*SeqRecord{}
+	for _, record := range records {
+		recordsByName[record.Name] = record
+		if record.Seq != "" {
+			qryNames = append(qryNames, record.Name)
+			qryLengths[record.Name] = len(record.Seq)
+		}
+	}
+
+	layout := contigLayout(hits, qryNames, qryLengths, refNames)
+	reordered := []*SeqRecord{}
+	newOrder := []string{}
+	reversed := 0
+	for _, placement := range layout {
+		record := recordsByName[placement.name]
+		if placement.reverse {
+			reversed++
+			reverseComplementRecord(record)
+		}
+		reordered = append(reordered, record)
+		newOrder = append(newOrder, record.Name)
+	}
+	for _, record := range records {
+		if record.Seq == "" {
+			reordered = append(reordered, record)
+		}
+	}
+	log.Printf("Placed %d of %d contigs on the reference, of which %d were reverse complemented", len(layout)-countUnplaced(layout), len(layout), reversed)
+
+	hadAnnotation := utils.FileExists(annotFile)
+	tmpFasta := fastaFile + ".tmp.reorder"
+	tmpAnnot := annotFile + ".tmp.reorder"
+	if err := writeSeqsAndAnnotation(reordered, tmpFasta, tmpAnnot); err != nil {
+		utils.DeleteFileIfExists(tmpFasta)
+		utils.DeleteFileIfExists(tmpAnnot)
+		return err
+	}
+	if err := utils.RenameFile(tmpFasta, fastaFile); err != nil {
+		return err
+	}
+	if hadAnnotation {
+		err = utils.RenameFile(tmpAnnot, annotFile)
+	} else {
+		err = utils.DeleteFileIfExists(tmpAnnot)
+	}
+	if err != nil {
+		return err
+	}
+	stats, err := getSeqStatsFromSingleLineFasta(fastaFile)
+	if err != nil {
+		return err
+	}
+	if err := writeFastaIndex(stats, fastaFile+".fai"); err != nil {
+		return err
+	}
+	if err := reorderSummaryFile(filepath.Join(workingDir, "g1.summary.tsv"), newOrder); err != nil {
+		return err
+	}
+	return writeContigLayout(layout, reportFile)
+}
+
+// Rewrites the lines of a summary TSV file made by writeSeqSummary in the
+// order of the given names. Does nothing if the file does not exist
+func reorderSummaryFile(summaryFile string, names []string) (err error) {
+	if !utils.FileExists(summaryFile) {
+		return nil
+	}
+	header := ""
+	lines := map[string]string{}
+	err = forEachLine(summaryFile, func(line string) error {
+		if header == "" {
+			header = line
+		} else {
+			name, _, _ := strings.Cut(line, "\t")
+			lines[name] = line
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fout, err := xopen.Wopen(summaryFile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", summaryFile, err)
+	}
+	defer closeWriter(fout, summaryFile, &err)
+	if _, err := fout.WriteString(header); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", summaryFile, err)
+	}
+	for _, name := range names {
+		if _, err := fout.WriteString(lines[name]); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", summaryFile, err)
+		}
+	}
+	return nil
+}
+
+func countUnplaced(layout []contigPlacement) int {
+	count := 0
+	for _, p := range layout {
+		if p.ref == "" {
+			count++
+		}
+	}
+	return count
+}
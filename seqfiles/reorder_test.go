@@ -0,0 +1,54 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestContigLayout(t *testing.T) {
+	hits := []blastHit{
+		{qry: "c1", ref: "ref2", qryStart: 1, qryEnd: 10, refStart: 5, refEnd: 14},
+		{qry: "c2", ref: "ref1", qryStart: 1, qryEnd: 5, refStart: 30, refEnd: 26},
+		{qry: "c2", ref: "ref1", qryStart: 6, qryEnd: 8, refStart: 50, refEnd: 52},
+		{qry: "c3", ref: "ref1", qryStart: 1, qryEnd: 4, refStart: 1, refEnd: 4},
+		{qry: "c3", ref: "ref2", qryStart: 1, qryEnd: 4, refStart: 1, refEnd: 4},
+	}
+	lengths := map[string]int{"c1": 10, "c2": 8, "c3": 4, "c4": 5}
+	got := contigLayout(hits, []string{"c4", "c1", "c2", "c3"}, lengths, []string{"ref1", "ref2"})
+	expect := []contigPlacement{
+		{name: "c3", length: 4, ref: "ref1", refStart: 1, alignedBases: 4},
+		{name: "c2", length: 8, ref: "ref1", refStart: 26, reverse: true, alignedBases: 8},
+		{name: "c1", length: 10, ref: "ref2", refStart: 5, alignedBases: 10},
+		{name: "c4", length: 5},
+	}
+	require.Equal(t, expect, got, "Wrong contig layout")
+}
+
+func TestReorderContigs(t *testing.T) {
+	workingDir := t.TempDir()
+	require.NoError(t, ParseSeqFile(filepath.Join("seqfiles_testdata", "reorder.g1.in.gff"), filepath.Join(workingDir, "g1")), "Error importing g1")
+	require.NoError(t, utils.CopyFile(filepath.Join("seqfiles_testdata", "reorder.g2.in.fa"), filepath.Join(workingDir, "g2.fa")), "Error copying g2")
+	require.NoError(t, utils.CopyFile(filepath.Join("seqfiles_testdata", "reorder.in.blast"), filepath.Join(workingDir, "blast")), "Error copying blast file")
+	reportFile := filepath.Join(workingDir, "report.tsv")
+	require.NoError(t, ReorderContigs(workingDir, reportFile), "Error reordering contigs")
+
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for expectSuffix, gotFile := range map[string]string{".fa": "g1.fa", ".gff": "g1.gff", ".summary.tsv": "g1.summary.tsv", ".tsv": "report.tsv"} {
+		expectFile := filepath.Join("seqfiles_testdata", "reorder.expect"+expectSuffix)
+		gotFile = filepath.Join(workingDir, gotFile)
+		filesEqual, err := cmp.CompareFile(expectFile, gotFile)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, gotFile)
+		require.True(t, filesEqual, "File %s expected contents incorrect", gotFile)
+	}
+
+	stats, err := getSeqStatsFromSingleLineFasta(filepath.Join(workingDir, "g1.fa"))
+	require.NoError(t, err, "Error getting stats of g1.fa")
+	index, err := readFastaIndex(filepath.Join(workingDir, "g1.fa.fai"))
+	require.NoError(t, err, "Error reading index of g1.fa")
+	for _, s := range stats {
+		require.Equal(t, s.offset, index[s.name].offset, "Wrong offset in index for %v", s.name)
+	}
+}
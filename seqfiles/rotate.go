@@ -0,0 +1,318 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Attributes that are searched when looking for a gene by name
+var geneNameAttributes = []string{"Name", "gene", "locus_tag", "ID"}
+
+// Returns the position of the start of a named gene on a sequence of the
+// given length, and its strand. For a gene on the - strand, the start is
+// the end coordinate. Genes that span the origin of a circular sequence
+// have two features with the same ID, one ending at the end of the
+// sequence and one starting at 1. Genes are searched for using their
+// Name, gene, locus_tag and ID attributes (not case sensitive). gene
+// features are used in preference to any other type
+func findGeneStart(features []Feature, geneName string, length int) (int, string, error) {
+	var found *Feature
+	for i := range features {
+		f := &features[i]
+		if found != nil && (found.Type == "gene" || f.Type != "gene") {
+			continue
+		}
+		for _, key := range geneNameAttributes {
+			if strings.EqualFold(f.GetAttribute(key), geneName) {
+				found = f
+				break
+			}
+		}
+	}
+	if found == nil {
+		return 0, "", fmt.Errorf("%w: gene %v not found", ErrBadRegion, geneName)
+	}
+
+	segments := []Feature{*found}
+	if id := found.GetAttribute("ID"); id != "" {
+		segments = []Feature{}
+		for _, f := range features {
+			if f.Type == found.Type && f.GetAttribute("ID") == id {
+				segments = append(segments, f)
+			}
+		}
+	}
+	start := segments[0].Start
+	end := segments[0].End
+	for _, s := range segments[1:] {
+		start = min(start, s.Start)
+		end = max(end, s.End)
+	}
+	for _, s := range segments {
+		if s.End == length && start == 1 {
+			// gene spans the origin. Its start is the start of the part at
+			// the end of the sequence, and its end is the end of the part
+			// at the start of the sequence
+			start = s.Start
+		} else if s.Start == 1 && end == length {
+			end = s.End
+		}
+	}
+	if found.Strand == "-" {
+		return end, "-", nil
+	}
+	return start, "+", nil
+}
+
+// Returns the position in g1 sequence seqName (which has the given length)
+// that matches the start of the g2 sequence in the longest match in a
+// blast file made by blast.RunBlast. reverse is true if the match is to
+// the - strand, in which case the position is after reverse complementing
+// seqName
+func findBlastStart(blastFile string, seqName string, length int) (int, bool, error) {
+	hits, err := readBlastHits(blastFile)
+	if err != nil {
+		return 0, false, err
+	}
+	var best *blastHit
+	for i := range hits {
+		if hits[i].qry == seqName && (best == nil || hits[i].qryEnd-hits[i].qryStart > best.qryEnd-best.qryStart) {
+			best = &hits[i]
+		}
+	}
+	if best == nil {
+		return 0, false, fmt.Errorf("no matches for sequence %v in blast file %v", seqName, blastFile)
+	}
+	if best.reverse() {
+		qryStart := length - best.qryEnd + 1
+		return ((qryStart-best.refEnd)%length+length)%length + 1, true, nil
+	}
+	return ((best.qryStart-best.refStart)%length+length)%length + 1, false, nil
+}
+
+// Returns the features of a circular sequence of the given length, after
+// rotating it so that newStart becomes position 1. Features that cross
+// the new origin are split in two, keeping the same ID. Features that
+// were split in two because they crossed the old origin are joined back
+// together. Features that cover the whole sequence are unchanged
+func rotateFeatures(features []Feature, length int, newStart int) []Feature {
+	newPosition := func(position int) int {
+		return ((position-newStart)%length+length)%length + 1
+	}
+	rotated := []Feature{}
+	for _, f := range features {
+		if (f.Start == 1 && f.End == length) || f.End < newStart || newStart <= f.Start {
+			if f.Start != 1 || f.End != length {
+				f.Start = newPosition(f.Start)
+				f.End = newPosition(f.End)
+			}
+			rotated = append(rotated, f)
+			continue
+		}
+		before := f
+		before.End = newStart - 1
+		after := f
+		after.Start = newStart
+		if f.Strand == "-" {
+			before.Phase = clippedPhase(f.Phase, f.End-before.End)
+		} else {
+			after.Phase = clippedPhase(f.Phase, after.Start-f.Start)
+		}
+		before.Start = newPosition(before.Start)
+		before.End = newPosition(before.End)
+		after.Start = newPosition(after.Start)
+		after.End = newPosition(after.End)
+		// the parts are kept in the order they are joined
+		if f.Strand == "-" {
+			rotated = append(rotated, after, before)
+		} else {
+			rotated = append(rotated, before, after)
+		}
+	}
+	return joinOriginSplitFeatures(rotated)
+}
+
+// Sorts features by start position in the same way as sortFeatures,
+// except that the parts of a feature (features with the same ID) are kept
+// in the order they are joined. Each part goes in the place of one of the
+// parts in the sorted order, so that the first part of a feature that
+// crosses the origin stays before the part that starts at position 1
+func sortFeaturesKeepingJoins(features []Feature) {
+	parts := map[string][]Feature{}
+	for _, f := range features {
+		if id := f.GetAttribute("ID"); id != "" {
+			parts[id] = append(parts[id], f)
+		}
+	}
+	sortFeatures(features)
+	used := map[string]int{}
+	for i := range features {
+		id := features[i].GetAttribute("ID")
+		if len(parts[id]) > 1 {
+			features[i] = parts[id][used[id]]
+			used[id]++
+		}
+	}
+}
+
+// Joins pairs of features that have the same ID, type and strand, where
+// one ends at position p and the other starts at p+1. These are features
+// that spanned the origin before rotating
+func joinOriginSplitFeatures(features []Feature) []Feature {
+	type featureKey struct {
+		id       string
+		featType string
+		strand   string
+		start    int
+	}
+	byStart := map[featureKey]int{}
+	for i, f := range features {
+		if id := f.GetAttribute("ID"); id != "" {
+			byStart[featureKey{id, f.Type, f.Strand, f.Start}] = i
+		}
+	}
+	// index of a feature -> index of the feature that is joined on to
+	// its end
+	joinedTo := map[int]int{}
+	removed := map[int]bool{}
+	for i, f := range features {
+		id := f.GetAttribute("ID")
+		if j, exists := byStart[featureKey{id, f.Type, f.Strand, f.End + 1}]; exists && id != "" && j != i && !removed[i] && !removed[j] {
+			joinedTo[i] = j
+			removed[j] = true
+		}
+	}
+	joined := []Feature{}
+	for i, f := range features {
+		if removed[i] {
+			continue
+		}
+		if j, exists := joinedTo[i]; exists {
+			next := features[j]
+			if f.Strand == "-" {
+				f.Phase = next.Phase
+				f.Attributes = next.Attributes
+			}
+			f.End = next.End
+		}
+		joined = append(joined, f)
+	}
+	return joined
+}
+
+// RotateSequence rotates a circular sequence of an imported genome, which
+// has files prefix.fa and optionally prefix.gff, so that it starts at a
+// new position. The new start is one of: a position (if start > 0), the
+// start of a gene (if geneName is not ""), or the position that best
+// matches the start of a g2 sequence in a blast file made by
+// blast.RunBlast (if blastFile is not ""). When using a gene or blast
+// match on the - strand, the sequence is also reverse complemented.
+// prefix.fa, prefix.gff and the feature counts in prefix.summary.tsv are
+// updated, and the proteins in prefix.faa are made again
+func RotateSequence(prefix string, seqName string, start int, geneName string, blastFile string) error {
+	fastaFile := prefix + ".fa"
+	annotFile := prefix + ".gff"
+	records, err := readSeqsAndAnnotation(fastaFile, annotFile)
+	if err != nil {
+		return err
+	}
+	var record *SeqRecord
+	for _, r := range records {
+		if r.Name == seqName && r.Seq != "" {
+			record = r
+		}
+	}
+	if record == nil {
+		return fmt.Errorf("%w: sequence %v not found in %v", ErrBadRegion, seqName, fastaFile)
+	}
+	length := len(record.Seq)
+	if !record.Circular {
+		log.Printf("Warning: sequence %v is not marked as circular, but rotating it anyway", seqName)
+	}
+
+	reverse := false
+	switch {
+	case geneName != "":
+		var strand string
+		start, strand, err = findGeneStart(record.Features, geneName, length)
+		if err != nil {
+			return fmt.Errorf("%w on sequence %v", err, seqName)
+		}
+		if strand == "-" {
+			reverse = true
+			start = length - start + 1
+		}
+	case blastFile != "":
+		start, reverse, err = findBlastStart(blastFile, seqName, length)
+		if err != nil {
+			return err
+		}
+	case start < 1 || start > length:
+		return fmt.Errorf("%w: new start %d is not in sequence %v (length %d)", ErrBadRegion, start, seqName, length)
+	}
+
+	oldFeatureCount := len(record.Features)
+	if reverse {
+		reverseComplementRecord(record)
+	}
+	record.Seq = record.Seq[start-1:] + record.Seq[:start-1]
+	record.Features = rotateFeatures(record.Features, length, start)
+	sortFeaturesKeepingJoins(record.Features)
+	log.Printf("Rotated sequence %v to start at position %d (reverse complemented: %v)", seqName, start, reverse)
+
+	hadAnnotation := utils.FileExists(annotFile)
+	tmpFasta := fastaFile + ".tmp.rotate"
+	tmpAnnot := annotFile + ".tmp.rotate"
+	if err := writeSeqsAndAnnotation(records, tmpFasta, tmpAnnot); err != nil {
+		utils.DeleteFileIfExists(tmpFasta)
+		utils.DeleteFileIfExists(tmpAnnot)
+		return err
+	}
+	if err := utils.RenameFile(tmpFasta, fastaFile); err != nil {
+		return err
+	}
+	if hadAnnotation {
+		err = utils.RenameFile(tmpAnnot, annotFile)
+	} else {
+		err = utils.DeleteFileIfExists(tmpAnnot)
+	}
+	if err != nil {
+		return err
+	}
+	// the protein headers have the CDS coordinates, so the proteins are
+	// remade with the genetic code they were made with
+	proteinsFile := prefix + ".faa"
+	if utils.FileExists(proteinsFile) {
+		code, err := proteinsDefaultGeneticCode(proteinsFile, records)
+		if err != nil {
+			return err
+		}
+		if err := writeProteins(fastaFile, annotFile, proteinsFile, code); err != nil {
+			return err
+		}
+	}
+	return addToSummaryFeatureCount(prefix+".summary.tsv", seqName, len(record.Features)-oldFeatureCount)
+}
+
+// Adds to the number of features of one sequence in a summary TSV file
+// made by writeSeqSummary. Does nothing if the file does not exist
+func addToSummaryFeatureCount(summaryFile string, seqName string, toAdd int) error {
+	if toAdd == 0 || !utils.FileExists(summaryFile) {
+		return nil
+	}
+	return rewriteFileLines(summaryFile, func(line string) (string, error) {
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		if fields[0] != seqName || len(fields) < 6 {
+			return line, nil
+		}
+		count, err := strconv.Atoi(fields[5])
+		if err != nil {
+			return "", fmt.Errorf("%w: summary file %v: number of features for %v is not an integer", ErrBadFormat, summaryFile, seqName)
+		}
+		fields[5] = strconv.Itoa(count + toAdd)
+		return strings.Join(fields, "\t") + "\n", nil
+	})
+}
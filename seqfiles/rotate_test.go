@@ -0,0 +1,113 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateFeatures(t *testing.T) {
+	features := []Feature{
+		{SeqID: "s", Type: "CDS", Start: 5, End: 13, Strand: "+", Phase: "0", Attributes: []Attribute{{Key: "ID", Values: []string{"cds1"}}}},
+		{SeqID: "s", Type: "CDS", Start: 5, End: 13, Strand: "-", Phase: "0", Attributes: []Attribute{{Key: "ID", Values: []string{"cds2"}}}},
+		{SeqID: "s", Type: "CDS", Start: 18, End: 20, Strand: "+", Phase: "0", Attributes: []Attribute{{Key: "ID", Values: []string{"cds3"}}}},
+		{SeqID: "s", Type: "CDS", Start: 1, End: 2, Strand: "+", Phase: "0", Attributes: []Attribute{{Key: "ID", Values: []string{"cds3"}}}},
+		{SeqID: "s", Type: "region", Start: 1, End: 20, Strand: "+", Phase: "."},
+	}
+	got := rotateFeatures(features, 20, 10)
+	require.Equal(t, 6, len(got), "Wrong number of features after rotating")
+	expect := []struct {
+		start int
+		end   int
+		phase string
+	}{{16, 20, "0"}, {1, 4, "1"}, {1, 4, "0"}, {16, 20, "2"}, {9, 13, "0"}, {1, 20, "."}}
+	for i, e := range expect {
+		require.Equal(t, e.start, got[i].Start, "Wrong start of feature %d", i)
+		require.Equal(t, e.end, got[i].End, "Wrong end of feature %d", i)
+		require.Equal(t, e.phase, got[i].Phase, "Wrong phase of feature %d", i)
+	}
+
+	// the parts of each feature stay in the order they are joined
+	sortFeaturesKeepingJoins(got)
+	expect = []struct {
+		start int
+		end   int
+		phase string
+	}{{16, 20, "0"}, {1, 4, "0"}, {1, 20, "."}, {9, 13, "0"}, {1, 4, "1"}, {16, 20, "2"}}
+	for i, e := range expect {
+		require.Equal(t, e.start, got[i].Start, "Wrong start of feature %d after sorting", i)
+		require.Equal(t, e.end, got[i].End, "Wrong end of feature %d after sorting", i)
+		require.Equal(t, e.phase, got[i].Phase, "Wrong phase of feature %d after sorting", i)
+	}
+}
+
+func TestFindGeneStart(t *testing.T) {
+	features := []Feature{
+		{Type: "CDS", Start: 5, End: 10, Strand: "+", Attributes: []Attribute{{Key: "gene", Values: []string{"dnaA"}}}},
+		{Type: "gene", Start: 4, End: 10, Strand: "+", Attributes: []Attribute{{Key: "ID", Values: []string{"g1"}}, {Key: "Name", Values: []string{"dnaA"}}}},
+		{Type: "gene", Start: 25, End: 30, Strand: "-", Attributes: []Attribute{{Key: "ID", Values: []string{"g2"}}}},
+		{Type: "gene", Start: 1, End: 2, Strand: "-", Attributes: []Attribute{{Key: "ID", Values: []string{"g2"}}}},
+	}
+	tests := []struct {
+		name   string
+		start  int
+		strand string
+	}{{"DNAA", 4, "+"}, {"g2", 2, "-"}}
+	for _, test := range tests {
+		start, strand, err := findGeneStart(features, test.name, 30)
+		require.NoError(t, err, "Error finding gene %v", test.name)
+		require.Equal(t, test.start, start, "Wrong start of gene %v", test.name)
+		require.Equal(t, test.strand, strand, "Wrong strand of gene %v", test.name)
+	}
+	_, _, err := findGeneStart(features, "notagene", 30)
+	require.ErrorIs(t, err, ErrBadRegion, "Should not find gene")
+}
+
+func TestRotateSequence(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "rotate.in.gbk")
+	outprefix := "tmp.test.rotateSequence"
+	tests := []struct {
+		start int
+		gene  string
+		name  string
+	}{{12, "", "start12"}, {8, "", "start8"}, {17, "", "start17"}, {0, "repa", "gene"}}
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, test := range tests {
+		require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
+		require.NoError(t, RotateSequence(outprefix, "plasmid1", test.start, test.gene, ""), "Error rotating sequence (%v)", test.name)
+		for _, suffix := range []string{".fa", ".gff", ".faa"} {
+			expectFile := filepath.Join("seqfiles_testdata", "rotate.expect."+test.name+suffix)
+			filesEqual, err := cmp.CompareFile(expectFile, outprefix+suffix)
+			require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+suffix)
+			require.True(t, filesEqual, "File %s expected contents incorrect (%v)", outprefix+suffix, test.name)
+		}
+	}
+
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
+	blastFile := outprefix + ".blast"
+	require.NoError(t, os.WriteFile(blastFile, []byte("plasmid1\tref\t100.0\t11\t30\t1\t20\t[]\n"), 0644), "Error writing file %v", blastFile)
+	require.NoError(t, RotateSequence(outprefix, "plasmid1", 0, "", blastFile), "Error rotating sequence using blast file")
+	expectFile := filepath.Join("seqfiles_testdata", "rotate.expect.start12.fa")
+	filesEqual, err := cmp.CompareFile(expectFile, outprefix+".fa")
+	require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+".fa")
+	require.False(t, filesEqual, "Rotating using blast file should start at 11, not 12")
+	records, err := readAllSeqRecords(outprefix+".fa", FASTA)
+	require.NoError(t, err, "Error reading file %v", outprefix+".fa")
+	require.Equal(t, "CCAGGGTTTTAAAAAATGACCGTAAATTTC", records[0].Seq, "Wrong sequence after rotating using blast file")
+
+	require.NoError(t, os.WriteFile(blastFile, []byte("plasmid1\tref\t100.0\t1\t10\t30\t21\t[]\n"), 0644), "Error writing file %v", blastFile)
+	start, reverse, err := findBlastStart(blastFile, "plasmid1", 30)
+	require.NoError(t, err, "Error getting start from blast file")
+	require.Equal(t, 1, start, "Wrong start from reverse blast match")
+	require.True(t, reverse, "Blast match should be reverse")
+
+	require.ErrorIs(t, RotateSequence(outprefix, "notaseq", 5, "", ""), ErrBadRegion, "Should fail with unknown sequence")
+	require.ErrorIs(t, RotateSequence(outprefix, "plasmid1", 31, "", ""), ErrBadRegion, "Should fail with start past end of sequence")
+
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".metadata.json", ".faa", ".blast"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
@@ -1,13 +1,11 @@
 package seqfiles
 
 import (
+	"errors"
 	"fmt"
 	"github.com/martinghunt/tnahelper/utils"
-	"github.com/shenwei356/xopen"
-	"io"
 	"log"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -21,162 +19,65 @@ const (
 	GFF3
 	GENBANK
 	EMBL
+	GTF
+	BED
 )
 
-type Gap struct {
-	SeqName string
-	Start   int
-	End     int
-}
-
-func GetFileType(filename string) FileFormat {
-	reader, err := xopen.Ropen(filename)
-	if err != nil {
-		log.Fatalf("Error opening file %v: %v", filename, err)
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", filename, err)
-		}
-	}()
-
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		log.Fatalf("Error reading fiest line of file %v: %v", filename, err)
-	}
-
-	if strings.HasPrefix(line, ">") {
-		return FASTA
-	} else if strings.HasPrefix(line, "@") {
-		return FASTQ
-	} else if strings.HasPrefix(line, "##gff-version") {
-		return GFF3
-	} else if strings.HasPrefix(line, "LOCUS ") {
-		return GENBANK
-	} else if strings.HasPrefix(line, "ID ") {
-		return EMBL
-	}
-	return Unknown
-}
-
-func parseFastaFile(infile string, outfile string) {
-	reader, err := xopen.Ropen(infile)
-	if err != nil {
-		log.Fatalf("Error opening file %v: %v", infile, err)
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", infile, err)
-		}
-	}()
-	fout, errOut := xopen.Wopen(outfile)
-	if errOut != nil {
-		log.Fatalf("Error opening file for writing %v: %v", outfile, errOut)
-	}
-	defer fout.Close()
-	first := true
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				if len(line) > 0 { // ie last line of file has no newline
-					fout.WriteString(strings.ToUpper(strings.TrimSpace(line)))
-				}
-				break
-			}
-
-			log.Fatalf("read file line error: %v", err)
-			return
-		}
+var (
+	// ErrUnknownFormat is returned when the format of a file cannot be
+	// determined, or is the wrong kind of file for what it is used for
+	ErrUnknownFormat = errors.New("unknown file format")
+	// ErrBadFormat is returned when the contents of a file cannot be parsed
+	ErrBadFormat = errors.New("bad file format")
+	// ErrDuplicateNames is returned when sequence names are not unique
+	ErrDuplicateNames = errors.New("duplicated sequence names")
+	// ErrAnnotationMismatch is returned when annotation is on sequences
+	// that do not exist or goes past the end of a sequence, and the
+	// policy is AnnotFail
+	ErrAnnotationMismatch = errors.New("annotation does not match sequences")
+	// ErrBadRegion is returned when a region to extract is not on one of
+	// the sequences
+	ErrBadRegion = errors.New("bad region")
+)
 
-		if strings.HasPrefix(line, ">") {
-			if first {
-				first = false
-			} else {
-				fout.WriteString("\n")
-			}
-			fout.WriteString(strings.Fields(line)[0] + "\n")
-		} else {
-			fout.WriteString(strings.ToUpper(strings.TrimSpace(line)))
-		}
-	}
-	fout.WriteString("\n")
-	fout.Flush()
+// GetFileType returns the format of a file, detected from its contents
+// by SniffFileType. Returns Unknown if the format could not be
+// determined. An error is only returned if the file could not be read
+func GetFileType(filename string) (FileFormat, error) {
+	result, err := SniffFileType(filename)
+	return result.Format, err
 }
 
-func parseFastqFile(infile string, outfile string) {
-	reader, err := xopen.Ropen(infile)
-	if err != nil {
-		log.Fatalf("Error opening file %v: %v", infile, err)
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", infile, err)
-		}
-	}()
-	fout, errOut := xopen.Wopen(outfile)
-	if errOut != nil {
-		log.Fatalf("Error opening file for writing %v: %v", outfile, errOut)
-	}
-	defer fout.Close()
-	oneRead := [4]string{}
-	lastRead := false
-
-	for {
-		for i := 0; i < 4; i++ {
-			oneRead[i], err = reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					lastRead = true
-					break
-				}
-
-				log.Fatalf("read file line error: %v", err)
-				return
-			}
-		}
-		if lastRead {
-			break
-		}
-
-		if !(strings.HasPrefix(oneRead[0], "@") && strings.HasPrefix(oneRead[2], "+")) {
-			log.Fatalf("Error getting sequence from file %v, around here: %v%v", infile, oneRead[0], oneRead[1])
-		}
-
-		fout.WriteString(">")
-		fout.WriteString(oneRead[0][1:])
-		fout.WriteString(strings.ToUpper(oneRead[1]))
-	}
-	fout.Flush()
+func parseFastaFile(infile string, outfile string, options ImportOptions) error {
+	return convertFastaFile(infile, outfile, options.keepCase())
 }
 
-func seqnameFromLineGenbankOrEMBL(line string, fformat FileFormat) string {
-	if fformat == GENBANK && strings.HasPrefix(line, "LOCUS ") {
+// Returns the sequence name from the LOCUS line of a genbank file, or the
+// ID line of an EMBL file. Returns "" if the line is not one of those
+func seqnameFromLineGenbankOrEMBL(line string, fformat FileFormat) (string, error) {
+	if fformat == GENBANK && hasKeyword(line, "LOCUS") {
 		fields := strings.Fields(strings.TrimRight(line, "\n"))
 		if len(fields) < 2 {
-			log.Fatalf("Error getting sequence name from LOCUS line of genbank file: %v", line)
+			return "", fmt.Errorf("%w: error getting sequence name from LOCUS line of genbank file: %v", ErrBadFormat, line)
 		}
-		return fields[1]
-	} else if fformat == EMBL && strings.HasPrefix(line, "ID ") {
+		return fields[1], nil
+	} else if fformat == EMBL && hasKeyword(line, "ID") {
 		fields := strings.Fields(line)
 		if len(fields) < 2 {
-			log.Fatalf("Error getting sequence name from LOCUS line of EMBL file: %v", line)
+			return "", fmt.Errorf("%w: error getting sequence name from ID line of EMBL file: %v", ErrBadFormat, line)
 		}
-		return strings.TrimRight(fields[1], ";")
+		return strings.TrimRight(fields[1], ";"), nil
 	}
-	return ""
+	return "", nil
 }
 
 func endGenbankOrEmblHeader(line string, fformat FileFormat) bool {
 	if fformat == GENBANK {
 		return strings.HasPrefix(line, "FEATURES")
 	} else if fformat == EMBL {
-		return line == "FH\n"
-	} else {
-		log.Fatalf("Unknown file format: %v", fformat)
+		return strings.TrimRight(line, "\r\n") == "FH"
 	}
-	panic("Unexpectedly reached an invalid state in endGenbankOrEmblHeader")
+	panic(fmt.Sprintf("Unexpectedly reached an invalid state in endGenbankOrEmblHeader. Unknown file format: %v", fformat))
 }
 
 func lineMarksGebnkaOrEmblSequenceStart(line string, fformat FileFormat) bool {
@@ -184,298 +85,516 @@ func lineMarksGebnkaOrEmblSequenceStart(line string, fformat FileFormat) bool {
 		return strings.HasPrefix(line, "ORIGIN")
 	} else if fformat == EMBL {
 		return strings.HasPrefix(line, "SQ   ")
-	} else {
-		log.Fatalf("Uknown file format: %v", fformat)
 	}
-	panic("Unexpectedly reached an invalid state in lineMarksGebnkaOrEmblSequenceStart")
+	panic(fmt.Sprintf("Unexpectedly reached an invalid state in lineMarksGebnkaOrEmblSequenceStart. Unknown file format: %v", fformat))
 }
 
-func parseGenbankOrEmblFile(infile string, outfileSeqs string, outfileAnnot string, fformat FileFormat) {
-	reader, err := xopen.Ropen(infile)
-	if err != nil {
-		log.Fatalf("Error opening file %v: %v", infile, err)
+// Feature keys whose GFF rows get a Parent attribute pointing at the gene
+// with the same /locus_tag or /gene qualifier
+var featureKeysWithGeneParent = map[string]bool{
+	"CDS":  true,
+	"mRNA": true,
+	"exon": true,
+}
+
+type genbankQualifier struct {
+	name  string
+	value string
+}
+
+type genbankFeature struct {
+	key        string
+	location   string
+	qualifiers []genbankQualifier
+}
+
+func (q *genbankQualifier) hasOpenQuote() bool {
+	return strings.HasPrefix(q.value, "\"") && strings.Count(q.value, "\"")%2 == 1
+}
+
+// Returns the value of the qualifier with quotes removed. Doubled quotes
+// inside the value become a single quote. Translations that were wrapped
+// over more than one line get their whitespace removed
+func (q *genbankQualifier) decodedValue() string {
+	value := q.value
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		value = strings.ReplaceAll(value[1:len(value)-1], "\"\"", "\"")
+	}
+	if q.name == "translation" {
+		value = strings.Join(strings.Fields(value), "")
 	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", infile, err)
+	return value
+}
+
+func (f *genbankFeature) qualifierValues(name string) []string {
+	values := []string{}
+	for _, q := range f.qualifiers {
+		if q.name == name {
+			values = append(values, q.decodedValue())
 		}
-	}()
-	foutSeqs, errOut := xopen.Wopen(outfileSeqs)
-	if errOut != nil {
-		log.Fatalf("Error opening sequence file for writing %v: %v", outfileSeqs, errOut)
-	}
-	foutAnnot, errOut := xopen.Wopen(outfileAnnot)
-	if errOut != nil {
-		log.Fatalf("Error opening annotation file for writing %v: %v", outfileAnnot, errOut)
-	}
-	defer foutSeqs.Close()
-	defer foutAnnot.Close()
-	inGene := false
-	inSeq := false
-	inFeatures := false
-	inHeader := false
-	geneStart := 0
-	geneEnd := 0
-	geneStrand := ""
-	geneName := ""
-	seqReplaceRe := regexp.MustCompile(`[\s0-9]`)
-	genePrefix := "                     /gene="
-	locustagPrefix := "                     /locus_tag"
-	currentContig := "UNKNOWN"
-	foutAnnot.WriteString("##gff-version 3\n")
-	nonNumberRe := regexp.MustCompile(`\D+`)
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+	}
+	return values
+}
 
-			log.Fatalf("read file line error: %v", err)
-			return
+// Returns the qualifiers of the feature as GFF3 attributes, in the order
+// they first appear. Qualifiers that occur more than once are combined
+// into one attribute with more than one value. Qualifiers without a
+// value, eg /pseudo, get the value "true"
+func (f *genbankFeature) gff3Attributes() []Attribute {
+	attributes := []Attribute{}
+	indexes := map[string]int{}
+	for _, q := range f.qualifiers {
+		value := "true"
+		if q.value != "" {
+			value = q.decodedValue()
 		}
+		if i, exists := indexes[q.name]; exists {
+			attributes[i].Values = append(attributes[i].Values, value)
+		} else {
+			indexes[q.name] = len(attributes)
+			attributes = append(attributes, Attribute{Key: gff3AttributeNameFromQualifier(q.name), Values: []string{value}})
+		}
+	}
+	return attributes
+}
 
-		if line == "//\n" {
-			foutSeqs.WriteString("\n")
-			inSeq = false
-			inGene = false
-		} else if inSeq {
-			foutSeqs.WriteString(strings.ToUpper(seqReplaceRe.ReplaceAllString(line, "")))
-		} else if inHeader {
-			if endGenbankOrEmblHeader(line, fformat) {
-				inHeader = false
-				inFeatures = true
-			}
-		} else if inFeatures {
-			// Genbank and EMBL feature lines are the same, except that EMBL
-			// startswith "FT", whereas Genbank is spaces
-			if fformat == EMBL && strings.HasPrefix(line, "FT ") {
-				line = strings.Replace(line, "FT", "  ", 1)
-			}
+// GFF3 reserves attribute names that start with an uppercase letter, so
+// qualifiers like /EC_number have their first letter lowercased (giving
+// eC_number, which is the same as Prokka does)
+func gff3AttributeNameFromQualifier(name string) string {
+	if len(name) > 0 && name[0] >= 'A' && name[0] <= 'Z' {
+		return strings.ToLower(name[:1]) + name[1:]
+	}
+	return name
+}
 
-			if lineMarksGebnkaOrEmblSequenceStart(line, fformat) {
-				inFeatures = false
-				if inGene {
-					foutAnnot.WriteString(fmt.Sprintf("%v\t.\tgene\t%v\t%v\t.\t%v\t.\tID=%v\n", currentContig, geneStart, geneEnd, geneStrand, geneName))
-					geneName = ""
-					geneStart = 0
-					geneEnd = 0
-					geneStrand = "."
-					inGene = false
-				}
-				inSeq = true
-			}
+// Returns the /gene and /locus_tag values of the feature joined with "/",
+// in the order they appear in the file
+func (f *genbankFeature) geneName() string {
+	names := []string{}
+	for _, q := range f.qualifiers {
+		if q.name == "gene" || q.name == "locus_tag" {
+			names = append(names, strings.TrimSpace(q.decodedValue()))
+		}
+	}
+	return strings.Join(names, "/")
+}
 
-			if inGene {
-				if strings.HasPrefix(line, genePrefix) || strings.HasPrefix(line, locustagPrefix) {
-					fields := strings.Split(line, "=")
-					if geneName == "UNKNOWN" {
-						geneName = ""
-					} else if len(geneName) > 0 {
-						geneName += "/"
-					}
-					geneName += strings.TrimSpace(strings.ReplaceAll(fields[1], "\"", ""))
-				} else if line[5] != ' ' {
-					foutAnnot.WriteString(fmt.Sprintf("%v\t.\tgene\t%v\t%v\t.\t%v\t.\tID=%v\n", currentContig, geneStart, geneEnd, geneStrand, geneName))
-					inGene = false
-					geneName = ""
-					geneStart = 0
-					geneEnd = 0
-					geneStrand = "."
-				}
+// Adds one line from the features section of a genbank or EMBL file. EMBL
+// lines must already have had the leading "FT" replaced with spaces.
+// Returns the updated list of features
+func addGenbankOrEmblFeatureLine(features []genbankFeature, line string) []genbankFeature {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > 5 && line[5] != ' ' {
+		fields := strings.Fields(line)
+		location := ""
+		if len(fields) > 1 {
+			location = fields[1]
+		}
+		return append(features, genbankFeature{key: fields[0], location: location})
+	}
+	if len(features) == 0 {
+		return features
+	}
 
-			}
+	feature := &features[len(features)-1]
+	text := strings.TrimSpace(line)
+	if len(feature.qualifiers) > 0 && feature.qualifiers[len(feature.qualifiers)-1].hasOpenQuote() {
+		q := &feature.qualifiers[len(feature.qualifiers)-1]
+		q.value += " " + text
+	} else if strings.HasPrefix(text, "/") {
+		name, value, _ := strings.Cut(text[1:], "=")
+		feature.qualifiers = append(feature.qualifiers, genbankQualifier{name: name, value: value})
+	} else if len(feature.qualifiers) == 0 {
+		feature.location += text
+	} else {
+		q := &feature.qualifiers[len(feature.qualifiers)-1]
+		q.value += " " + text
+	}
+	return features
+}
 
-			if strings.HasPrefix(line, "     gene") {
-				inGene = true
-				fields := strings.Fields(line)
-				if strings.HasPrefix(fields[1], "complement") {
-					geneStrand = "-"
-				} else {
-					geneStrand = "+"
-				}
+// Returns base if it is not in usedIDs, otherwise base with the
+// smallest "_N" suffix that is not used. Adds the returned ID to usedIDs
+func uniqueID(base string, usedIDs map[string]bool) string {
+	id := base
+	for i := 2; usedIDs[id]; i++ {
+		id = fmt.Sprintf("%v_%d", base, i)
+	}
+	usedIDs[id] = true
+	return id
+}
 
-				coords := nonNumberRe.Split(fields[1], -1)
-				geneStart = -1
-				geneEnd = -1
-				for _, s := range coords {
-					if s == "" {
-						continue
-					}
-					c, _ := strconv.Atoi(s)
-					if geneStart == -1 || c < geneStart {
-						geneStart = c
-					}
-					if geneEnd == -1 || c > geneEnd {
-						geneEnd = c
+// Converts the features of one genbank or EMBL record to GFF3 features.
+// Features get a unique ID, made from their gene and locus_tag, and CDS,
+// mRNA and exon features get a Parent gene where possible. Features with
+// a location made of more than one part get one GFF3 feature per part
+func genbankFeaturesToGFF3(contig string, circular bool, features []genbankFeature, usedIDs map[string]bool) []Feature {
+	ids := make([]string, len(features))
+	geneIDs := map[string]string{}
+	for i, f := range features {
+		name := f.geneName()
+		if f.key == "gene" {
+			if name == "" {
+				name = contig + ".gene"
+			}
+			ids[i] = uniqueID(name, usedIDs)
+			for _, qname := range []string{"gene", "locus_tag"} {
+				for _, v := range f.qualifierValues(qname) {
+					if _, exists := geneIDs[qname+"="+v]; !exists {
+						geneIDs[qname+"="+v] = ids[i]
 					}
 				}
-				geneName = "UNKNOWN"
 			}
-			continue
+		} else if name == "" {
+			ids[i] = uniqueID(contig+"."+f.key, usedIDs)
 		} else {
-			seqname := seqnameFromLineGenbankOrEMBL(line, fformat)
-			if seqname == "" {
-				continue
-			}
-			foutSeqs.WriteString(">")
-			currentContig = seqname
-			foutSeqs.WriteString(currentContig)
-			foutSeqs.WriteString("\n")
-			inHeader = true
+			ids[i] = uniqueID(name+"."+f.key, usedIDs)
 		}
 	}
-	foutSeqs.Flush()
-	foutAnnot.Flush()
-}
 
-func parseGFF3File(infile string, outfileSeqs string, outfileAnnot string) {
-	reader, err := xopen.Ropen(infile)
-	if err != nil {
-		log.Fatalf("Error opening file %v: %v", infile, err)
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", infile, err)
-		}
-	}()
-	foutSeqs, errOut := xopen.Wopen(outfileSeqs)
-	if errOut != nil {
-		log.Fatalf("Error opening sequence file for writing %v: %v", outfileSeqs, errOut)
-	}
-	foutAnnot, errOut := xopen.Wopen(outfileAnnot)
-	if errOut != nil {
-		log.Fatalf("Error opening annotation file for writing %v: %v", outfileAnnot, errOut)
-	}
-	defer foutSeqs.Close()
-	defer foutAnnot.Close()
-	firstSeq := true
-	inFasta := false
-	foutAnnot.WriteString("##gff-version 3\n")
-
-	for {
-		line, err := reader.ReadString('\n')
+	gffFeatures := []Feature{}
+	for i, f := range features {
+		location, err := parseLocation(f.location)
 		if err != nil {
-			if err == io.EOF {
-				break
+			log.Printf("Warning: skipping %v feature on %v. Error parsing location %v: %v", f.key, contig, f.location, err)
+			continue
+		}
+		attributes := []Attribute{{Key: "ID", Values: []string{ids[i]}}}
+		if featureKeysWithGeneParent[f.key] {
+		parentLoop:
+			for _, qname := range []string{"locus_tag", "gene"} {
+				for _, v := range f.qualifierValues(qname) {
+					if parent, exists := geneIDs[qname+"="+v]; exists {
+						attributes = append(attributes, Attribute{Key: "Parent", Values: []string{parent}})
+						break parentLoop
+					}
+				}
 			}
-
-			log.Fatalf("read file line error: %v", err)
-			return
 		}
-
-		if strings.HasPrefix(line, "##FASTA") {
-			inFasta = true
-			continue
-		} else if line[0] == '#' {
-			continue
+		if circular && f.key == "source" {
+			attributes = append(attributes, Attribute{Key: "Is_circular", Values: []string{"true"}})
+		}
+		if location.isPartial() {
+			attributes = append(attributes, Attribute{Key: "partial", Values: []string{"true"}})
 		}
+		qualifiers := f.gff3Attributes()
 
-		if inFasta {
-			if strings.HasPrefix(line, ">") {
-				if firstSeq {
-					firstSeq = false
-				} else {
-					foutSeqs.WriteString("\n")
-				}
-				foutSeqs.WriteString(line)
-			} else {
-				foutSeqs.WriteString(strings.ToUpper(strings.TrimSpace(line)))
+		for _, s := range location.segments {
+			if s.accession != "" {
+				log.Printf("Warning: ignoring part of %v feature %v on %v that is on a different sequence: %v", f.key, ids[i], contig, f.location)
+				continue
 			}
-		} else {
-			foutAnnot.WriteString(line)
+			end := s.end
+			if s.between {
+				// GFF3 zero-length sites have start == end, and the site
+				// is to the right of that base
+				end = s.start
+			}
+			segmentAttributes := append([]Attribute{}, attributes...)
+			if s.startPartial {
+				segmentAttributes = append(segmentAttributes, Attribute{Key: "start_range", Values: []string{".", strconv.Itoa(s.start)}})
+			}
+			if s.endPartial {
+				segmentAttributes = append(segmentAttributes, Attribute{Key: "end_range", Values: []string{strconv.Itoa(end), "."}})
+			}
+			gffFeatures = append(gffFeatures, Feature{
+				SeqID:      contig,
+				Source:     ".",
+				Type:       f.key,
+				Start:      s.start,
+				End:        end,
+				Score:      ".",
+				Strand:     s.strand,
+				Phase:      ".",
+				Attributes: append(segmentAttributes, qualifiers...),
+			})
 		}
 	}
-	foutSeqs.WriteString("\n")
-	foutSeqs.Flush()
-	foutAnnot.Flush()
+	return gffFeatures
 }
 
-func getGapsFromSingleLineFasta(infile string, minimumGapLen ...int) []Gap {
-	minGapLen := 1
-	if len(minimumGapLen) > 0 {
-		minGapLen = minimumGapLen[0]
-	}
-	gaps := []Gap{}
-	if minGapLen <= 0 {
-		return gaps
+// Returns true if the LOCUS line of a genbank file, or the ID line of an
+// EMBL file, says that the sequence is circular
+func isCircularGenbankOrEMBL(line string) bool {
+	for _, field := range strings.Fields(line) {
+		if strings.ToLower(strings.TrimRight(field, ";")) == "circular" {
+			return true
+		}
 	}
-	gapRegex := regexp.MustCompile(fmt.Sprintf(`N{%d,}`, minGapLen))
+	return false
+}
 
-	reader, err := xopen.Ropen(infile)
+// Imports a genbank or EMBL file. The identifiers, description and
+// organism of each record are written to outfileMetadata
+func parseGenbankOrEmblFile(infile string, outfileSeqs string, outfileAnnot string, outfileMetadata string, fformat FileFormat, options ImportOptions) error {
+	metadata, err := convertSeqFile(infile, fformat, outfileSeqs, outfileAnnot, false, options.keepCase(), options.SeqNameSource)
 	if err != nil {
-		log.Fatalf("Error opening file %v: %v", infile, err)
+		return err
 	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			log.Fatalf("Error closing file %v: %v", infile, err)
-		}
-	}()
-	currentName := ""
+	if err := resolveContigRecords(outfileSeqs, outfileAnnot, metadata); err != nil {
+		return err
+	}
+	return writeGenomeMetadata(metadata, outfileMetadata)
+}
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+func parseGFF3File(infile string, outfileSeqs string, outfileAnnot string, options ImportOptions) error {
+	_, err := convertSeqFile(infile, GFF3, outfileSeqs, outfileAnnot, options.StrictGFF3, options.keepCase(), NameFromLocus)
+	return err
+}
 
-			log.Fatalf("read file line error: %v", err)
-		}
+// ConvertAnnotationFile writes the annotation from a GFF3, GTF, BED,
+// genbank or EMBL file to a GFF3 file. Any sequences in the input file
+// are ignored
+func ConvertAnnotationFile(infile string, outfile string, strictGFF3 bool) error {
+	return convertAnnotationFile(infile, outfile, strictGFF3, NameFromLocus)
+}
 
-		if strings.HasPrefix(line, ">") {
-			fields := strings.Fields(line)
-			currentName = strings.TrimPrefix(fields[0], ">")
-		} else {
-			for _, match := range gapRegex.FindAllStringIndex(line, -1) {
-				gaps = append(gaps, Gap{SeqName: currentName, Start: match[0] + 1, End: match[1]})
-			}
-		}
+// Same as ConvertAnnotationFile, except that the sequence names of
+// genbank and EMBL records are chosen by nameSource
+func convertAnnotationFile(infile string, outfile string, strictGFF3 bool, nameSource SeqNameSource) error {
+	filetype, err := GetFileType(infile)
+	if err != nil {
+		return err
 	}
-	return gaps
+	switch filetype {
+	case GFF3, GENBANK, EMBL, GTF, BED:
+		_, err := convertSeqFile(infile, filetype, "", outfile, strictGFF3, false, nameSource)
+		return err
+	case FASTA, FASTQ:
+		return fmt.Errorf("%w: file %v is a sequence file, not an annotation file", ErrUnknownFormat, infile)
+	}
+	return fmt.Errorf("%w: could not determine type of annotation file %v", ErrUnknownFormat, infile)
 }
 
-func addGapsToAnnotFile(gaps []Gap, filename string) {
-	annotFileExists := utils.FileExists(filename)
-	fout, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// Adds the features and ##sequence-region pragmas from one GFF3 file to
+// the end of another. The file to add to is made if it does not exist
+func appendGFF3File(infile string, outfile string) (err error) {
+	outfileExists := utils.FileExists(outfile)
+	fout, err := os.OpenFile(outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+		return fmt.Errorf("error opening file %v: %w", outfile, err)
 	}
-	defer fout.Close()
-	if !(annotFileExists) {
+	defer closeWriter(fout, outfile, &err)
+	if !outfileExists {
 		fout.WriteString("##gff-version 3\n")
 	}
-	for _, gap := range gaps {
-		fout.WriteString(fmt.Sprintf("%v\tTNA\tgap\t%v\t%v\t.\t+\t.\tname=gap\n", gap.SeqName, gap.Start, gap.End))
+
+	return forEachLine(infile, func(line string) error {
+		if !strings.HasPrefix(line, "#") || strings.HasPrefix(line, "##sequence-region") {
+			fout.WriteString(line)
+		}
+		return nil
+	})
+}
+
+// ImportOptions holds the settings used when importing a sequence file
+// with ParseSeqFileWithOptions
+type ImportOptions struct {
+	// Runs of gap characters at least this long are added to the
+	// annotation as gaps. Anything <= 0 means do not add gaps
+	MinGapLen int
+	// Characters that are counted as gap, eg "NX-". Not case sensitive.
+	// If empty, DefaultGapChars is used
+	GapChars string
+	// If true, any problem in GFF3 input is fatal. Otherwise problems are
+	// fixed where possible, lines that cannot be fixed are skipped, and
+	// each problem is reported as a warning
+	StrictGFF3 bool
+	// What to do with features that are on sequences that were not
+	// imported, or that go past the end of their sequence
+	AnnotationPolicy AnnotationPolicy
+	// Optional file of annotation (GFF3, GTF, BED, genbank or EMBL) to
+	// add to the annotation of the imported sequences
+	AnnotationFile string
+	// Optional AGP file describing how the imported sequences are joined
+	// into scaffolds. If given, the scaffolds are imported instead of the
+	// sequences they are made from
+	AGPFile string
+	// If true, sequences with a name that is already used are renamed by
+	// adding .2, .3, etc. Otherwise duplicated names are fatal
+	UniqueNames bool
+	// Added to the start of every sequence name
+	NamePrefix string
+	// Bases at the start and end of FASTQ reads with quality below this
+	// are trimmed off. Anything <= 0 means do not trim
+	FastqTrimQuality int
+	// Bases in FASTQ reads with quality below this are changed to N, so
+	// that they can be found as gaps. Anything <= 0 means do not mask
+	FastqMaskQuality int
+	// FASTQ reads shorter than this after trimming are not imported
+	FastqMinLength int
+	// Runs of lower case (soft-masked) bases at least this long are added
+	// to the annotation. Anything <= 0 means do not add them. Ignored for
+	// genbank and EMBL input
+	SoftMaskMinLen int
+	// Feature type used for soft-masked runs: repeat_region or
+	// low_complexity
+	SoftMaskType string
+	// Sequences are split into pieces at gaps at least this long, and
+	// the pieces are imported instead. Anything <= 0 means do not split
+	SplitMinGapLen int
+	// What to do with features that cross a gap where a sequence is split
+	SplitPolicy SplitPolicy
+	// Which identifier of genbank and EMBL records is used as the
+	// sequence name. This also applies to a genbank or EMBL AnnotationFile
+	SeqNameSource SeqNameSource
+	// NCBI genetic code used to translate CDS features that do not have
+	// a transl_table attribute. Zero means DefaultGeneticCode
+	GeneticCode int
+	// Result of SniffFileType on the input file, if the caller already
+	// has it, so that the file is not sniffed again. If nil, the file is
+	// sniffed when it is imported
+	Sniffed *SniffResult
+}
+
+// Sequences must keep their case when imported, so that soft-masked
+// runs can be found. This is never done for genbank or EMBL input
+func (o ImportOptions) keepCase() bool {
+	return o.SoftMaskMinLen > 0
+}
+
+func DefaultImportOptions() ImportOptions {
+	return ImportOptions{
+		MinGapLen:        1,
+		GapChars:         DefaultGapChars,
+		StrictGFF3:       false,
+		AnnotationPolicy: AnnotClip,
+		SoftMaskType:     "repeat_region",
 	}
 }
 
-func ParseSeqFile(infile string, outprefix string, minimumGapLen ...int) {
-	minGapLen := 1
+func ParseSeqFile(infile string, outprefix string, minimumGapLen ...int) error {
+	options := DefaultImportOptions()
 	if len(minimumGapLen) > 0 {
-		minGapLen = minimumGapLen[0]
+		options.MinGapLen = minimumGapLen[0]
+	}
+	return ParseSeqFileWithOptions(infile, outprefix, options)
+}
+
+func ParseSeqFileWithOptions(infile string, outprefix string, options ImportOptions) error {
+	if options.keepCase() && !isSoftMaskType(options.SoftMaskType) {
+		return fmt.Errorf("unknown soft-masked feature type '%v'. Must be one of: %v", options.SoftMaskType, strings.Join(softMaskTypes, ", "))
+	}
+	if options.GeneticCode == 0 {
+		options.GeneticCode = DefaultGeneticCode
+	}
+	if err := checkGeneticCode(options.GeneticCode); err != nil {
+		return err
+	}
+	var sniffed SniffResult
+	var err error
+	if options.Sniffed != nil {
+		sniffed = *options.Sniffed
+	} else if sniffed, err = SniffFileType(infile); err != nil {
+		return err
+	}
+	filetype := sniffed.Format
+	if sniffed.Protein {
+		return fmt.Errorf("%w: file %v looks like protein FASTA, but only nucleotide sequences can be imported", ErrUnknownFormat, infile)
+	}
+	if options.keepCase() && (filetype == GENBANK || filetype == EMBL) {
+		// genbank and EMBL sequences are usually all lower case, which
+		// would make every sequence one soft-masked run
+		log.Printf("Warning: soft-masked runs are only found in FASTA, FASTQ and GFF3 files, not in genbank or EMBL file %v", infile)
+		options.SoftMaskMinLen = 0
 	}
-	filetype := GetFileType(infile)
 	fastaOutfile := outprefix + ".fa"
 	annotOutfile := outprefix + ".gff"
+	metadataOutfile := outprefix + ".metadata.json"
+	if err := utils.DeleteFileIfExists(metadataOutfile); err != nil {
+		return err
+	}
 	switch filetype {
 	case FASTA:
-		parseFastaFile(infile, fastaOutfile)
+		err = parseFastaFile(infile, fastaOutfile, options)
+		if err == nil {
+			err = utils.DeleteFileIfExists(annotOutfile)
+		}
 	case FASTQ:
-		parseFastqFile(infile, fastaOutfile)
+		err = parseFastqFile(infile, fastaOutfile, options)
+		if err == nil {
+			err = utils.DeleteFileIfExists(annotOutfile)
+		}
 	case GFF3:
-		parseGFF3File(infile, fastaOutfile, annotOutfile)
+		err = parseGFF3File(infile, fastaOutfile, annotOutfile, options)
 	case GENBANK:
-		parseGenbankOrEmblFile(infile, fastaOutfile, annotOutfile, GENBANK)
+		err = parseGenbankOrEmblFile(infile, fastaOutfile, annotOutfile, metadataOutfile, GENBANK, options)
 	case EMBL:
-		parseGenbankOrEmblFile(infile, fastaOutfile, annotOutfile, EMBL)
+		err = parseGenbankOrEmblFile(infile, fastaOutfile, annotOutfile, metadataOutfile, EMBL, options)
+	case GTF, BED:
+		err = fmt.Errorf("%w: file %v is a GTF or BED annotation file, which has no sequences. Use it as the annotation file with a sequence file instead", ErrUnknownFormat, infile)
 	default:
-		log.Fatalf("Error: could not determine type of file %v", infile)
+		err = fmt.Errorf("%w: could not determine type of file %v: %v", ErrUnknownFormat, infile, sniffed.Reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	if options.AnnotationFile != "" {
+		tmpAnnot := outprefix + ".tmp.annotation.gff"
+		if err := convertAnnotationFile(options.AnnotationFile, tmpAnnot, options.StrictGFF3, options.SeqNameSource); err != nil {
+			utils.DeleteFileIfExists(tmpAnnot)
+			return err
+		}
+		if err := appendGFF3File(tmpAnnot, annotOutfile); err != nil {
+			return err
+		}
+		if err := utils.DeleteFileIfExists(tmpAnnot); err != nil {
+			return err
+		}
+	}
+	if options.AGPFile != "" {
+		if err := importScaffoldsFromAGP(fastaOutfile, annotOutfile, options.AGPFile, outprefix); err != nil {
+			return err
+		}
+	}
+	if err := renameSequences(fastaOutfile, annotOutfile, outprefix+".names.tsv", options.NamePrefix, options.UniqueNames); err != nil {
+		return err
+	}
+	if _, err := checkAnnotation(fastaOutfile, annotOutfile, outprefix+".annotation_problems.tsv", options.AnnotationPolicy); err != nil {
+		return err
+	}
+	gapChars := options.GapChars
+	if gapChars == "" {
+		gapChars = DefaultGapChars
+	}
+	if options.SplitMinGapLen > 0 {
+		if err := splitAtGaps(fastaOutfile, annotOutfile, outprefix+".split.tsv", options.SplitMinGapLen, gapChars, options.SplitPolicy); err != nil {
+			return err
+		}
+	}
+	softMasked := []Feature{}
+	if options.keepCase() {
+		softMasked, err = getSoftMaskedFromSingleLineFasta(fastaOutfile, options.SoftMaskMinLen, options.SoftMaskType)
+		if err != nil {
+			return err
+		}
+		if err := upperCaseSingleLineFasta(fastaOutfile); err != nil {
+			return err
+		}
+	}
+	gaps, err := getGapsFromSingleLineFasta(fastaOutfile, options.MinGapLen, gapChars)
+	if err != nil {
+		return err
+	}
+	if len(gaps) > 0 && utils.FileExists(annotOutfile) {
+		if err := addGapInfoFromAnnotation(gaps, annotOutfile); err != nil {
+			return err
+		}
+	}
+	if err := writeIndexAndSummary(fastaOutfile, annotOutfile, gaps, fastaOutfile+".fai", outprefix+".summary.tsv"); err != nil {
+		return err
 	}
-	gaps := getGapsFromSingleLineFasta(fastaOutfile, minGapLen)
 	if len(gaps) > 0 {
-		addGapsToAnnotFile(gaps, annotOutfile)
+		if err := addGapsToAnnotFile(gaps, annotOutfile); err != nil {
+			return err
+		}
+	}
+	if len(softMasked) > 0 {
+		if err := appendFeaturesToAnnotFile(softMasked, annotOutfile); err != nil {
+			return err
+		}
 	}
+	return writeProteins(fastaOutfile, annotOutfile, outprefix+".faa", options.GeneticCode)
 }
@@ -10,12 +10,13 @@ import (
 
 func TestParseFASTA(t *testing.T) {
 	infile := filepath.Join("seqfiles_testdata", "parseFasta.in.fa.gz")
-	fileType := GetFileType(infile)
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
 	require.Equal(t, FASTA, fileType, "Did not get filetype of FASTA")
 	outprefix := "tmp.test.ParseFasta"
 	outfile := outprefix + ".fa"
 	utils.DeleteFileIfExists(outfile)
-	ParseSeqFile(infile, outprefix)
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
 	expectFile := filepath.Join("seqfiles_testdata", "parseFasta.expect.fa")
 	cmp := equalfile.New(nil, equalfile.Options{})
 	filesEqual, err := cmp.CompareFile(expectFile, outfile)
@@ -25,40 +26,45 @@ func TestParseFASTA(t *testing.T) {
 
 	infile = filepath.Join("seqfiles_testdata", "parseFasta.no_final_newline.in.fa")
 	expectFile = filepath.Join("seqfiles_testdata", "parseFasta.no_final_newline.expect.fa")
-	ParseSeqFile(infile, outprefix)
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
 	filesEqual, err = cmp.CompareFile(expectFile, outfile)
 	require.NoError(t, err, "Error comparing FASTA files %s, %s", expectFile, outfile)
 	require.True(t, filesEqual, "FASTA file %s expected contents incorrect", outfile)
 	utils.DeleteFileIfExists(outfile)
-
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
 }
 
 func TestParseFASTQ(t *testing.T) {
 	infile := filepath.Join("seqfiles_testdata", "parseFastq.in.fq")
-	fileType := GetFileType(infile)
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
 	require.Equal(t, FASTQ, fileType, "Did not get filetype of FASTQ")
 	outprefix := "tmp.test.ParseFastq"
 	outfile := outprefix + ".fa"
 	utils.DeleteFileIfExists(outfile)
-	ParseSeqFile(infile, outprefix)
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
 	expectFile := filepath.Join("seqfiles_testdata", "parseFastq.expect.fa")
 	cmp := equalfile.New(nil, equalfile.Options{})
 	filesEqual, err := cmp.CompareFile(expectFile, outfile)
 	require.NoError(t, err, "Error comparing FASTA files %s, %s", expectFile, outfile)
 	require.True(t, filesEqual, "FASTA file %s expected contents incorrect", outfile)
 	utils.DeleteFileIfExists(outfile)
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
 }
 
 func TestParseGFF3(t *testing.T) {
 	infile := filepath.Join("seqfiles_testdata", "parseGFF3.in.gff")
-	fileType := GetFileType(infile)
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
 	require.Equal(t, GFF3, fileType, "Did not get filetype of GFF3")
 	outprefix := "tmp.test.ParseGFF3"
 	outfileFa := outprefix + ".fa"
 	utils.DeleteFileIfExists(outfileFa)
 	outfileAnnot := outprefix + ".gff"
 	utils.DeleteFileIfExists(outfileAnnot)
-	ParseSeqFile(infile, outprefix)
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
 
 	expectFileFa := filepath.Join("seqfiles_testdata", "parseGFF3.expect.fa")
 	cmp := equalfile.New(nil, equalfile.Options{})
@@ -72,33 +78,49 @@ func TestParseGFF3(t *testing.T) {
 	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFileFa, outfileFa)
 	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfileFa)
 	utils.DeleteFileIfExists(outfileAnnot)
+	utils.DeleteFileIfExists(outprefix + ".annotation_problems.tsv")
+	utils.DeleteFileIfExists(outprefix + ".faa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
 }
 
 func TestSeqnameFromLineGenbankOrEMBL(t *testing.T) {
 	s := "LOCUS    name  foo    bar\n"
-	got := seqnameFromLineGenbankOrEMBL(s, GENBANK)
+	got, err := seqnameFromLineGenbankOrEMBL(s, GENBANK)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "name", "Got name '%s' instead of 'name'", got)
 
 	s = "LOCUS    name\n"
-	got = seqnameFromLineGenbankOrEMBL(s, GENBANK)
+	got, err = seqnameFromLineGenbankOrEMBL(s, GENBANK)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "name", "Got name '%s' instead of 'name'", got)
 
 	s = "ID   name\n"
-	got = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	got, err = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "name", "Got name '%s' instead of 'name'", got)
 
 	s = "ID   name;\n"
-	got = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	got, err = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "name", "Got name '%s' instead of 'name'", got)
 
 	s = "ID   name; foo\n"
-	got = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	got, err = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	require.NoError(t, err, "Error getting name from line %v", s)
+	require.Equal(t, got, "name", "Got name '%s' instead of 'name'", got)
+
+	s = "ID\tname;\r\n"
+	got, err = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "name", "Got name '%s' instead of 'name'", got)
 
 	s = "not a line with seq name in it\n"
-	got = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	got, err = seqnameFromLineGenbankOrEMBL(s, EMBL)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "", "Got name '%s' instead of empty string", got)
-	got = seqnameFromLineGenbankOrEMBL(s, GENBANK)
+	got, err = seqnameFromLineGenbankOrEMBL(s, GENBANK)
+	require.NoError(t, err, "Error getting name from line %v", s)
 	require.Equal(t, got, "", "Got name '%s' instead of empty string", got)
 }
 
@@ -118,14 +140,15 @@ func TestLineMarksGebnkaOrEmblSequenceStart(t *testing.T) {
 
 func TestParseGenbank(t *testing.T) {
 	infile := filepath.Join("seqfiles_testdata", "parseGenbank.in.gbk")
-	fileType := GetFileType(infile)
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
 	require.Equal(t, GENBANK, fileType, "Did not get filetype of GENBANK")
 	outprefix := "tmp.test.ParseGenbank"
 	outfileFa := outprefix + ".fa"
 	utils.DeleteFileIfExists(outfileFa)
 	outfileAnnot := outprefix + ".gff"
 	utils.DeleteFileIfExists(outfileAnnot)
-	ParseSeqFile(infile, outprefix)
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
 
 	expectFileFa := filepath.Join("seqfiles_testdata", "parseGenbank.expect.fa")
 	cmp := equalfile.New(nil, equalfile.Options{})
@@ -139,18 +162,29 @@ func TestParseGenbank(t *testing.T) {
 	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFileFa, outfileFa)
 	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfileFa)
 	utils.DeleteFileIfExists(outfileAnnot)
+
+	expectFileMetadata := filepath.Join("seqfiles_testdata", "parseGenbank.expect.metadata.json")
+	filesEqual, err = cmp.CompareFile(expectFileMetadata, outprefix+".metadata.json")
+	require.NoError(t, err, "Error comparing metadata files %s, %s", expectFileMetadata, outprefix+".metadata.json")
+	require.True(t, filesEqual, "Metadata file %s expected contents incorrect", outprefix+".metadata.json")
+	utils.DeleteFileIfExists(outprefix + ".metadata.json")
+	utils.DeleteFileIfExists(outprefix + ".annotation_problems.tsv")
+	utils.DeleteFileIfExists(outprefix + ".faa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
 }
 
 func TestParseEMBL(t *testing.T) {
 	infile := filepath.Join("seqfiles_testdata", "parseEMBL.in.embl")
-	fileType := GetFileType(infile)
+	fileType, err := GetFileType(infile)
+	require.NoError(t, err, "Error getting file type")
 	require.Equal(t, EMBL, fileType, "Did not get filetype of EMBL")
 	outprefix := "tmp.test.ParseEMBL"
 	outfileFa := outprefix + ".fa"
 	utils.DeleteFileIfExists(outfileFa)
 	outfileAnnot := outprefix + ".gff"
 	utils.DeleteFileIfExists(outfileAnnot)
-	ParseSeqFile(infile, outprefix)
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
 
 	expectFileFa := filepath.Join("seqfiles_testdata", "parseEMBL.expect.fa")
 	cmp := equalfile.New(nil, equalfile.Options{})
@@ -164,6 +198,11 @@ func TestParseEMBL(t *testing.T) {
 	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFileFa, outfileFa)
 	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfileFa)
 	utils.DeleteFileIfExists(outfileAnnot)
+	utils.DeleteFileIfExists(outprefix + ".metadata.json")
+	utils.DeleteFileIfExists(outprefix + ".annotation_problems.tsv")
+	utils.DeleteFileIfExists(outprefix + ".faa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
 }
 
 func TestGaps(t *testing.T) {
@@ -172,7 +211,9 @@ func TestGaps(t *testing.T) {
 	gaps = append(gaps, Gap{SeqName: "one", Start: 1, End: 2})
 	gaps = append(gaps, Gap{SeqName: "two", Start: 4, End: 6})
 	gaps = append(gaps, Gap{SeqName: "three", Start: 1, End: 2})
-	require.Equal(t, gaps, getGapsFromSingleLineFasta(infile, 2), "Incorrect gaps in TestGetGapsFromSingleLineFasta")
+	got, err := getGapsFromSingleLineFasta(infile, 2, DefaultGapChars)
+	require.NoError(t, err, "Error getting gaps from file %v", infile)
+	require.Equal(t, gaps, got, "Incorrect gaps in TestGetGapsFromSingleLineFasta")
 	gaps = []Gap{}
 	gaps = append(gaps, Gap{SeqName: "one", Start: 1, End: 2})
 	gaps = append(gaps, Gap{SeqName: "one", Start: 8, End: 8})
@@ -180,11 +221,13 @@ func TestGaps(t *testing.T) {
 	gaps = append(gaps, Gap{SeqName: "two", Start: 14, End: 14})
 	gaps = append(gaps, Gap{SeqName: "two", Start: 16, End: 16})
 	gaps = append(gaps, Gap{SeqName: "three", Start: 1, End: 2})
-	require.Equal(t, gaps, getGapsFromSingleLineFasta(infile), "Incorrect gaps in TestGetGapsFromSingleLineFasta")
+	got, err = getGapsFromSingleLineFasta(infile, 1, DefaultGapChars)
+	require.NoError(t, err, "Error getting gaps from file %v", infile)
+	require.Equal(t, gaps, got, "Incorrect gaps in TestGetGapsFromSingleLineFasta")
 
 	outfileAnnot := "tmp.test.gaps.gff"
 	utils.DeleteFileIfExists(outfileAnnot)
-	addGapsToAnnotFile(gaps, outfileAnnot)
+	require.NoError(t, addGapsToAnnotFile(gaps, outfileAnnot), "Error adding gaps to file")
 	expectFileAnnot := filepath.Join("seqfiles_testdata", "gaps.expect.1.gff")
 	cmp := equalfile.New(nil, equalfile.Options{})
 	filesEqual, err := cmp.CompareFile(expectFileAnnot, outfileAnnot)
@@ -193,10 +236,86 @@ func TestGaps(t *testing.T) {
 
 	gaps = gaps[:0]
 	gaps = append(gaps, Gap{SeqName: "four", Start: 42, End: 142})
-	addGapsToAnnotFile(gaps, outfileAnnot)
+	require.NoError(t, addGapsToAnnotFile(gaps, outfileAnnot), "Error adding gaps to file")
 	expectFileAnnot = filepath.Join("seqfiles_testdata", "gaps.expect.2.gff")
 	filesEqual, err = cmp.CompareFile(expectFileAnnot, outfileAnnot)
 	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFileAnnot, outfileAnnot)
 	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfileAnnot)
 	utils.DeleteFileIfExists(outfileAnnot)
 }
+
+func TestAddGenbankOrEmblFeatureLine(t *testing.T) {
+	features := []genbankFeature{}
+	features = addGenbankOrEmblFeatureLine(features, "                     /gene=\"ignored\"\n")
+	require.Equal(t, 0, len(features), "Qualifier before any feature should be ignored")
+	features = addGenbankOrEmblFeatureLine(features, "     mobile_element  join(1..10,\n")
+	features = addGenbankOrEmblFeatureLine(features, "                     20..30)\n")
+	features = addGenbankOrEmblFeatureLine(features, "                     /note=\"a note that\n")
+	features = addGenbankOrEmblFeatureLine(features, "                     /spans lines\"\n")
+	features = addGenbankOrEmblFeatureLine(features, "                     /pseudo\n")
+	features = addGenbankOrEmblFeatureLine(features, "     CDS             1..3\n")
+	expect := []genbankFeature{
+		{
+			key:      "mobile_element",
+			location: "join(1..10,20..30)",
+			qualifiers: []genbankQualifier{
+				{name: "note", value: "\"a note that /spans lines\""},
+				{name: "pseudo", value: ""},
+			},
+		},
+		{key: "CDS", location: "1..3"},
+	}
+	require.Equal(t, expect, features, "Error parsing feature lines")
+}
+
+func TestGenbankFeatureGFF3Attributes(t *testing.T) {
+	feature := genbankFeature{
+		key:      "CDS",
+		location: "1..3",
+		qualifiers: []genbankQualifier{
+			{name: "db_xref", value: "\"GI:1\""},
+			{name: "note", value: "\"a \"\"quoted\"\" note; with a semicolon\""},
+			{name: "db_xref", value: "\"taxon:2\""},
+			{name: "pseudo", value: ""},
+			{name: "EC_number", value: "\"1.2.3.4\""},
+			{name: "translation", value: "\"MABC DEF\""},
+			{name: "codon_start", value: "1"},
+		},
+	}
+	expect := "db_xref=GI:1,taxon:2;note=a \"quoted\" note%3B with a semicolon;pseudo=true;eC_number=1.2.3.4;translation=MABCDEF;codon_start=1"
+	require.Equal(t, expect, gff3AttributesString(feature.gff3Attributes()), "Error making GFF3 attributes from qualifiers")
+}
+
+func TestParseSeqFileWithAnnotationFile(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "importWithAnnotation.in.fa")
+	outprefix := "tmp.test.ParseSeqFileWithAnnotationFile"
+	outfileFa := outprefix + ".fa"
+	outfileAnnot := outprefix + ".gff"
+	utils.DeleteFileIfExists(outfileFa)
+	utils.DeleteFileIfExists(outfileAnnot)
+	options := DefaultImportOptions()
+	options.MinGapLen = 3
+	options.AnnotationFile = filepath.Join("seqfiles_testdata", "gtf.in.gtf")
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+
+	expectFileAnnot := filepath.Join("seqfiles_testdata", "importWithAnnotation.expect.gff")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFileAnnot, outfileAnnot)
+	require.NoError(t, err, "Error comparing annotation files %s, %s", expectFileAnnot, outfileAnnot)
+	require.True(t, filesEqual, "Annotation file %s expected contents incorrect", outfileAnnot)
+	require.True(t, utils.FileExists(outprefix+".annotation_problems.tsv"), "Annotation problems file not found")
+
+	for _, suffix := range []string{"fa.fai", "summary.tsv"} {
+		expectFile := filepath.Join("seqfiles_testdata", "importWithAnnotation.expect."+suffix)
+		gotFile := outprefix + "." + suffix
+		filesEqual, err = cmp.CompareFile(expectFile, gotFile)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, gotFile)
+		require.True(t, filesEqual, "File %s expected contents incorrect", gotFile)
+	}
+	utils.DeleteFileIfExists(outfileFa)
+	utils.DeleteFileIfExists(outfileAnnot)
+	utils.DeleteFileIfExists(outprefix + ".annotation_problems.tsv")
+	utils.DeleteFileIfExists(outprefix + ".faa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+}
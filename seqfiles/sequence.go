@@ -0,0 +1,145 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"io"
+	"sort"
+)
+
+// Feature types that describe the sequence itself rather than something
+// on one strand of it, so keep their strand when reverse complemented
+var unstrandedFeatureTypes = map[string]bool{
+	"region":       true,
+	"source":       true,
+	"gap":          true,
+	"assembly_gap": true,
+}
+
+func reverseStrand(strand string) string {
+	switch strand {
+	case "+":
+		return "-"
+	case "-":
+		return "+"
+	}
+	return strand
+}
+
+// Returns a copy of a feature that is moved from one sequence to another.
+// The region from regionStart to regionEnd (1-based, inclusive) of the
+// old sequence starts at newStart in the new sequence, and is reverse
+// complemented if reverse is true, which also changes the strand of the
+// feature. The feature must be inside the region
+func liftFeature(feature Feature, newSeqID string, regionStart int, regionEnd int, newStart int, reverse bool) Feature {
+	lifted := feature
+	lifted.SeqID = newSeqID
+	lifted.Attributes = make([]Attribute, len(feature.Attributes))
+	for i, attribute := range feature.Attributes {
+		lifted.Attributes[i] = Attribute{Key: attribute.Key, Values: append([]string{}, attribute.Values...)}
+	}
+	if reverse {
+		lifted.Start = newStart + regionEnd - feature.End
+		lifted.End = newStart + regionEnd - feature.Start
+		if !unstrandedFeatureTypes[feature.Type] {
+			lifted.Strand = reverseStrand(feature.Strand)
+		}
+	} else {
+		lifted.Start = newStart + feature.Start - regionStart
+		lifted.End = newStart + feature.End - regionStart
+	}
+	return lifted
+}
+
+// Reverse complements the sequence of a record, and moves its features to
+// the other strand. The features are kept sorted by start position
+func reverseComplementRecord(record *SeqRecord) {
+	record.Seq = string(utils.ReverseComplement([]byte(record.Seq)))
+	for i, feature := range record.Features {
+		record.Features[i] = liftFeature(feature, record.Name, 1, len(record.Seq), 1, true)
+	}
+	sortFeatures(record.Features)
+}
+
+func sortFeatures(features []Feature) {
+	sort.SliceStable(features, func(i, j int) bool {
+		return features[i].Start < features[j].Start
+	})
+}
+
+// Returns the records from a FASTA file, with the features from a GFF3
+// file added. annotFile can be "" or not exist, meaning no features.
+// Features on sequences that are not in the FASTA file are returned in
+// extra records that have no sequence
+func readSeqsAndAnnotation(fastaFile string, annotFile string) ([]*SeqRecord, error) {
+	records, err := readAllSeqRecords(fastaFile, FASTA)
+	if err != nil {
+		return nil, err
+	}
+	if annotFile == "" || !utils.FileExists(annotFile) {
+		return records, nil
+	}
+	annotRecords, err := readAllSeqRecords(annotFile, GFF3)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*SeqRecord{}
+	for _, record := range records {
+		byName[record.Name] = record
+	}
+	for _, annotRecord := range annotRecords {
+		if record, exists := byName[annotRecord.Name]; exists {
+			record.Features = append(record.Features, annotRecord.Features...)
+			record.Circular = record.Circular || annotRecord.Circular
+			if annotRecord.RegionLength > 0 {
+				record.RegionLength = annotRecord.RegionLength
+			}
+		} else {
+			records = append(records, annotRecord)
+		}
+	}
+	return records, nil
+}
+
+// Returns all the records from a file of the given format
+func readAllSeqRecords(filename string, format FileFormat) ([]*SeqRecord, error) {
+	reader, err := newReaderWithFormat(filename, format)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	records := []*SeqRecord{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// Writes records to a FASTA file and their features to a GFF3 file
+func writeSeqsAndAnnotation(records []*SeqRecord, fastaFile string, annotFile string) (err error) {
+	fastaWriter, err := NewFastaWriter(fastaFile)
+	if err != nil {
+		return err
+	}
+	defer closeWriter(fastaWriter, fastaFile, &err)
+	gff3Writer, err := NewGFF3Writer(annotFile)
+	if err != nil {
+		return err
+	}
+	defer closeWriter(gff3Writer, annotFile, &err)
+	for _, record := range records {
+		if record.Seq != "" {
+			if err := fastaWriter.Write(record); err != nil {
+				return err
+			}
+		}
+		if err := gff3Writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/shenwei356/xopen"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Number of bytes from the start of a file that are used to detect its
+// format
+const sniffChunkSize = 64 * 1024
+
+// Letters that are only in protein sequences. All other letters can be
+// in nucleotide sequences, as IUPAC codes or X for masked bases
+const proteinOnlyLetters = "EFIJLOPQZefijlopqz"
+
+// Fraction of the letters in a FASTA file that must be in
+// proteinOnlyLetters for it to be protein instead of nucleotide. About a
+// third of the amino acids in a typical protein are one of these
+const minProteinOnlyFraction = 0.1
+
+const utf8BOM = "\uFEFF"
+
+// SniffResult is the detected format of a file
+type SniffResult struct {
+	Format FileFormat
+	// True if Format is FASTA and the sequences look like protein
+	Protein bool
+	// From 0 (no idea) to 1 (certain)
+	Confidence float64
+	// Human-readable description of why the format was chosen
+	Reason string
+}
+
+func (f FileFormat) String() string {
+	switch f {
+	case FASTA:
+		return "FASTA"
+	case FASTQ:
+		return "FASTQ"
+	case GFF3:
+		return "GFF3"
+	case GENBANK:
+		return "genbank"
+	case EMBL:
+		return "EMBL"
+	case GTF:
+		return "GTF"
+	case BED:
+		return "BED"
+	}
+	return "unknown"
+}
+
+// SniffFileType detects the format of a file from the start of its
+// contents. Every format is scored, and the one with the highest
+// confidence is returned. A UTF-8 byte order mark, Windows line endings,
+// blank lines and comment lines at the start of the file are allowed. An
+// error is only returned if the file could not be read
+func SniffFileType(filename string) (SniffResult, error) {
+	reader, err := xopen.Ropen(filename)
+	if err != nil {
+		return SniffResult{}, fmt.Errorf("error opening file %v: %w", filename, err)
+	}
+	defer reader.Close()
+
+	chunk := make([]byte, sniffChunkSize)
+	n, err := io.ReadFull(reader, chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return SniffResult{}, fmt.Errorf("error reading file %v: %w", filename, err)
+	}
+	text := string(chunk[:n])
+	if n == sniffChunkSize {
+		// the last line is probably incomplete
+		if i := strings.LastIndexByte(text, '\n'); i > 0 {
+			text = text[:i]
+		}
+	}
+	return sniffLines(strings.Split(text, "\n")), nil
+}
+
+// Returns the format of the given lines from the start of a file
+func sniffLines(lines []string) SniffResult {
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], utf8BOM)
+	}
+	nonBlank := []string{}
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if len(strings.TrimSpace(line)) > 0 {
+			nonBlank = append(nonBlank, line)
+		}
+	}
+	if len(nonBlank) == 0 {
+		return SniffResult{Format: Unknown, Reason: "file is empty"}
+	}
+
+	best := SniffResult{Format: Unknown, Reason: "does not look like any supported format"}
+	for _, sniff := range []func([]string) SniffResult{sniffFasta, sniffFastq, sniffGenbank, sniffEmbl, sniffGFF3, sniffGTF, sniffBED} {
+		result := sniff(nonBlank)
+		if result.Confidence > best.Confidence {
+			best = result
+		}
+	}
+	return best
+}
+
+// Returns true if the line starts with the keyword followed by whitespace
+func hasKeyword(line string, keyword string) bool {
+	return strings.HasPrefix(line, keyword) && len(line) > len(keyword) && unicode.IsSpace(rune(line[len(keyword)]))
+}
+
+func sniffFasta(lines []string) SniffResult {
+	result := SniffResult{Format: FASTA}
+	first := 0
+	for first < len(lines) && strings.HasPrefix(lines[first], "#") {
+		first++
+	}
+	if first == len(lines) || !strings.HasPrefix(lines[first], ">") {
+		return result
+	}
+
+	letters := 0
+	proteinOnly := 0
+	seqLines := 0
+	for _, line := range lines[first+1:] {
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		seqLines++
+		for _, c := range strings.TrimSpace(line) {
+			switch {
+			case strings.ContainsRune(proteinOnlyLetters, c):
+				proteinOnly++
+				letters++
+			case unicode.IsLetter(c):
+				letters++
+			case c != '*' && c != '-' && c != '.':
+				result.Confidence = 0.3
+				result.Reason = fmt.Sprintf("has a FASTA header line, but the sequence has unexpected character '%c'", c)
+				return result
+			}
+		}
+	}
+
+	if seqLines == 0 {
+		result.Confidence = 0.7
+		result.Reason = "has a FASTA header line, but no sequence was found"
+		return result
+	}
+	proteinOnlyFraction := float64(proteinOnly) / float64(max(letters, 1))
+	result.Protein = proteinOnlyFraction >= minProteinOnlyFraction
+	alphabet := "nucleotide"
+	if result.Protein {
+		alphabet = "protein"
+	}
+	result.Confidence = 1
+	result.Reason = fmt.Sprintf("FASTA header line followed by %v sequence (%.0f%% letters only found in protein)", alphabet, 100*proteinOnlyFraction)
+	if first > 0 {
+		result.Confidence = 0.9
+		result.Reason += fmt.Sprintf(", after %d comment line(s)", first)
+	}
+	return result
+}
+
+func sniffFastq(lines []string) SniffResult {
+	result := SniffResult{Format: FASTQ}
+	if !strings.HasPrefix(lines[0], "@") {
+		return result
+	}
+	seqLength := 0
+	i := 1
+	for i < len(lines) && !strings.HasPrefix(lines[i], "+") {
+		seqLength += len(strings.TrimSpace(lines[i]))
+		i++
+	}
+	qualLength := 0
+	for i++; i < len(lines) && qualLength < seqLength; i++ {
+		qualLength += len(strings.TrimSpace(lines[i]))
+	}
+	if seqLength == 0 || qualLength != seqLength {
+		result.Confidence = 0.4
+		result.Reason = "first line starts with '@', but is not followed by a sequence, '+' line and qualities of the same length"
+		return result
+	}
+	result.Confidence = 1
+	result.Reason = "first record has '@' header line, sequence, '+' line and qualities"
+	return result
+}
+
+// Returns the confidence and reason for a genbank or EMBL file, where
+// the first line of a record starts with the given keyword
+func sniffGenbankOrEmbl(lines []string, keyword string, nextKeywords []string) (float64, string) {
+	for i, line := range lines {
+		if !hasKeyword(line, keyword) {
+			continue
+		}
+		if i > 0 {
+			return 0.6, fmt.Sprintf("has a line starting with '%v', but not on the first line", keyword)
+		}
+		for _, next := range lines[i+1:] {
+			for _, nextKeyword := range nextKeywords {
+				if hasKeyword(next, nextKeyword) || next == nextKeyword {
+					return 1, fmt.Sprintf("first line starts with '%v', followed by a '%v' line", keyword, nextKeyword)
+				}
+			}
+		}
+		return 0.8, fmt.Sprintf("first line starts with '%v'", keyword)
+	}
+	return 0, ""
+}
+
+func sniffGenbank(lines []string) SniffResult {
+	confidence, reason := sniffGenbankOrEmbl(lines, "LOCUS", []string{"DEFINITION", "ACCESSION", "FEATURES", "ORIGIN"})
+	return SniffResult{Format: GENBANK, Confidence: confidence, Reason: reason}
+}
+
+func sniffEmbl(lines []string) SniffResult {
+	confidence, reason := sniffGenbankOrEmbl(lines, "ID", []string{"XX", "AC", "FH", "SQ"})
+	return SniffResult{Format: EMBL, Confidence: confidence, Reason: reason}
+}
+
+// Returns the lines that are not comments or header lines, up to the
+// FASTA section of a GFF3 file if there is one
+func featureLines(lines []string) []string {
+	features := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "##FASTA") || strings.HasPrefix(line, ">") {
+			break
+		} else if !isBEDHeaderLine(line) {
+			features = append(features, line)
+		}
+	}
+	return features
+}
+
+// Returns the fraction of lines for which isFeatureLine is true
+func fractionOfFeatureLines(lines []string, isFeatureLine func(string) bool) float64 {
+	if len(lines) == 0 {
+		return 0
+	}
+	count := 0
+	for _, line := range lines {
+		if isFeatureLine(line) {
+			count++
+		}
+	}
+	return float64(count) / float64(len(lines))
+}
+
+// Returns the version from the ##gff-version line, or "" if there is
+// not one before the first feature line
+func gffVersion(lines []string) string {
+	for _, line := range lines {
+		if hasKeyword(line, "##gff-version") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "##gff-version"))
+		} else if !strings.HasPrefix(line, "#") {
+			break
+		}
+	}
+	return ""
+}
+
+func isGFF3Line(line string) bool {
+	_, problems := parseGFF3Line(line, 0)
+	return (len(problems) == 0 || problems[len(problems)-1].fixed) && !isGTFLine(line)
+}
+
+func sniffGFF3(lines []string) SniffResult {
+	result := SniffResult{Format: GFF3}
+	if version := gffVersion(lines); strings.HasPrefix(version, "3") {
+		result.Confidence = 1
+		result.Reason = "has a '##gff-version 3' line"
+		return result
+	}
+	features := featureLines(lines)
+	fraction := fractionOfFeatureLines(features, isGFF3Line)
+	if fraction > 0 {
+		result.Confidence = 0.9 * fraction
+		result.Reason = fmt.Sprintf("no '##gff-version 3' line, but %.0f%% of %d feature lines are GFF3", 100*fraction, len(features))
+	}
+	return result
+}
+
+func sniffGTF(lines []string) SniffResult {
+	result := SniffResult{Format: GTF}
+	if version := gffVersion(lines); strings.HasPrefix(version, "2") {
+		result.Confidence = 0.95
+		result.Reason = "has a '##gff-version 2' line"
+		return result
+	}
+	features := featureLines(lines)
+	fraction := fractionOfFeatureLines(features, isGTFLine)
+	if fraction > 0 {
+		result.Confidence = 0.9 * fraction
+		result.Reason = fmt.Sprintf("%.0f%% of %d feature lines are GTF", 100*fraction, len(features))
+	}
+	return result
+}
+
+func sniffBED(lines []string) SniffResult {
+	result := SniffResult{Format: BED}
+	features := featureLines(lines)
+	fraction := fractionOfFeatureLines(features, isBEDLine)
+	if fraction > 0 {
+		result.Confidence = 0.8 * fraction
+		result.Reason = fmt.Sprintf("%.0f%% of %d feature lines are BED", 100*fraction, len(features))
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSniffLines(t *testing.T) {
+	tests := []struct {
+		contents string
+		format   FileFormat
+		protein  bool
+	}{
+		{"", Unknown, false},
+		{"\n\n", Unknown, false},
+		{"not a sequence file\n", Unknown, false},
+		{">seq1\nACGT\n", FASTA, false},
+		{"\uFEFF>seq1\r\nACGT\r\n", FASTA, false},
+		{"\n\n>seq1\nACGT\n", FASTA, false},
+		{"# a comment\n>seq1\nACGT\n", FASTA, false},
+		{">seq1\nMKVLAAGIVGLLLAQ*\n", FASTA, true},
+		{">seq1\nMKVLAAGIVGLLLAQ*\n>seq2\nACGTACGT\n", FASTA, true},
+		{">seq1\nACGTXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXACGT\n", FASTA, false},
+		{">seq1\nACRYKMSWBDHVNacrykmswbdhvnXx-\n", FASTA, false},
+		{"@read1\nACGT\n+\nIIII\n", FASTQ, false},
+		{"@read1\nAC\nGT\n+\n@I\nII", FASTQ, false},
+		{"##gff-version 3\nseq1\tsrc\tgene\t1\t10\t.\t+\t.\tID=g1\n", GFF3, false},
+		{"seq1\tsrc\tgene\t1\t10\t.\t+\t.\tID=g1\r\nseq1\tsrc\tCDS\t1\t10\t.\t+\t0\tParent=g1\r\n", GFF3, false},
+		{"##gff-version 2\n", GTF, false},
+		{"chr1\tsrc\texon\t1\t10\t.\t+\t.\tgene_id \"g1\"; transcript_id \"t1\";\n", GTF, false},
+		{"track name=foo\nchr1\t0\t10\tname\n", BED, false},
+		{"LOCUS       seq1     10 bp    DNA     linear\nDEFINITION  foo.\n", GENBANK, false},
+		{"\uFEFFID\tseq1; SV 1; linear; DNA; STD; PRO; 10 BP.\r\nXX\r\n", EMBL, false},
+	}
+	for _, test := range tests {
+		got := sniffLines(strings.Split(test.contents, "\n"))
+		require.Equal(t, test.format, got.Format, "Wrong format for contents %q (reason: %v)", test.contents, got.Reason)
+		require.Equal(t, test.protein, got.Protein, "Wrong protein flag for contents %q", test.contents)
+		require.NotEqual(t, "", got.Reason, "No reason given for contents %q", test.contents)
+		if test.format != Unknown {
+			require.Greater(t, got.Confidence, 0.5, "Confidence too low for contents %q", test.contents)
+		}
+	}
+}
+
+func TestParseFastaWindows(t *testing.T) {
+	infile := "tmp.test.ParseFastaWindows.in.fa"
+	contents := "\uFEFF\r\n# comment\r\n>seq1 foo\r\naCGT\r\ngT\r\n>seq2\r\nATG\r\n"
+	require.NoError(t, os.WriteFile(infile, []byte(contents), 0644), "Error writing file %v", infile)
+	result, err := SniffFileType(infile)
+	require.NoError(t, err, "Error sniffing file %v", infile)
+	require.Equal(t, FASTA, result.Format, "Wrong format of file %v", infile)
+
+	outprefix := "tmp.test.ParseFastaWindows"
+	require.NoError(t, ParseSeqFile(infile, outprefix), "Error importing file")
+	expectFile := filepath.Join("seqfiles_testdata", "parseFasta.expect.fa")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outprefix+".fa")
+	require.NoError(t, err, "Error comparing FASTA files %s, %s", expectFile, outprefix+".fa")
+	require.True(t, filesEqual, "FASTA file %s expected contents incorrect", outprefix+".fa")
+
+	require.NoError(t, os.WriteFile(infile, []byte(">prot\nMKVLAAGIVGLLLAQ\n"), 0644), "Error writing file %v", infile)
+	require.ErrorIs(t, ParseSeqFile(infile, outprefix), ErrUnknownFormat, "Should not import protein FASTA")
+
+	// the result of sniffing is used instead of sniffing again
+	options := DefaultImportOptions()
+	options.Sniffed = &SniffResult{Format: Unknown, Reason: "given by test"}
+	require.NoError(t, os.WriteFile(infile, []byte(contents), 0644), "Error writing file %v", infile)
+	err = ParseSeqFileWithOptions(infile, outprefix, options)
+	require.ErrorIs(t, err, ErrUnknownFormat, "Should use the given sniff result")
+	require.Contains(t, err.Error(), "given by test", "Wrong error message")
+
+	utils.DeleteFileIfExists(infile)
+	utils.DeleteFileIfExists(outprefix + ".fa")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+}
@@ -0,0 +1,86 @@
+package seqfiles
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Feature types that can be used for soft-masked runs of bases
+var softMaskTypes = []string{"repeat_region", "low_complexity"}
+
+func isSoftMaskType(featureType string) bool {
+	for _, t := range softMaskTypes {
+		if t == featureType {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a feature of the given type for each run of at least minLen
+// lower case bases in a FASTA file that has each sequence on one line.
+// The file is read in pieces, so runs that cross from one piece to the
+// next are joined up
+func getSoftMaskedFromSingleLineFasta(infile string, minLen int, featureType string) ([]Feature, error) {
+	features := []Feature{}
+	if minLen <= 0 {
+		return features, nil
+	}
+	currentName := ""
+	// 0-based position in the sequence of the start of the current piece,
+	// and of the start of the current run of lower case bases
+	position := 0
+	runStart := -1
+	addRun := func(end int) {
+		if runStart != -1 && end-runStart >= minLen {
+			features = append(features, Feature{
+				SeqID:      currentName,
+				Source:     "TNA",
+				Type:       featureType,
+				Start:      runStart + 1,
+				End:        end,
+				Score:      ".",
+				Strand:     "+",
+				Phase:      ".",
+				Attributes: []Attribute{{Key: "name", Values: []string{"soft_masked"}}},
+			})
+		}
+		runStart = -1
+	}
+
+	err := forEachFastaPiece(infile, func(line string, seqOffset int64) error {
+		addRun(position)
+		currentName = strings.TrimPrefix(strings.Fields(line)[0], ">")
+		position = 0
+		return nil
+	}, func(piece []byte) error {
+		for i, c := range piece {
+			if 'a' <= c && c <= 'z' {
+				if runStart == -1 {
+					runStart = position + i
+				}
+			} else {
+				addRun(position + i)
+			}
+		}
+		position += len(piece)
+		return nil
+	})
+	addRun(position)
+	return features, err
+}
+
+// Changes the sequences in a FASTA file to upper case. The file is
+// rewritten in pieces, so memory use does not depend on sequence length
+func upperCaseSingleLineFasta(filename string) error {
+	inHeader := false
+	return rewriteFilePieces(filename, func(piece []byte, lineStart bool, lineEnd bool) ([]byte, error) {
+		if lineStart {
+			inHeader = len(piece) > 0 && piece[0] == '>'
+		}
+		if inHeader {
+			return piece, nil
+		}
+		return bytes.ToUpper(piece), nil
+	})
+}
@@ -0,0 +1,82 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSoftMask(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "softMask.in.fa")
+	outprefix := "tmp.test.softMask"
+	options := DefaultImportOptions()
+	options.MinGapLen = 2
+	options.SoftMaskMinLen = 3
+	options.SoftMaskType = "low_complexity"
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, suffix := range []string{"fa", "gff"} {
+		expectFile := filepath.Join("seqfiles_testdata", "softMask.expect."+suffix)
+		gotFile := outprefix + "." + suffix
+		filesEqual, err := cmp.CompareFile(expectFile, gotFile)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, gotFile)
+		require.True(t, filesEqual, "File %s expected contents incorrect", gotFile)
+	}
+
+	options.SoftMaskType = "foo"
+	require.Error(t, ParseSeqFileWithOptions(infile, outprefix, options), "Should be error from unknown feature type")
+	utils.DeleteFileIfExists(outprefix + ".fa")
+	utils.DeleteFileIfExists(outprefix + ".gff")
+	utils.DeleteFileIfExists(outprefix + ".fa.fai")
+	utils.DeleteFileIfExists(outprefix + ".summary.tsv")
+}
+
+func TestSoftMaskGenbank(t *testing.T) {
+	// genbank sequences are lower case, but are not soft-masked
+	infile := filepath.Join("seqfiles_testdata", "parseGenbank.in.gbk")
+	outprefix := "tmp.test.softMaskGenbank"
+	options := DefaultImportOptions()
+	options.SoftMaskMinLen = 5
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, suffix := range []string{"fa", "gff"} {
+		expectFile := filepath.Join("seqfiles_testdata", "parseGenbank.expect."+suffix)
+		gotFile := outprefix + "." + suffix
+		filesEqual, err := cmp.CompareFile(expectFile, gotFile)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, gotFile)
+		require.True(t, filesEqual, "File %s expected contents incorrect", gotFile)
+	}
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".annotation_problems.tsv", ".metadata.json", ".faa"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
+
+func TestSoftMaskInChunks(t *testing.T) {
+	// runs that cross chunk boundaries, and a run at the end of a
+	// sequence with no newline at the end of the file
+	fasta := ">seq1 a description longer than one chunk\n" + "AC" + strings.Repeat("a", 20) + "GTacGT\n" + ">seq2\nAaaa"
+	infile := "tmp.test.softMaskInChunks.fa"
+	require.NoError(t, os.WriteFile(infile, []byte(fasta), 0644), "Error writing file")
+	expect := []Feature{
+		{SeqID: "seq1", Source: "TNA", Type: "low_complexity", Start: 3, End: 22, Score: ".", Strand: "+", Phase: ".", Attributes: []Attribute{{Key: "name", Values: []string{"soft_masked"}}}},
+		{SeqID: "seq2", Source: "TNA", Type: "low_complexity", Start: 2, End: 4, Score: ".", Strand: "+", Phase: ".", Attributes: []Attribute{{Key: "name", Values: []string{"soft_masked"}}}},
+	}
+	defer func(chunkSize int) { readChunkSize = chunkSize }(readChunkSize)
+
+	for _, chunkSize := range []int{16, 17, 1 << 20} {
+		readChunkSize = chunkSize
+		got, err := getSoftMaskedFromSingleLineFasta(infile, 3, "low_complexity")
+		require.NoError(t, err, "Error getting soft-masked runs")
+		require.Equal(t, expect, got, "Wrong soft-masked runs with chunk size %d", chunkSize)
+		require.NoError(t, upperCaseSingleLineFasta(infile), "Error changing to upper case")
+		gotFasta, err := os.ReadFile(infile)
+		require.NoError(t, err, "Error reading file %v", infile)
+		require.Equal(t, ">seq1 a description longer than one chunk\n"+"AC"+strings.Repeat("A", 20)+"GTACGT\n"+">seq2\nAAAA", string(gotFasta), "Wrong upper case file with chunk size %d", chunkSize)
+		require.NoError(t, os.WriteFile(infile, []byte(fasta), 0644), "Error writing file")
+	}
+	utils.DeleteFileIfExists(infile)
+}
@@ -0,0 +1,263 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"log"
+	"strconv"
+)
+
+// What to do with features that cross a gap where a sequence is split
+type SplitPolicy uint64
+
+const (
+	// Features that cross a gap are split into one feature per piece,
+	// with ".part_1", ".part_2", etc added to their IDs
+	SplitFeatures SplitPolicy = iota
+	// Features that cross a gap are dropped, and removed from the Parent
+	// of their children
+	SplitDrop
+)
+
+func ParseSplitPolicy(policy string) (SplitPolicy, error) {
+	switch policy {
+	case "split":
+		return SplitFeatures, nil
+	case "drop":
+		return SplitDrop, nil
+	}
+	return SplitFeatures, fmt.Errorf("Unknown split policy '%v'. Must be one of: split, drop", policy)
+}
+
+// A piece of a sequence between gaps. Start and End are 1-based and
+// inclusive, in the coordinates of the original sequence
+type seqPiece struct {
+	name  string
+	seqID string
+	start int
+	end   int
+}
+
+// Returns the pieces of a sequence of the given length that are between
+// the gaps, which must be sorted and on the same sequence. If there are no
+// gaps, the one piece keeps the name of the sequence. Otherwise pieces
+// are named <name>_1, <name>_2, etc
+func seqPieces(name string, length int, gaps []Gap) []seqPiece {
+	if len(gaps) == 0 {
+		return []seqPiece{{name: name, seqID: name, start: 1, end: length}}
+	}
+	pieces := []seqPiece{}
+	start := 1
+	addPiece := func(end int) {
+		if start <= end {
+			pieces = append(pieces, seqPiece{name: fmt.Sprintf("%v_%d", name, len(pieces)+1), seqID: name, start: start, end: end})
+		}
+	}
+	for _, gap := range gaps {
+		addPiece(gap.Start - 1)
+		start = gap.End + 1
+	}
+	addPiece(length)
+	return pieces
+}
+
+// Returns the phase of a CDS after removing bases from its start (5' end,
+// which is the end coordinate for the - strand)
+func clippedPhase(phase string, removed int) string {
+	p, err := strconv.Atoi(phase)
+	if err != nil || removed == 0 {
+		return phase
+	}
+	return strconv.Itoa(((p-removed)%3 + 3) % 3)
+}
+
+// Returns the parts of a feature on each of the pieces that it overlaps,
+// in the coordinates of the pieces. If there is more than one part, each
+// ID gets a .part_N suffix
+func splitFeature(feature Feature, pieces []seqPiece) []Feature {
+	overlapping := []seqPiece{}
+	for _, piece := range pieces {
+		if feature.Start <= piece.end && piece.start <= feature.End {
+			overlapping = append(overlapping, piece)
+		}
+	}
+	parts := []Feature{}
+	id := feature.GetAttribute("ID")
+	for i, piece := range overlapping {
+		clipped := feature
+		clipped.Start = max(feature.Start, piece.start)
+		clipped.End = min(feature.End, piece.end)
+		if feature.Strand == "-" {
+			clipped.Phase = clippedPhase(feature.Phase, feature.End-clipped.End)
+		} else {
+			clipped.Phase = clippedPhase(feature.Phase, clipped.Start-feature.Start)
+		}
+		part := liftFeature(clipped, piece.name, piece.start, piece.end, 1, false)
+		if id != "" && len(overlapping) > 1 {
+			newID := fmt.Sprintf("%v.part_%d", id, i+1)
+			part.SetAttribute("ID", newID)
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// Splits the sequences in fastaFile at gaps of at least minGapLen, and
+// moves the features in annotFile onto the pieces. Both files are
+// rewritten. Features that cross a gap are dealt with according to policy.
+// Features inside a gap are removed. Removed features are also removed
+// from the Parent of their children. Each piece, and where it is in the
+// original sequence, is written to tsvFile
+func splitAtGaps(fastaFile string, annotFile string, tsvFile string, minGapLen int, gapChars string, policy SplitPolicy) error {
+	gaps, err := getGapsFromSingleLineFasta(fastaFile, minGapLen, gapChars)
+	if err != nil {
+		return err
+	}
+	gapsBySeq := map[string][]Gap{}
+	for _, gap := range gaps {
+		gapsBySeq[gap.SeqName] = append(gapsBySeq[gap.SeqName], gap)
+	}
+	records, err := readSeqsAndAnnotation(fastaFile, annotFile)
+	if err != nil {
+		return err
+	}
+
+	newRecords := []*SeqRecord{}
+	allPieces := []seqPiece{}
+	usedNames := map[string]bool{}
+	partIDs := map[string]map[string]string{}
+	splitCount := 0
+	droppedCount := 0
+	inGapCount := 0
+	// IDs of features that were removed, and that were kept. An ID can be
+	// in both if a feature has more than one part
+	removedIDs := map[string]bool{}
+	keptIDs := map[string]bool{}
+	for _, record := range records {
+		if record.Seq == "" {
+			newRecords = append(newRecords, record)
+			continue
+		}
+		pieces := seqPieces(record.Name, len(record.Seq), gapsBySeq[record.Name])
+		if len(pieces) == 0 {
+			log.Printf("Warning: sequence %v is all gaps, so has no pieces after splitting at gaps. Sequence removed", record.Name)
+		}
+		pieceRecords := map[string]*SeqRecord{}
+		for _, piece := range pieces {
+			if usedNames[piece.name] {
+				return fmt.Errorf("%w: name %v of a piece after splitting sequences at gaps is already used", ErrDuplicateNames, piece.name)
+			}
+			usedNames[piece.name] = true
+			pieceRecord := &SeqRecord{Name: piece.name, Seq: record.Seq[piece.start-1 : piece.end], RegionLength: piece.end - piece.start + 1}
+			if len(pieces) == 1 {
+				pieceRecord.Circular = record.Circular
+			}
+			pieceRecords[piece.name] = pieceRecord
+			newRecords = append(newRecords, pieceRecord)
+		}
+		allPieces = append(allPieces, pieces...)
+
+		for _, feature := range record.Features {
+			parts := splitFeature(feature, pieces)
+			id := feature.GetAttribute("ID")
+			if len(parts) == 0 {
+				inGapCount++
+				removedIDs[id] = true
+				continue
+			} else if len(parts) > 1 {
+				if policy == SplitDrop {
+					droppedCount++
+					removedIDs[id] = true
+					continue
+				}
+				splitCount++
+			}
+			keptIDs[id] = true
+			for _, part := range parts {
+				pieceRecords[part.SeqID].Features = append(pieceRecords[part.SeqID].Features, part)
+				if id != "" && len(parts) > 1 {
+					if partIDs[id] == nil {
+						partIDs[id] = map[string]string{}
+					}
+					partIDs[id][part.SeqID] = part.GetAttribute("ID")
+				}
+			}
+		}
+	}
+
+	// Children of a feature that was split need their Parent changed to
+	// the part of the feature that is on the same piece. Parents that were
+	// removed are removed from the Parent of their children
+	orphanCount := 0
+	for _, record := range newRecords {
+		for i := range record.Features {
+			attributes := []Attribute{}
+			for _, attribute := range record.Features[i].Attributes {
+				if attribute.Key != "Parent" {
+					attributes = append(attributes, attribute)
+					continue
+				}
+				parents := []string{}
+				for _, parent := range attribute.Values {
+					if newID, exists := partIDs[parent][record.Name]; exists {
+						parents = append(parents, newID)
+					} else if !removedIDs[parent] || keptIDs[parent] {
+						parents = append(parents, parent)
+					}
+				}
+				if len(parents) < len(attribute.Values) {
+					orphanCount++
+				}
+				if len(parents) > 0 {
+					attributes = append(attributes, Attribute{Key: "Parent", Values: parents})
+				}
+			}
+			record.Features[i].Attributes = attributes
+		}
+	}
+
+	if splitCount > 0 {
+		log.Printf("Split %d feature(s) that crossed a gap where a sequence was split", splitCount)
+	}
+	if droppedCount > 0 {
+		log.Printf("Warning: removed %d feature(s) that crossed a gap where a sequence was split", droppedCount)
+	}
+	if inGapCount > 0 {
+		log.Printf("Removed %d feature(s) that were inside a gap where a sequence was split", inGapCount)
+	}
+	if orphanCount > 0 {
+		log.Printf("Warning: removed the Parent of %d feature(s), because their parent was removed when splitting sequences at gaps", orphanCount)
+	}
+	tmpFasta := fastaFile + ".tmp.split"
+	tmpAnnot := annotFile + ".tmp.split"
+	if err := writeSeqsAndAnnotation(newRecords, tmpFasta, tmpAnnot); err != nil {
+		utils.DeleteFileIfExists(tmpFasta)
+		utils.DeleteFileIfExists(tmpAnnot)
+		return err
+	}
+	if err := utils.RenameFile(tmpFasta, fastaFile); err != nil {
+		return err
+	}
+	if err := utils.RenameFile(tmpAnnot, annotFile); err != nil {
+		return err
+	}
+	return writeSeqPieces(allPieces, tsvFile)
+}
+
+func writeSeqPieces(pieces []seqPiece, outfile string) (err error) {
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	if _, err := fout.WriteString("name\tlength\toriginal_name\toriginal_start\toriginal_end\n"); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", outfile, err)
+	}
+	for _, p := range pieces {
+		if _, err := fout.WriteString(fmt.Sprintf("%v\t%d\t%v\t%d\t%d\n", p.name, p.end-p.start+1, p.seqID, p.start, p.end)); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", outfile, err)
+		}
+	}
+	return nil
+}
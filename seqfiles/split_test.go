@@ -0,0 +1,53 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeqPieces(t *testing.T) {
+	require.Equal(t, []seqPiece{{"seq", "seq", 1, 10}}, seqPieces("seq", 10, nil), "Wrong pieces with no gaps")
+	gaps := []Gap{{SeqName: "seq", Start: 1, End: 2}, {SeqName: "seq", Start: 5, End: 6}, {SeqName: "seq", Start: 9, End: 10}}
+	require.Equal(t, []seqPiece{{"seq_1", "seq", 3, 4}, {"seq_2", "seq", 7, 8}}, seqPieces("seq", 10, gaps), "Wrong pieces with gaps at ends")
+	require.Equal(t, []seqPiece{}, seqPieces("seq", 2, gaps[:1]), "Wrong pieces of all gap sequence")
+}
+
+func TestClippedPhase(t *testing.T) {
+	require.Equal(t, "1", clippedPhase("0", 11), "Wrong phase")
+	require.Equal(t, "0", clippedPhase("2", 2), "Wrong phase")
+	require.Equal(t, "2", clippedPhase("0", 1), "Wrong phase")
+	require.Equal(t, ".", clippedPhase(".", 5), "Wrong phase when no phase")
+}
+
+func TestSplitAtGaps(t *testing.T) {
+	infile := filepath.Join("seqfiles_testdata", "split.in.gff")
+	outprefix := "tmp.test.splitAtGaps"
+	options := DefaultImportOptions()
+	options.SplitMinGapLen = 3
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+
+	cmp := equalfile.New(nil, equalfile.Options{})
+	for _, suffix := range []string{".fa", ".gff", ".split.tsv"} {
+		expectFile := filepath.Join("seqfiles_testdata", "split.expect"+filepath.Ext(suffix))
+		filesEqual, err := cmp.CompareFile(expectFile, outprefix+suffix)
+		require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+suffix)
+		require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+suffix)
+	}
+
+	options.SplitPolicy = SplitDrop
+	require.NoError(t, ParseSeqFileWithOptions(infile, outprefix, options), "Error importing file")
+	expectFile := filepath.Join("seqfiles_testdata", "split.drop.expect.gff")
+	filesEqual, err := cmp.CompareFile(expectFile, outprefix+".gff")
+	require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+".gff")
+	require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+".gff")
+
+	_, err = ParseSplitPolicy("nope")
+	require.Error(t, err, "Should not parse unknown split policy")
+
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".split.tsv"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
@@ -0,0 +1,137 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"strings"
+)
+
+// Statistics for one sequence in a FASTA file that has each sequence on
+// one line. offset is the position in the file of the first base of the
+// sequence
+type seqStats struct {
+	name     string
+	length   int
+	offset   int64
+	gcCount  int
+	nCount   int
+	gaps     int
+	features int
+}
+
+// Percent of bases that are G or C, not counting Ns
+func (s *seqStats) gcPercent() float64 {
+	if s.length-s.nCount == 0 {
+		return 0
+	}
+	return 100 * float64(s.gcCount) / float64(s.length-s.nCount)
+}
+
+// Returns the stats of each sequence in the file, in the order they
+// appear. Assumes that each sequence is on one line. The gaps and
+// features counts are not set
+func getSeqStatsFromSingleLineFasta(infile string) ([]seqStats, error) {
+	stats := []seqStats{}
+	err := forEachFastaPiece(infile, func(line string, seqOffset int64) error {
+		stats = append(stats, seqStats{name: strings.TrimPrefix(strings.Fields(line)[0], ">"), offset: seqOffset})
+		return nil
+	}, func(piece []byte) error {
+		if len(stats) == 0 {
+			return nil
+		}
+		s := &stats[len(stats)-1]
+		for _, c := range piece {
+			switch c {
+			case 'G', 'C', 'g', 'c':
+				s.gcCount++
+			case 'N', 'n':
+				s.nCount++
+			}
+		}
+		s.length += len(piece)
+		return nil
+	})
+	return stats, err
+}
+
+// Returns the number of features on each sequence in a GFF3 file
+func countFeaturesPerSeq(annotFile string) (map[string]int, error) {
+	counts := map[string]int{}
+	err := forEachLine(annotFile, func(line string) error {
+		if !strings.HasPrefix(line, "#") && len(strings.TrimSpace(line)) > 0 {
+			seqID, _, _ := strings.Cut(line, "\t")
+			counts[seqID]++
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// Writes a samtools-compatible FASTA index of a FASTA file that has each
+// sequence on one line
+func writeFastaIndex(stats []seqStats, outfile string) (err error) {
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	for _, s := range stats {
+		lineWidth := s.length + 1
+		if s.length == 0 {
+			lineWidth = 0
+		}
+		if _, err := fmt.Fprintf(fout, "%v\t%d\t%d\t%d\t%d\n", s.name, s.length, s.offset, s.length, lineWidth); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", outfile, err)
+		}
+	}
+	return nil
+}
+
+// Writes a TSV file with one line per sequence of: name, length, GC
+// percent, number of Ns, number of gaps, number of features
+func writeSeqSummary(stats []seqStats, outfile string) (err error) {
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	if _, err := fout.WriteString("name\tlength\tgc_percent\tn_count\tgaps\tfeatures\n"); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", outfile, err)
+	}
+	for _, s := range stats {
+		if _, err := fmt.Fprintf(fout, "%v\t%d\t%.2f\t%d\t%d\t%d\n", s.name, s.length, s.gcPercent(), s.nCount, s.gaps, s.features); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", outfile, err)
+		}
+	}
+	return nil
+}
+
+// Writes a FASTA index (.fai) and a summary TSV file for an imported
+// FASTA file. The feature counts are taken from annotFile, if it exists,
+// and do not include the gaps
+func writeIndexAndSummary(fastaFile string, annotFile string, gaps []Gap, faiFile string, summaryFile string) error {
+	stats, err := getSeqStatsFromSingleLineFasta(fastaFile)
+	if err != nil {
+		return err
+	}
+	features := map[string]int{}
+	if utils.FileExists(annotFile) {
+		features, err = countFeaturesPerSeq(annotFile)
+		if err != nil {
+			return err
+		}
+	}
+	gapCounts := map[string]int{}
+	for _, gap := range gaps {
+		gapCounts[gap.SeqName]++
+	}
+	for i := range stats {
+		stats[i].gaps = gapCounts[stats[i].name]
+		stats[i].features = features[stats[i].name]
+	}
+	if err := writeFastaIndex(stats, faiFile); err != nil {
+		return err
+	}
+	return writeSeqSummary(stats, summaryFile)
+}
@@ -0,0 +1,394 @@
+package seqfiles
+
+import (
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Genetic code used to translate CDS features that do not have a
+// transl_table attribute, when no other code is chosen
+const DefaultGeneticCode = 11
+
+// An NCBI genetic code, in the same form as the NCBI gc.prt file. The
+// amino acid of each codon, and whether it is a start codon, are in
+// order TTT, TTC, TTA, TTG, TCT, ..., GGG
+type geneticCode struct {
+	name   string
+	aas    string
+	starts string
+}
+
+var geneticCodes = map[int]geneticCode{
+	1: {"Standard",
+		"FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"---M------**--*----M---------------M----------------------------"},
+	2: {"Vertebrate Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSS**VVVVAAAADDEEGGGG",
+		"----------**--------------------MMMM----------**---M------------"},
+	3: {"Yeast Mitochondrial",
+		"FFLLSSSSYY**CCWWTTTTPPPPHHQQRRRRIIMMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------**----------------------MM---------------M------------"},
+	4: {"Mold, Protozoan, and Coelenterate Mitochondrial and Mycoplasma/Spiroplasma",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--MM------**-------M------------MMMM---------------M------------"},
+	5: {"Invertebrate Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSSSVVVVAAAADDEEGGGG",
+		"---M------**--------------------MMMM---------------M------------"},
+	6: {"Ciliate, Dasycladacean and Hexamita Nuclear",
+		"FFLLSSSSYYQQCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--------------*--------------------M----------------------------"},
+	9: {"Echinoderm and Flatworm Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNNKSSSSVVVVAAAADDEEGGGG",
+		"----------**-----------------------M---------------M------------"},
+	10: {"Euplotid Nuclear",
+		"FFLLSSSSYY**CCCWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------**-----------------------M----------------------------"},
+	11: {"Bacterial, Archaeal and Plant Plastid",
+		"FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"---M------**--*----M------------MMMM---------------M------------"},
+	12: {"Alternative Yeast Nuclear",
+		"FFLLSSSSYY**CC*WLLLSPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------**--*----M---------------M----------------------------"},
+	13: {"Ascidian Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSGGVVVVAAAADDEEGGGG",
+		"---M------**----------------------MM---------------M------------"},
+	14: {"Alternative Flatworm Mitochondrial",
+		"FFLLSSSSYYY*CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNNKSSSSVVVVAAAADDEEGGGG",
+		"-----------*-----------------------M----------------------------"},
+	15: {"Blepharisma Macronuclear",
+		"FFLLSSSSYY*QCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------*---*--------------------M----------------------------"},
+	16: {"Chlorophycean Mitochondrial",
+		"FFLLSSSSYY*LCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------*---*--------------------M----------------------------"},
+	21: {"Trematode Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNNKSSSSVVVVAAAADDEEGGGG",
+		"----------**-----------------------M---------------M------------"},
+	22: {"Scenedesmus obliquus Mitochondrial",
+		"FFLLSS*SYY*LCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"------*---*---*--------------------M----------------------------"},
+	23: {"Thraustochytrium Mitochondrial",
+		"FF*LSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--*-------**--*-----------------M--M---------------M------------"},
+	24: {"Rhabdopleuridae Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSSKVVVVAAAADDEEGGGG",
+		"---M------**-------M---------------M---------------M------------"},
+	25: {"Candidate Division SR1 and Gracilibacteria",
+		"FFLLSSSSYY**CCGWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"---M------**-----------------------M---------------M------------"},
+	26: {"Pachysolen tannophilus Nuclear",
+		"FFLLSSSSYY**CC*WLLLAPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------**--*----M---------------M----------------------------"},
+	27: {"Karyorelict Nuclear",
+		"FFLLSSSSYYQQCCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--------------*--------------------M----------------------------"},
+	28: {"Condylostoma Nuclear",
+		"FFLLSSSSYYQQCCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------**--*--------------------M----------------------------"},
+	29: {"Mesodinium Nuclear",
+		"FFLLSSSSYYYYCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--------------*--------------------M----------------------------"},
+	30: {"Peritrich Nuclear",
+		"FFLLSSSSYYEECC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--------------*--------------------M----------------------------"},
+	31: {"Blastocrithidia Nuclear",
+		"FFLLSSSSYYEECCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------**-----------------------M----------------------------"},
+	32: {"Balanophoraceae Plastid",
+		"FFLLSSSSYY*WCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"---M------*---*----M------------MMMM---------------M------------"},
+	33: {"Cephalodiscidae Mitochondrial",
+		"FFLLSSSSYYY*CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSSKVVVVAAAADDEEGGGG",
+		"---M-------*-------M---------------M---------------M------------"},
+}
+
+func checkGeneticCode(code int) error {
+	if _, exists := geneticCodes[code]; exists {
+		return nil
+	}
+	codes := []int{}
+	for c := range geneticCodes {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+	return fmt.Errorf("unknown genetic code %d. Must be one of: %v", code, strings.Trim(fmt.Sprint(codes), "[]"))
+}
+
+// Returns the index of a codon in the genetic code strings, or -1 if it
+// has a base that is not A, C, G, T or U
+func codonIndex(codon string) int {
+	index := 0
+	for i := 0; i < 3; i++ {
+		index *= 4
+		switch codon[i] {
+		case 'T', 't', 'U', 'u':
+		case 'C', 'c':
+			index += 1
+		case 'A', 'a':
+			index += 2
+		case 'G', 'g':
+			index += 3
+		default:
+			return -1
+		}
+	}
+	return index
+}
+
+// Translates a coding sequence. Any bases at the end that are not a
+// whole codon are ignored, and codons with ambiguous bases are X. If
+// hasStart is true, the first codon is translated as M if it is a start
+// codon. A stop codon at the end is not included
+func (g *geneticCode) translate(seq string, hasStart bool) string {
+	protein := make([]byte, 0, len(seq)/3)
+	for i := 0; i+3 <= len(seq); i += 3 {
+		index := codonIndex(seq[i : i+3])
+		if index < 0 {
+			protein = append(protein, 'X')
+		} else if i == 0 && hasStart && g.starts[index] == 'M' {
+			protein = append(protein, 'M')
+		} else {
+			protein = append(protein, g.aas[index])
+		}
+	}
+	return strings.TrimSuffix(string(protein), "*")
+}
+
+// A CDS made of one or more GFF3 features with the same ID, which are in
+// the order they are joined
+type cdsFeature struct {
+	name     string
+	segments []Feature
+}
+
+// Returns the CDS features of a record, grouping features with the same
+// ID. The parts of CDS features are put in the order they are joined,
+// which is by position (descending on the - strand), unless the parts are
+// on different strands or span the origin of a circular sequence. In
+// those cases, the order in the file is used
+func recordCDSFeatures(record *SeqRecord) []cdsFeature {
+	cdss := []cdsFeature{}
+	indexes := map[string]int{}
+	for _, f := range record.Features {
+		if f.Type != "CDS" {
+			continue
+		}
+		id := f.GetAttribute("ID")
+		if i, exists := indexes[id]; exists && id != "" {
+			cdss[i].segments = append(cdss[i].segments, f)
+			continue
+		}
+		name := id
+		if name == "" {
+			name = fmt.Sprintf("%v:%d-%d", f.SeqID, f.Start, f.End)
+		} else {
+			indexes[id] = len(cdss)
+		}
+		cdss = append(cdss, cdsFeature{name: name, segments: []Feature{f}})
+	}
+
+	for _, cds := range cdss {
+		sameStrand := true
+		for _, s := range cds.segments {
+			sameStrand = sameStrand && s.Strand == cds.segments[0].Strand
+		}
+		if sameStrand && !(record.Circular && cds.spansOrigin(len(record.Seq))) {
+			reverse := cds.segments[0].Strand == "-"
+			sort.SliceStable(cds.segments, func(i, j int) bool {
+				return (cds.segments[i].Start < cds.segments[j].Start) != reverse
+			})
+		}
+	}
+	return cdss
+}
+
+// Returns true if, in file order, a part that ends at the end of the
+// sequence is next to a part that starts at position 1. These are joined
+// across the origin of a circular sequence
+func (c *cdsFeature) spansOrigin(seqLength int) bool {
+	for i := 1; i < len(c.segments); i++ {
+		previous := c.segments[i-1]
+		current := c.segments[i]
+		if (previous.End == seqLength && current.Start == 1) || (previous.Start == 1 && current.End == seqLength) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the number of bases before the first codon: the GFF3 phase of
+// the first part, or the codon_start attribute (from genbank/EMBL) minus
+// one if there is no phase
+func (c *cdsFeature) phase() int {
+	phase, err := strconv.Atoi(c.segments[0].Phase)
+	if err != nil {
+		phase, err = strconv.Atoi(c.segments[0].GetAttribute("codon_start"))
+		phase--
+	}
+	if err != nil || phase < 0 || phase > 2 {
+		return 0
+	}
+	return phase
+}
+
+// Returns true if the 5' end of the CDS is missing, ie its start is
+// partial on the + strand, or its end is partial on the - strand
+func (c *cdsFeature) partial5() bool {
+	first := c.segments[0]
+	if first.Strand == "-" {
+		return first.GetAttribute("end_range") != ""
+	}
+	return first.GetAttribute("start_range") != ""
+}
+
+// Returns the coding sequence of the CDS from the sequence it is on,
+// starting at the first codon
+func (c *cdsFeature) codingSeq(seq string) string {
+	var coding strings.Builder
+	for _, s := range c.segments {
+		if s.Start < 1 || s.End > len(seq) || s.End < s.Start {
+			continue
+		}
+		if s.Strand == "-" {
+			coding.Write(utils.ReverseComplement([]byte(seq[s.Start-1 : s.End])))
+		} else {
+			coding.WriteString(seq[s.Start-1 : s.End])
+		}
+	}
+	return coding.String()[min(c.phase(), coding.Len()):]
+}
+
+// Returns the FASTA header of the protein of a CDS, eg
+// "gene1.CDS Contig1:42-60(+) transl_table=11 internal_stops=1
+// translation=mismatch". internal_stops is only added if there are any,
+// and translation only if the CDS has a translation attribute
+func (c *cdsFeature) proteinHeader(code int, internalStops int, translation string) string {
+	start := c.segments[0].Start
+	end := c.segments[0].End
+	for _, s := range c.segments[1:] {
+		start = min(start, s.Start)
+		end = max(end, s.End)
+	}
+	header := fmt.Sprintf(">%v %v:%d-%d(%v) transl_table=%d", c.name, c.segments[0].SeqID, start, end, c.segments[0].Strand, code)
+	if internalStops > 0 {
+		header += fmt.Sprintf(" internal_stops=%d", internalStops)
+	}
+	if translation != "" {
+		header += " translation=" + translation
+	}
+	return header
+}
+
+// Returns the genetic code that was used for CDS features without a
+// transl_table attribute in a proteins file made by writeProteins, which
+// is found from the transl_table in the FASTA headers of their proteins.
+// Returns DefaultGeneticCode if there are no such proteins
+func proteinsDefaultGeneticCode(proteinsFile string, records []*SeqRecord) (int, error) {
+	codes := map[string]int{}
+	err := forEachLine(proteinsFile, func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], ">") {
+			return nil
+		}
+		for _, field := range fields[1:] {
+			if table, isTable := strings.CutPrefix(field, "transl_table="); isTable {
+				code, err := strconv.Atoi(table)
+				if err != nil {
+					return fmt.Errorf("%w: bad transl_table in file %v: %v", ErrBadFormat, proteinsFile, strings.TrimSpace(line))
+				}
+				codes[strings.TrimPrefix(fields[0], ">")] = code
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		for _, cds := range recordCDSFeatures(record) {
+			if code, exists := codes[cds.name]; exists && cds.segments[0].GetAttribute("transl_table") == "" {
+				return code, nil
+			}
+		}
+	}
+	return DefaultGeneticCode, nil
+}
+
+// Writes the translation of every CDS feature in annotFile to outfile,
+// using the sequences in fastaFile. Each CDS is translated with the
+// genetic code in its transl_table attribute, or defaultCode if it does
+// not have one. Proteins with internal stop codons, or that are different
+// from the translation attribute of the CDS, are marked in their FASTA
+// header and counted in a warning. If there are no CDS features, outfile
+// is not written, and is deleted if it exists
+func writeProteins(fastaFile string, annotFile string, outfile string, defaultCode int) (err error) {
+	if err := utils.DeleteFileIfExists(outfile); err != nil {
+		return err
+	}
+	if !utils.FileExists(annotFile) {
+		return nil
+	}
+	records, err := readSeqsAndAnnotation(fastaFile, annotFile)
+	if err != nil {
+		return err
+	}
+
+	var fout *xopen.Writer
+	withStops := 0
+	mismatches := 0
+	for _, record := range records {
+		if record.Seq == "" {
+			continue
+		}
+		for _, cds := range recordCDSFeatures(record) {
+			code := defaultCode
+			if table := cds.segments[0].GetAttribute("transl_table"); table != "" {
+				code, err = strconv.Atoi(table)
+				if err == nil {
+					err = checkGeneticCode(code)
+				}
+				if err != nil {
+					log.Printf("Warning: CDS %v has transl_table %v, which is not known. Using genetic code %d", cds.name, table, defaultCode)
+					code = defaultCode
+				}
+			}
+			g := geneticCodes[code]
+			protein := g.translate(cds.codingSeq(record.Seq), !cds.partial5() && cds.phase() == 0)
+			internalStops := strings.Count(protein, "*")
+			if internalStops > 0 {
+				withStops++
+			}
+			translation := ""
+			if expect := cds.segments[0].GetAttribute("translation"); expect != "" {
+				translation = "match"
+				if expect != protein {
+					translation = "mismatch"
+					mismatches++
+				}
+			}
+
+			if fout == nil {
+				fout, err = xopen.Wopen(outfile)
+				if err != nil {
+					return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+				}
+				defer closeWriter(fout, outfile, &err)
+			}
+			if _, err := fout.WriteString(cds.proteinHeader(code, internalStops, translation) + "\n" + protein + "\n"); err != nil {
+				return fmt.Errorf("error writing to file %v: %w", outfile, err)
+			}
+		}
+	}
+	if withStops > 0 {
+		log.Printf("Warning: %d CDS feature(s) have internal stop codons. They are marked with internal_stops in %v", withStops, outfile)
+	}
+	if mismatches > 0 {
+		log.Printf("Warning: %d CDS feature(s) translate differently to their translation attribute. They are marked with translation=mismatch in %v", mismatches, outfile)
+	}
+	return nil
+}
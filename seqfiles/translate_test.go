@@ -0,0 +1,110 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/udhos/equalfile"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	standard := geneticCodes[1]
+	bacterial := geneticCodes[11]
+	mycoplasma := geneticCodes[4]
+	require.Equal(t, "MK", standard.translate("ATGAAATAA", true), "Wrong translation")
+	require.Equal(t, "MK", standard.translate("atgaaataaCG", true), "Wrong translation of lower case with extra bases")
+	require.Equal(t, "M*KX", standard.translate("ATGTGAAAANCG", true), "Wrong translation with internal stop and N")
+	require.Equal(t, "VK", standard.translate("GTGAAA", true), "GTG is not a start codon in table 1")
+	require.Equal(t, "MK", bacterial.translate("GTGAAA", true), "GTG is a start codon in table 11")
+	require.Equal(t, "VK", bacterial.translate("GTGAAA", false), "Wrong translation without a start codon")
+	require.Equal(t, "MW", mycoplasma.translate("ATGTGA", true), "TGA is W in table 4")
+	trematode := geneticCodes[21]
+	require.Equal(t, "MMSN", trematode.translate("GTGATAAGAAAATAA", true), "Wrong translation in table 21")
+	rhabdopleuridae := geneticCodes[24]
+	require.Equal(t, "MSKW", rhabdopleuridae.translate("CTGAGAAGGTGATAG", true), "Wrong translation in table 24")
+	for _, code := range []int{1, 2, 3, 4, 5, 6, 9, 10, 11, 12, 13, 14, 15, 16, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33} {
+		require.NoError(t, checkGeneticCode(code), "Genetic code %d should be known", code)
+	}
+	require.Error(t, checkGeneticCode(7), "Genetic code 7 should not be known")
+}
+
+func TestRecordCDSFeatures(t *testing.T) {
+	record := &SeqRecord{Name: "seq1", Seq: "AACCCATGGGTTT", Features: []Feature{
+		{SeqID: "seq1", Type: "CDS", Start: 1, End: 4, Strand: "-", Phase: ".", Attributes: []Attribute{{Key: "ID", Values: []string{"cds1"}}}},
+		{SeqID: "seq1", Type: "gene", Start: 1, End: 13, Strand: "-", Phase: "."},
+		{SeqID: "seq1", Type: "CDS", Start: 9, End: 13, Strand: "-", Phase: ".", Attributes: []Attribute{{Key: "ID", Values: []string{"cds1"}}, {Key: "codon_start", Values: []string{"2"}}}},
+		{SeqID: "seq1", Type: "CDS", Start: 5, End: 7, Strand: "+", Phase: "."},
+	}}
+	cdss := recordCDSFeatures(record)
+	require.Equal(t, 2, len(cdss), "Wrong number of CDS features")
+	require.Equal(t, "cds1", cdss[0].name, "Wrong CDS name")
+	require.Equal(t, 9, cdss[0].segments[0].Start, "CDS parts should be in the order they are joined")
+	require.Equal(t, 1, cdss[0].phase(), "Wrong phase from codon_start")
+	require.Equal(t, "AACCGGTT", cdss[0].codingSeq(record.Seq), "Wrong coding sequence")
+	require.Equal(t, "seq1:5-7", cdss[1].name, "Wrong name of CDS without an ID")
+	require.Equal(t, "CAT", cdss[1].codingSeq(record.Seq), "Wrong coding sequence")
+
+	// the parts of a CDS on a circular sequence are kept in file order,
+	// because it could span the origin
+	record.Circular = true
+	require.Equal(t, 1, recordCDSFeatures(record)[0].segments[0].Start, "CDS parts on circular sequence should be in file order")
+
+	// a spliced CDS on the - strand of a circular sequence, with its parts
+	// in ascending order in the file, does not span the origin, so its
+	// parts are joined in descending order
+	record = &SeqRecord{Name: "seq1", Seq: "AAGCCCATGTTTT", Circular: true, Features: []Feature{
+		{SeqID: "seq1", Type: "CDS", Start: 2, End: 4, Strand: "-", Phase: ".", Attributes: []Attribute{{Key: "ID", Values: []string{"cds1"}}}},
+		{SeqID: "seq1", Type: "CDS", Start: 7, End: 9, Strand: "-", Phase: ".", Attributes: []Attribute{{Key: "ID", Values: []string{"cds1"}}}},
+	}}
+	cdss = recordCDSFeatures(record)
+	require.Equal(t, 7, cdss[0].segments[0].Start, "CDS parts on circular sequence that do not span the origin should be sorted")
+	require.Equal(t, "CATGCT", cdss[0].codingSeq(record.Seq), "Wrong coding sequence")
+}
+
+func TestWriteProteins(t *testing.T) {
+	outfile := "tmp.test.writeProteins.faa"
+	fastaFile := filepath.Join("seqfiles_testdata", "translate.in.fa")
+	annotFile := filepath.Join("seqfiles_testdata", "translate.in.gff")
+	require.NoError(t, writeProteins(fastaFile, annotFile, outfile, 11), "Error writing proteins")
+	expectFile := filepath.Join("seqfiles_testdata", "translate.expect.faa")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outfile)
+	require.NoError(t, err, "Error comparing files %s, %s", expectFile, outfile)
+	require.True(t, filesEqual, "File %s expected contents incorrect", outfile)
+
+	require.NoError(t, writeProteins(fastaFile, "does_not_exist.gff", outfile, 11), "Error writing proteins with no annotation")
+	require.False(t, utils.FileExists(outfile), "Proteins file should be deleted when there are no CDS features")
+}
+
+func TestImportGenbankProteins(t *testing.T) {
+	// CDS features with a partial start and codon_start, on the - strand,
+	// and with a transl_table and a translation that does not match
+	outprefix := "tmp.test.importGenbankProteins"
+	require.NoError(t, ParseSeqFile(filepath.Join("seqfiles_testdata", "translate.in.gbk"), outprefix), "Error importing file")
+	expectFile := filepath.Join("seqfiles_testdata", "translate.expect.gbk.faa")
+	cmp := equalfile.New(nil, equalfile.Options{})
+	filesEqual, err := cmp.CompareFile(expectFile, outprefix+".faa")
+	require.NoError(t, err, "Error comparing files %s, %s", expectFile, outprefix+".faa")
+	require.True(t, filesEqual, "File %s expected contents incorrect", outprefix+".faa")
+	for _, suffix := range []string{".fa", ".gff", ".fa.fai", ".summary.tsv", ".annotation_problems.tsv", ".metadata.json", ".faa"} {
+		utils.DeleteFileIfExists(outprefix + suffix)
+	}
+}
+
+func TestProteinsDefaultGeneticCode(t *testing.T) {
+	proteinsFile := "tmp.test.proteinsDefaultGeneticCode.faa"
+	require.NoError(t, os.WriteFile(proteinsFile, []byte(">cds1 seq1:1-6(+) transl_table=11\nM\n>cds2 seq1:7-12(+) transl_table=4\nM\n"), 0644), "Error writing file %v", proteinsFile)
+	records := []*SeqRecord{{Name: "seq1", Seq: "ATGTAAATGTAA", Features: []Feature{
+		{SeqID: "seq1", Type: "CDS", Start: 1, End: 6, Strand: "+", Phase: "0", Attributes: []Attribute{{Key: "ID", Values: []string{"cds1"}}, {Key: "transl_table", Values: []string{"11"}}}},
+		{SeqID: "seq1", Type: "CDS", Start: 7, End: 12, Strand: "+", Phase: "0", Attributes: []Attribute{{Key: "ID", Values: []string{"cds2"}}}},
+	}}}
+	code, err := proteinsDefaultGeneticCode(proteinsFile, records)
+	require.NoError(t, err, "Error getting genetic code")
+	require.Equal(t, 4, code, "Wrong genetic code of CDS without transl_table")
+	code, err = proteinsDefaultGeneticCode(proteinsFile, records[:0])
+	require.NoError(t, err, "Error getting genetic code")
+	require.Equal(t, DefaultGeneticCode, code, "Should get default genetic code when no proteins match")
+	utils.DeleteFileIfExists(proteinsFile)
+}
@@ -0,0 +1,274 @@
+package seqfiles
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/shenwei356/xopen"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// FastaWriter writes SeqRecords to a FASTA file, with each sequence on
+// one line
+type FastaWriter struct {
+	filename string
+	writer   *xopen.Writer
+}
+
+func NewFastaWriter(filename string) (*FastaWriter, error) {
+	writer, err := xopen.Wopen(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for writing %v: %w", filename, err)
+	}
+	return &FastaWriter{filename: filename, writer: writer}, nil
+}
+
+func (w *FastaWriter) Write(record *SeqRecord) error {
+	// the parts are written separately, to not make a copy of the sequence
+	for _, part := range []string{">", record.Name, "\n", record.Seq, "\n"} {
+		if _, err := w.writer.WriteString(part); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", w.filename, err)
+		}
+	}
+	return nil
+}
+
+func (w *FastaWriter) Close() error {
+	return w.writer.Close()
+}
+
+// GFF3Writer writes the annotation of SeqRecords to a GFF3 file
+type GFF3Writer struct {
+	filename string
+	writer   *xopen.Writer
+}
+
+// NewGFF3Writer opens a file for writing and writes the GFF3 header line
+func NewGFF3Writer(filename string) (*GFF3Writer, error) {
+	writer, err := xopen.Wopen(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file for writing %v: %w", filename, err)
+	}
+	if _, err := writer.WriteString("##gff-version 3\n"); err != nil {
+		return nil, fmt.Errorf("error writing to file %v: %w", filename, err)
+	}
+	return &GFF3Writer{filename: filename, writer: writer}, nil
+}
+
+// Write writes a ##sequence-region pragma if the record has a
+// RegionLength, followed by the features of the record
+func (w *GFF3Writer) Write(record *SeqRecord) error {
+	var lines strings.Builder
+	if record.RegionLength > 0 {
+		lines.WriteString(fmt.Sprintf("##sequence-region %v 1 %d\n", record.Name, record.RegionLength))
+	}
+	for _, feature := range record.Features {
+		lines.WriteString(feature.GFF3Line())
+	}
+	if _, err := w.writer.WriteString(lines.String()); err != nil {
+		return fmt.Errorf("error writing to file %v: %w", w.filename, err)
+	}
+	return nil
+}
+
+func (w *GFF3Writer) Close() error {
+	return w.writer.Close()
+}
+
+// Reads all the records from infile, and writes their sequences to
+// outfileSeqs, and their annotation to outfileAnnot. Either output file
+// can be "" to not write it. Records without a sequence are not written
+// to outfileSeqs. Sequences are written in upper case, unless keepCase
+// is true. Returns the metadata of genbank and EMBL records, whose names
+// are chosen by nameSource
+func convertSeqFile(infile string, format FileFormat, outfileSeqs string, outfileAnnot string, strictGFF3 bool, keepCase bool, nameSource SeqNameSource) (metadata []SeqMetadata, err error) {
+	reader, err := newReaderWithFormat(infile, format)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	reader.StrictGFF3 = strictGFF3
+	reader.NameSource = nameSource
+
+	var fastaWriter *FastaWriter
+	if outfileSeqs != "" {
+		fastaWriter, err = NewFastaWriter(outfileSeqs)
+		if err != nil {
+			return nil, err
+		}
+		defer closeWriter(fastaWriter, outfileSeqs, &err)
+	}
+	var gff3Writer *GFF3Writer
+	if outfileAnnot != "" {
+		gff3Writer, err = NewGFF3Writer(outfileAnnot)
+		if err != nil {
+			return nil, err
+		}
+		defer closeWriter(gff3Writer, outfileAnnot, &err)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return metadata, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if record.Metadata != nil {
+			metadata = append(metadata, *record.Metadata)
+		}
+
+		if fastaWriter != nil {
+			if record.Seq == "" {
+				// Genbank/EMBL records without a sequence are dealt with
+				// after importing, using their metadata
+				if record.Metadata == nil {
+					log.Printf("Warning: no sequence found for %v in file %v", record.Name, infile)
+				}
+			} else {
+				if !keepCase {
+					record.Seq = strings.ToUpper(record.Seq)
+				}
+				if err := fastaWriter.Write(record); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if gff3Writer != nil {
+			if err := gff3Writer.Write(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Converts a FASTA file to outfile in the same way as convertSeqFile,
+// except that sequence lines are read and written in pieces, so that
+// memory use does not depend on the length of the sequences
+func convertFastaFile(infile string, outfile string, keepCase bool) (err error) {
+	fout, err := xopen.Wopen(outfile)
+	if err != nil {
+		return fmt.Errorf("error opening file for writing %v: %w", outfile, err)
+	}
+	defer closeWriter(fout, outfile, &err)
+	write := func(b []byte) error {
+		if _, err := fout.Write(b); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", outfile, err)
+		}
+		return nil
+	}
+
+	// Name of the current record, which is "" before the first header.
+	// Its header is not written until some sequence is found
+	name := ""
+	hasSeq := false
+	endRecord := func() error {
+		if name == "" {
+			return nil
+		} else if !hasSeq {
+			log.Printf("Warning: no sequence found for %v in file %v", name, infile)
+			return nil
+		}
+		return write([]byte("\n"))
+	}
+
+	lineNumber := 0
+	// Header lines, and lines before the first header, are kept whole
+	var line []byte
+	keepLine := false
+	isComment := false
+	// Whitespace in a sequence line is only written if more sequence
+	// follows it on the same line
+	lineHasSeq := false
+	var space []byte
+	const whitespace = " \t\r\n\v\f"
+
+	err = forEachLinePiece(infile, func(piece []byte, lineStart bool, lineEnd bool) error {
+		if lineStart {
+			lineNumber++
+			keepLine = name == "" || piece[0] == '>'
+			isComment = piece[0] == '#'
+			line = line[:0]
+			lineHasSeq = false
+			space = space[:0]
+		}
+		if keepLine {
+			line = append(line, piece...)
+			if !lineEnd {
+				return nil
+			}
+			header := strings.TrimRight(string(line), "\r\n")
+			if name == "" && (len(strings.TrimSpace(header)) == 0 || isComment) {
+				return nil
+			}
+			fields := strings.Fields(strings.TrimPrefix(header, ">"))
+			if !strings.HasPrefix(header, ">") || len(fields) == 0 {
+				return fmt.Errorf("%w: expected a FASTA header line starting with '>' followed by a name in file %v, line %d, but got: %v", ErrBadFormat, infile, lineNumber, header)
+			}
+			if err := endRecord(); err != nil {
+				return err
+			}
+			name = fields[0]
+			hasSeq = false
+			return nil
+		} else if isComment {
+			return nil
+		}
+
+		if !lineHasSeq {
+			piece = bytes.TrimLeft(piece, whitespace)
+		}
+		seq := bytes.TrimRight(piece, whitespace)
+		if len(seq) == 0 {
+			space = append(space, piece...)
+			return nil
+		}
+		if !hasSeq {
+			if err := write([]byte(">" + name + "\n")); err != nil {
+				return err
+			}
+			hasSeq = true
+		}
+		if !keepCase {
+			seq = bytes.ToUpper(seq)
+		}
+		if err := write(space); err != nil {
+			return err
+		}
+		if err := write(seq); err != nil {
+			return err
+		}
+		lineHasSeq = true
+		space = append(space[:0], piece[len(seq):]...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return endRecord()
+}
+
+// Adds features to the end of a GFF3 file. The file is made if it does
+// not exist
+func appendFeaturesToAnnotFile(features []Feature, filename string) (err error) {
+	annotFileExists := utils.FileExists(filename)
+	fout, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file %v: %w", filename, err)
+	}
+	defer closeWriter(fout, filename, &err)
+	if !annotFileExists {
+		if _, err := fout.WriteString("##gff-version 3\n"); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", filename, err)
+		}
+	}
+	for _, feature := range features {
+		if _, err := fout.WriteString(feature.GFF3Line()); err != nil {
+			return fmt.Errorf("error writing to file %v: %w", filename, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package seqfiles
+
+import (
+	"github.com/martinghunt/tnahelper/utils"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFastaAndGFF3Writers(t *testing.T) {
+	records := []*SeqRecord{
+		{Name: "seq1", Seq: "ACGTacgt", RegionLength: 8, Features: []Feature{
+			{SeqID: "seq1", Source: ".", Type: "gene", Start: 2, End: 5, Score: ".", Strand: "+", Phase: ".", Attributes: []Attribute{{Key: "ID", Values: []string{"g;1"}}}},
+		}},
+		{Name: "seq2", Seq: "AAA"},
+	}
+	fastaFile := "tmp.test.writers.fa"
+	gffFile := "tmp.test.writers.gff"
+	fastaWriter, err := NewFastaWriter(fastaFile)
+	require.NoError(t, err, "Error opening FASTA writer")
+	gff3Writer, err := NewGFF3Writer(gffFile)
+	require.NoError(t, err, "Error opening GFF3 writer")
+	for _, record := range records {
+		require.NoError(t, fastaWriter.Write(record), "Error writing FASTA record")
+		require.NoError(t, gff3Writer.Write(record), "Error writing GFF3 record")
+	}
+	require.NoError(t, fastaWriter.Close(), "Error closing FASTA writer")
+	require.NoError(t, gff3Writer.Close(), "Error closing GFF3 writer")
+
+	got, err := os.ReadFile(fastaFile)
+	require.NoError(t, err, "Error reading file %v", fastaFile)
+	require.Equal(t, ">seq1\nACGTacgt\n>seq2\nAAA\n", string(got), "Wrong FASTA file contents")
+	got, err = os.ReadFile(gffFile)
+	require.NoError(t, err, "Error reading file %v", gffFile)
+	require.Equal(t, "##gff-version 3\n##sequence-region seq1 1 8\nseq1\t.\tgene\t2\t5\t.\t+\t.\tID=g%3B1\n", string(got), "Wrong GFF3 file contents")
+	utils.DeleteFileIfExists(fastaFile)
+	utils.DeleteFileIfExists(gffFile)
+}
+
+func TestConvertFastaFileInChunks(t *testing.T) {
+	// blank and comment lines before the first header and inside records,
+	// whitespace around sequence lines that crosses chunk boundaries,
+	// windows line endings, a header longer than a chunk, a record with
+	// no sequence, and no newline at the end of the file
+	fasta := utf8BOM + "\n# comment\n" +
+		">S1 a description that is longer than one chunk\r\n" +
+		"acgt" + strings.Repeat(" ", 20) + "ACGT\r\n" +
+		"   " + strings.Repeat("n", 30) + strings.Repeat(" ", 20) + "\r\n" +
+		"# comment\n\n" +
+		">empty\n" + strings.Repeat(" ", 40) + "\n" +
+		">S2\n TTaa"
+	infile := "tmp.test.convertFastaFile.in.fa"
+	outfile := "tmp.test.convertFastaFile.out.fa"
+	require.NoError(t, os.WriteFile(infile, []byte(fasta), 0644), "Error writing file")
+	expect := ">S1\nacgt" + strings.Repeat(" ", 20) + "ACGT" + strings.Repeat("n", 30) + "\n>S2\nTTaa\n"
+	defer func(chunkSize int) { readChunkSize = chunkSize }(readChunkSize)
+
+	for _, chunkSize := range []int{16, 17, 1 << 20} {
+		readChunkSize = chunkSize
+		for _, keepCase := range []bool{true, false} {
+			require.NoError(t, convertFastaFile(infile, outfile, keepCase), "Error converting FASTA file")
+			got, err := os.ReadFile(outfile)
+			require.NoError(t, err, "Error reading file %v", outfile)
+			want := expect
+			if !keepCase {
+				want = strings.ToUpper(expect)
+			}
+			require.Equal(t, want, string(got), "Wrong FASTA file with chunk size %d and keepCase %v", chunkSize, keepCase)
+
+			// same as reading whole records
+			_, err = convertSeqFile(infile, FASTA, outfile, "", false, keepCase, NameFromLocus)
+			require.NoError(t, err, "Error converting FASTA file")
+			got, err = os.ReadFile(outfile)
+			require.NoError(t, err, "Error reading file %v", outfile)
+			require.Equal(t, want, string(got), "Wrong FASTA file from reading whole records")
+		}
+	}
+
+	require.NoError(t, os.WriteFile(infile, []byte("\nACGT\n>seq1\nACGT\n"), 0644), "Error writing file")
+	err := convertFastaFile(infile, outfile, false)
+	require.ErrorIs(t, err, ErrBadFormat, "Should be error from sequence before first header")
+	require.Contains(t, err.Error(), "line 2, but got: ACGT", "Wrong error message")
+	utils.DeleteFileIfExists(infile)
+	utils.DeleteFileIfExists(outfile)
+}
@@ -1,9 +1,9 @@
 package utils
 
 import (
-	"io/ioutil"
-	"log"
+	"fmt"
 	"os"
+	"strings"
 )
 
 func FileExists(filename string) bool {
@@ -14,49 +14,62 @@ func FileExists(filename string) bool {
 	return err == nil
 }
 
-func DeleteFileIfExists(filename string) {
+func DeleteFileIfExists(filename string) error {
 	if FileExists(filename) {
 		err := os.Remove(filename)
 		if err != nil {
-			log.Fatalf("Error deleting file %v: %v", filename, err)
+			return fmt.Errorf("error deleting file %v: %w", filename, err)
 		}
 	}
+	return nil
 }
 
-func RenameFile(oldName string, newName string) {
+func RenameFile(oldName string, newName string) error {
 	err := os.Rename(oldName, newName)
 	if err != nil {
-		log.Fatalf("Error renaming file %s -> %s: %v", oldName, newName, err)
+		return fmt.Errorf("error renaming file %s -> %s: %w", oldName, newName, err)
 	}
+	return nil
 }
 
-func CopyFile(sourceFile string, destFile string) {
-	fin, err := ioutil.ReadFile(sourceFile)
+func CopyFile(sourceFile string, destFile string) error {
+	fin, err := os.ReadFile(sourceFile)
 	if err != nil {
-		log.Fatalf("Error opening file for copying: %v", err)
+		return fmt.Errorf("error opening file for copying %v: %w", sourceFile, err)
 	}
 
-	err = ioutil.WriteFile(destFile, fin, 0644)
+	err = os.WriteFile(destFile, fin, 0644)
 	if err != nil {
-		log.Fatalf("Error writing file: %v", err)
+		return fmt.Errorf("error writing file %v: %w", destFile, err)
 	}
+	return nil
 }
 
-func ReverseComplement(seq []byte) []byte {
-	revcomp := make([]byte, len(seq))
-
-	comp := map[byte]byte{
-		'A': 'T',
-		'T': 'A',
-		'C': 'G',
-		'G': 'C',
-		'N': 'N',
+var complement = func() [256]byte {
+	var comp [256]byte
+	for i := range comp {
+		comp[i] = byte(i)
+	}
+	pairs := []string{"AT", "CG", "UA", "RY", "KM", "BV", "DH"}
+	for _, pair := range pairs {
+		for _, p := range []string{pair, strings.ToLower(pair)} {
+			comp[p[0]] = p[1]
+			if p[0] != 'U' && p[0] != 'u' {
+				comp[p[1]] = p[0]
+			}
+		}
 	}
+	return comp
+}()
 
+// ReverseComplement returns the reverse complement of a nucleotide
+// sequence, keeping the case of each base. IUPAC ambiguity codes are
+// complemented, and any other characters (eg N, S, W, gaps) are unchanged
+func ReverseComplement(seq []byte) []byte {
+	revcomp := make([]byte, len(seq))
 	for i := 0; i < len(seq); i++ {
-		revcomp[len(seq)-1-i] = comp[seq[i]]
+		revcomp[len(seq)-1-i] = complement[seq[i]]
 	}
-
 	return revcomp
 }
 
@@ -24,7 +24,7 @@ func TestDeleteFileIfExists(t *testing.T) {
 	require.True(t, FileExists(outfile), "File should exist: %v", outfile)
 	DeleteFileIfExists(outfile)
 	require.False(t, FileExists(outfile), "File should not exist: %v", outfile)
-	DeleteFileIfExists(outfile) // run again, should not throw error
+	require.NoError(t, DeleteFileIfExists(outfile), "Error deleting file that does not exist")
 }
 
 func TestRenameFile(t *testing.T) {
@@ -36,7 +36,7 @@ func TestRenameFile(t *testing.T) {
 	require.Equal(t, err, nil, "Error opening test file for writing: %v", oldName)
 	file.Close()
 	require.True(t, FileExists(oldName), "File should exist: %v", oldName)
-	RenameFile(oldName, newName)
+	require.NoError(t, RenameFile(oldName, newName), "Error renaming file")
 	require.False(t, FileExists(oldName), "File should not exist: %v", oldName)
 	require.True(t, FileExists(newName), "File should exist: %v", newName)
 	DeleteFileIfExists(newName)
@@ -46,7 +46,7 @@ func TestCopyFile(t *testing.T) {
 	infile := filepath.Join("utils_testdata", "copyFile")
 	outfile := "tmp.test.CopyFile.out"
 	DeleteFileIfExists(outfile)
-	CopyFile(infile, outfile)
+	require.NoError(t, CopyFile(infile, outfile), "Error copying file")
 	require.True(t, FileExists(outfile), "File should exist: %v", outfile)
 	DeleteFileIfExists(outfile)
 }
@@ -58,6 +58,26 @@ func TestReverseComplement(t *testing.T) {
 	require.Equal(t, string(rev), string(expect), "Error reverse complement. Got: %s", rev)
 }
 
+func TestReverseComplementIUPACAndCase(t *testing.T) {
+	complements := map[byte]byte{
+		'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A', 'U': 'A',
+		'R': 'Y', 'Y': 'R', 'K': 'M', 'M': 'K', 'B': 'V', 'V': 'B', 'D': 'H', 'H': 'D',
+		'N': 'N', 'S': 'S', 'W': 'W', 'X': 'X', '-': '-', '*': '*',
+		'a': 't', 'c': 'g', 'g': 'c', 't': 'a', 'u': 'a',
+		'r': 'y', 'y': 'r', 'k': 'm', 'm': 'k', 'b': 'v', 'v': 'b', 'd': 'h', 'h': 'd',
+		'n': 'n', 's': 's', 'w': 'w', 'x': 'x',
+	}
+	for base, expect := range complements {
+		got := ReverseComplement([]byte{base})
+		require.Equal(t, string(expect), string(got), "Wrong complement of %c", base)
+	}
+
+	seq := "acGTnRYkmBDhvUsw-"
+	require.Equal(t, "-wsAbdHVkmRYnACgt", string(ReverseComplement([]byte(seq))), "Wrong reverse complement of %v", seq)
+	seq = "ACGTRYKMBDHVNacgtrykmbdhvn"
+	require.Equal(t, seq, string(ReverseComplement(ReverseComplement([]byte(seq)))), "Reverse complementing twice should give the original sequence")
+}
+
 func TestReverse(t *testing.T) {
 	fwd := "ABCDE"
 	rev := "EDCBA"